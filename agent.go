@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Limits that keep individual agent tool results small enough for the context window
+const (
+	agentMaxToolOutput   = 32 * 1024
+	agentMaxGrepMatches  = 100
+	agentMaxListEntries  = 500
+	agentMaxGrepFileSize = 1024 * 1024
+)
+
+// agentBudgetMessage asks the model for a final answer once the step or token budget is used up
+const agentBudgetMessage = "The tool budget for this request is exhausted. Answer now using the information gathered so far."
+
+// agentSkippedDirs are never listed or searched by agent tools
+var agentSkippedDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+}
+
+// AgentTraceEntry records one tool call made by the model in agent mode
+type AgentTraceEntry struct {
+	Step      int
+	Tool      string
+	Arguments string
+	Summary   string
+	Error     string
+}
+
+// agentTools returns the function-calling tool definitions offered to the model in agent mode
+func agentTools() []deepseek.Tool {
+	pathProperty := map[string]interface{}{
+		"type":        "string",
+		"description": "File or directory path, relative to the workspace root or absolute within it",
+	}
+
+	return []deepseek.Tool{
+		{
+			Type: "function",
+			Function: deepseek.Function{
+				Name:        "read_file",
+				Description: "Read a text file from the workspace. Lines are prefixed with their line numbers.",
+				Parameters: &deepseek.FunctionParameters{
+					Type: "object",
+					Properties: map[string]interface{}{
+						"path":       pathProperty,
+						"start_line": map[string]interface{}{"type": "integer", "description": "Optional first line to read (1-based)"},
+						"end_line":   map[string]interface{}{"type": "integer", "description": "Optional last line to read (inclusive)"},
+					},
+					Required: []string{"path"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: deepseek.Function{
+				Name:        "list_directory",
+				Description: "List the entries of a workspace directory with their type and size",
+				Parameters: &deepseek.FunctionParameters{
+					Type: "object",
+					Properties: map[string]interface{}{
+						"path": pathProperty,
+					},
+					Required: []string{"path"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: deepseek.Function{
+				Name:        "grep",
+				Description: "Search workspace files for a regular expression and return matching lines as path:line: text",
+				Parameters: &deepseek.FunctionParameters{
+					Type: "object",
+					Properties: map[string]interface{}{
+						"pattern": map[string]interface{}{"type": "string", "description": "Regular expression (Go RE2 syntax)"},
+						"path":    pathProperty,
+						"glob":    map[string]interface{}{"type": "string", "description": "Optional file name filter such as *.go"},
+					},
+					Required: []string{"pattern"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: deepseek.Function{
+				Name:        "file_outline",
+				Description: "List the functions, types and other top-level declarations of a source file with their line ranges",
+				Parameters: &deepseek.FunctionParameters{
+					Type: "object",
+					Properties: map[string]interface{}{
+						"path": pathProperty,
+					},
+					Required: []string{"path"},
+				},
+			},
+		},
+	}
+}
+
+// runAgent sends the request with the agent tools attached, executes the tool calls the model makes
+// and feeds the results back until the model answers or the step/token budget is exhausted.
+// The returned response carries the usage accumulated over all steps, and the stats cover the
+// API requests made for all steps, including retries.
+func (s *DeepseekServer) runAgent(ctx context.Context, request *deepseek.ChatCompletionRequest, progressToken interface{}) (*deepseek.ChatCompletionResponse, []AgentTraceEntry, RequestStats, error) {
+	logger := getLoggerFromContext(ctx)
+
+	agentRequest := *request
+	agentRequest.Tools = agentTools()
+	messages := append([]deepseek.ChatCompletionMessage(nil), request.Messages...)
+
+	var usage deepseek.Usage
+	var trace []AgentTraceEntry
+	var stats RequestStats
+
+	for step := 1; ; step++ {
+		budgetExhausted := step > s.config.AgentMaxSteps ||
+			(s.config.AgentMaxTokens > 0 && usage.TotalTokens >= s.config.AgentMaxTokens)
+		if budgetExhausted {
+			logger.Info("Agent budget exhausted after %d step(s) and %d tokens, requesting final answer", step-1, usage.TotalTokens)
+			agentRequest.Tools = nil
+			messages = append(messages, deepseek.ChatCompletionMessage{
+				Role:    deepseek.ChatMessageRoleUser,
+				Content: agentBudgetMessage,
+			})
+		}
+
+		agentRequest.Messages = messages
+		response, stepStats, err := s.executeDeepseekRequest(ctx, &agentRequest, nil)
+		stats.add(stepStats)
+		if err != nil {
+			return nil, trace, stats, fmt.Errorf("agent step %d failed: %w", step, err)
+		}
+		addUsage(&usage, response.Usage)
+
+		if budgetExhausted || len(response.Choices) == 0 || len(response.Choices[0].Message.ToolCalls) == 0 {
+			response.Usage = usage
+			return response, trace, stats, nil
+		}
+
+		reply := response.Choices[0].Message
+		messages = append(messages, deepseek.ChatCompletionMessage{
+			Role:      deepseek.ChatMessageRoleAssistant,
+			Content:   reply.Content,
+			ToolCalls: reply.ToolCalls,
+		})
+
+		for _, call := range reply.ToolCalls {
+			logger.Info("Agent step %d: %s(%s)", step, call.Function.Name, call.Function.Arguments)
+			if progressToken != nil && s.progress != nil {
+				if err := s.progress.NotifyProgress(progressToken, step, fmt.Sprintf("agent step %d: %s", step, call.Function.Name)); err != nil {
+					logger.Warn("Failed to send progress notification: %v", err)
+				}
+			}
+
+			output, err := s.executeAgentTool(call.Function.Name, call.Function.Arguments)
+			entry := AgentTraceEntry{
+				Step:      step,
+				Tool:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			}
+			if err != nil {
+				entry.Error = err.Error()
+				output = "Error: " + err.Error()
+			} else {
+				entry.Summary = fmt.Sprintf("%d bytes returned", len(output))
+			}
+			trace = append(trace, entry)
+
+			messages = append(messages, deepseek.ChatCompletionMessage{
+				Role:       deepseek.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Content:    output,
+			})
+		}
+	}
+}
+
+// addUsage adds the token counts of one API call to a running total
+func addUsage(total *deepseek.Usage, usage deepseek.Usage) {
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	total.PromptCacheHitTokens += usage.PromptCacheHitTokens
+	total.PromptCacheMissTokens += usage.PromptCacheMissTokens
+}
+
+// agentToolArgs holds the union of all agent tool arguments
+type agentToolArgs struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Pattern   string `json:"pattern"`
+	Glob      string `json:"glob"`
+}
+
+// executeAgentTool runs a single tool call inside the workspace and returns its textual result
+func (s *DeepseekServer) executeAgentTool(name string, rawArgs string) (string, error) {
+	var args agentToolArgs
+	if rawArgs != "" {
+		if err := json.Unmarshal([]byte(rawArgs), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments for %s: %w", name, err)
+		}
+	}
+
+	var output string
+	var err error
+	switch name {
+	case "read_file":
+		output, err = s.agentReadFile(args)
+	case "list_directory":
+		output, err = s.agentListDirectory(args)
+	case "grep":
+		output, err = s.agentGrep(args)
+	case "file_outline":
+		output, err = s.agentFileOutline(args)
+	default:
+		return "", fmt.Errorf("unknown tool: %s", name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return truncateToolOutput(output, agentMaxToolOutput), nil
+}
+
+// truncateToolOutput shortens output to at most max bytes, cutting after the last complete line
+// when there is one in the second half and otherwise at a UTF-8 character boundary
+func truncateToolOutput(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	cut := max
+	if newline := strings.LastIndexByte(output[:max], '\n'); newline >= max/2 {
+		cut = newline + 1
+	} else {
+		for cut > 0 && !utf8.RuneStart(output[cut]) {
+			cut--
+		}
+	}
+	return strings.TrimSuffix(output[:cut], "\n") + "\n[output truncated]"
+}
+
+// agentReadFile implements the read_file tool
+func (s *DeepseekServer) agentReadFile(args agentToolArgs) (string, error) {
+	path, err := s.workspace.Resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	content, err := s.validateAndReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	start, end := 1, len(lines)
+	if args.StartLine > 0 {
+		start = args.StartLine
+	}
+	if args.EndLine > 0 && args.EndLine < end {
+		end = args.EndLine
+	}
+	if start > end {
+		return "", fmt.Errorf("invalid line range %d-%d for %s (%d lines)", start, end, args.Path, len(lines))
+	}
+
+	var sb strings.Builder
+	for i := start; i <= end; i++ {
+		sb.WriteString(fmt.Sprintf("%6d  %s\n", i, lines[i-1]))
+	}
+	return sb.String(), nil
+}
+
+// agentListDirectory implements the list_directory tool
+func (s *DeepseekServer) agentListDirectory(args agentToolArgs) (string, error) {
+	if args.Path == "" {
+		args.Path = "."
+	}
+	path, err := s.workspace.Resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to list directory %s: %w", args.Path, err)
+	}
+
+	var sb strings.Builder
+	for i, entry := range entries {
+		if i >= agentMaxListEntries {
+			sb.WriteString(fmt.Sprintf("[%d more entries not shown]\n", len(entries)-i))
+			break
+		}
+		if entry.IsDir() {
+			sb.WriteString(entry.Name() + "/\n")
+			continue
+		}
+		size := int64(0)
+		if info, err := entry.Info(); err == nil {
+			size = info.Size()
+		}
+		sb.WriteString(fmt.Sprintf("%s (%s)\n", entry.Name(), humanReadableSize(size)))
+	}
+	if len(entries) == 0 {
+		sb.WriteString("(empty directory)\n")
+	}
+	return sb.String(), nil
+}
+
+// agentGrep implements the grep tool
+func (s *DeepseekServer) agentGrep(args agentToolArgs) (string, error) {
+	re, err := regexp.Compile(args.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	if args.Path == "" {
+		args.Path = "."
+	}
+	root, err := s.workspace.Resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	matches := 0
+	errLimit := errors.New("match limit reached")
+
+	// Files excluded by .gitignore and .deepseekignore are not searched, as in file_paths expansion
+	matcher := newIgnoreMatcher()
+	matcher.loadAncestors(root)
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && agentSkippedDirs[d.Name()] || matcher.ignored(path, true) {
+				return filepath.SkipDir
+			}
+			matcher.loadDir(path)
+			return nil
+		}
+		// Symlinks and special files are never followed; a link may point outside the workspace
+		if !d.Type().IsRegular() || isIgnoreFile(d.Name()) || matcher.ignored(path, false) {
+			return nil
+		}
+		if args.Glob != "" {
+			if ok, _ := filepath.Match(args.Glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		if info, err := d.Info(); err != nil || info.Size() > agentMaxGrepFileSize {
+			return nil
+		}
+		if _, err := ValidateFilePath(path, s.fileTypes, s.config.AllowedFileTypes, s.config.MaxFileSize); err != nil {
+			return nil
+		}
+
+		resolved, err := s.workspace.Resolve(path)
+		if err != nil {
+			return nil
+		}
+		content, err := os.ReadFile(resolved)
+		if err != nil || bytes.IndexByte(content, 0) >= 0 {
+			return nil
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 0, 64*1024), agentMaxGrepFileSize)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			if re.MatchString(scanner.Text()) {
+				sb.WriteString(fmt.Sprintf("%s:%d: %s\n", s.workspace.RelPath(path), lineNo, scanner.Text()))
+				matches++
+				if matches >= agentMaxGrepMatches {
+					return errLimit
+				}
+			}
+		}
+		return nil
+	})
+	if walkErr == errLimit {
+		sb.WriteString(fmt.Sprintf("[stopped after %d matches]\n", agentMaxGrepMatches))
+	}
+
+	if matches == 0 {
+		return "No matches found.", nil
+	}
+	return sb.String(), nil
+}
+
+// agentFileOutline implements the file_outline tool
+func (s *DeepseekServer) agentFileOutline(args agentToolArgs) (string, error) {
+	path, err := s.workspace.Resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	content, err := s.validateAndReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	symbols := fileOutline(path, content)
+	if len(symbols) == 0 {
+		return "No declarations found.", nil
+	}
+
+	var sb strings.Builder
+	for _, symbol := range symbols {
+		sb.WriteString(fmt.Sprintf("%s %s (lines %d-%d)\n", symbol.Kind, symbol.Name, symbol.StartLine, symbol.EndLine))
+	}
+	return sb.String(), nil
+}
+
+// formatAgentTrace renders the tool calls made in agent mode
+func formatAgentTrace(trace []AgentTraceEntry) string {
+	var sb strings.Builder
+	sb.WriteString("## Agent Trace\n\n")
+	if len(trace) == 0 {
+		sb.WriteString("*The model answered without calling any tools.*\n")
+		return sb.String()
+	}
+
+	sb.WriteString("| Step | Tool | Arguments | Result |\n")
+	sb.WriteString("|------|------|-----------|--------|\n")
+	for _, entry := range trace {
+		result := entry.Summary
+		if entry.Error != "" {
+			result = "error: " + entry.Error
+		}
+		sb.WriteString(fmt.Sprintf("| %d | %s | `%s` | %s |\n",
+			entry.Step, entry.Tool, strings.ReplaceAll(entry.Arguments, "|", "\\|"), strings.ReplaceAll(result, "|", "\\|")))
+	}
+	return sb.String()
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+const agentTestSource = "package x\n\nfunc A() int {\n\treturn 1\n}\n"
+
+// agentTestWorkspace creates a workspace holding a.go and sub/b.go
+func agentTestWorkspace(t *testing.T) *Workspace {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.go": agentTestSource, "sub/b.go": "package sub\n"} {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := NewWorkspace([]string{root}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+// agentResponse renders a chat completion calling the given tools, or answering if there are none
+func agentResponse(totalTokens int, calls ...deepseek.ToolCall) string {
+	message := deepseek.Message{Role: deepseek.ChatMessageRoleAssistant, ToolCalls: calls}
+	if len(calls) == 0 {
+		message.Content = "The answer."
+	}
+	data, _ := json.Marshal(deepseek.ChatCompletionResponse{
+		ID:      "r",
+		Choices: []deepseek.Choice{{Message: message}},
+		Usage:   deepseek.Usage{TotalTokens: totalTokens},
+	})
+	return string(data)
+}
+
+// agentCall creates a tool call of the named agent tool
+func agentCall(id, name, arguments string) deepseek.ToolCall {
+	return deepseek.ToolCall{ID: id, Type: "function", Function: deepseek.ToolCallFunction{Name: name, Arguments: arguments}}
+}
+
+// agentTestServer creates a server whose client talks to an httptest server answering with the
+// given responses in turn. It returns the requests the server received.
+func agentTestServer(t *testing.T, config *Config, responses ...string) (*DeepseekServer, func() []deepseek.ChatCompletionRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []deepseek.ChatCompletionRequest
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request deepseek.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, request)
+		n := len(requests)
+		mu.Unlock()
+		if n > len(responses) {
+			t.Errorf("unexpected request %d", n)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(responses[n-1]))
+	}))
+	t.Cleanup(api.Close)
+
+	s := &DeepseekServer{
+		config:    config,
+		client:    deepseek.NewClient("key", api.URL+"/"),
+		workspace: agentTestWorkspace(t),
+		fileTypes: NewFileTypeRegistry(nil),
+	}
+	return s, func() []deepseek.ChatCompletionRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestRunAgent(t *testing.T) {
+	readA := agentCall("call_1", "read_file", `{"path":"a.go"}`)
+	tests := []struct {
+		name       string
+		maxSteps   int
+		maxTokens  int
+		responses  []string
+		wantTrace  []string // Tool and error, if any, of each trace entry
+		wantTokens int
+		wantBudget bool   // The last request withdraws the tools and asks for an answer
+		wantResult string // Start of the last tool result sent to the model
+	}{
+		{
+			name:       "answer without tools",
+			maxSteps:   5,
+			responses:  []string{agentResponse(7)},
+			wantTokens: 7,
+		},
+		{
+			name:       "tool call",
+			maxSteps:   5,
+			responses:  []string{agentResponse(10, readA), agentResponse(5)},
+			wantTrace:  []string{"read_file"},
+			wantTokens: 15,
+			wantResult: "     1  package x\n",
+		},
+		{
+			name:       "failed tool call",
+			maxSteps:   5,
+			responses:  []string{agentResponse(10, agentCall("call_1", "read_file", `{"path":"../outside.go"}`)), agentResponse(5)},
+			wantTrace:  []string{"read_file: path ../outside.go"},
+			wantTokens: 15,
+			wantResult: "Error: ",
+		},
+		{
+			name:       "step budget",
+			maxSteps:   1,
+			responses:  []string{agentResponse(10, readA), agentResponse(5)},
+			wantTrace:  []string{"read_file"},
+			wantTokens: 15,
+			wantBudget: true,
+		},
+		{
+			name:       "token budget",
+			maxSteps:   5,
+			maxTokens:  10,
+			responses:  []string{agentResponse(12, readA, agentCall("call_2", "list_directory", `{"path":"sub"}`)), agentResponse(3)},
+			wantTrace:  []string{"read_file", "list_directory"},
+			wantTokens: 15,
+			wantBudget: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{AgentMaxSteps: tt.maxSteps, AgentMaxTokens: tt.maxTokens, HTTPTimeout: time.Minute}
+			s, requests := agentTestServer(t, config, tt.responses...)
+			ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+			request := &deepseek.ChatCompletionRequest{
+				Model:    "deepseek-chat",
+				Messages: []deepseek.ChatCompletionMessage{{Role: deepseek.ChatMessageRoleUser, Content: "What does A return?"}},
+			}
+
+			response, trace, stats, err := s.runAgent(ctx, request, nil)
+			if err != nil {
+				t.Fatalf("runAgent: %v", err)
+			}
+			if stats.Attempts != len(tt.responses) {
+				t.Errorf("made %d attempts, want %d", stats.Attempts, len(tt.responses))
+			}
+			if response.Choices[0].Message.Content != "The answer." || response.Usage.TotalTokens != tt.wantTokens {
+				t.Errorf("answer %q using %d tokens, want %d tokens", response.Choices[0].Message.Content, response.Usage.TotalTokens, tt.wantTokens)
+			}
+
+			var got []string
+			for _, entry := range trace {
+				if entry.Error != "" {
+					got = append(got, entry.Tool+": "+entry.Error)
+				} else {
+					got = append(got, entry.Tool)
+				}
+			}
+			if len(got) != len(tt.wantTrace) {
+				t.Fatalf("trace = %q, want %q", got, tt.wantTrace)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.wantTrace[i]) {
+					t.Errorf("trace entry %d = %q, want %q", i, got[i], tt.wantTrace[i])
+				}
+			}
+
+			sent := requests()
+			if len(sent) != len(tt.responses) {
+				t.Fatalf("sent %d requests, want %d", len(sent), len(tt.responses))
+			}
+			if len(sent[0].Tools) != len(agentTools()) {
+				t.Errorf("first request offers %d tools, want %d", len(sent[0].Tools), len(agentTools()))
+			}
+			last := sent[len(sent)-1]
+			lastMessage := last.Messages[len(last.Messages)-1]
+			if budget := len(last.Tools) == 0 && lastMessage.Content == agentBudgetMessage; budget != tt.wantBudget {
+				t.Errorf("last request asks for the final answer = %v, want %v", budget, tt.wantBudget)
+			}
+			if tt.wantResult != "" {
+				if lastMessage.Role != deepseek.ChatMessageRoleTool || lastMessage.ToolCallID != "call_1" || !strings.HasPrefix(lastMessage.Content, tt.wantResult) {
+					t.Errorf("last message = %+v, want the result of call_1 starting with %q", lastMessage, tt.wantResult)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteAgentTool(t *testing.T) {
+	s := &DeepseekServer{config: &Config{}, workspace: agentTestWorkspace(t), fileTypes: NewFileTypeRegistry(nil)}
+	large := strings.Repeat("// padding line for the output limit\n", agentMaxToolOutput/30)
+	if err := os.WriteFile(filepath.Join(s.workspace.Roots()[0], "large.go"), []byte(large), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tool      string
+		arguments string
+		want      string // Expected output, or the start of the error
+		wantErr   bool
+	}{
+		{"read file", "read_file", `{"path":"a.go"}`, "     1  package x\n     2  \n     3  func A() int {\n     4  \treturn 1\n     5  }\n     6  \n", false},
+		{"read line range", "read_file", `{"path":"a.go","start_line":3,"end_line":3}`, "     3  func A() int {\n", false},
+		{"read invalid range", "read_file", `{"path":"a.go","start_line":9}`, "invalid line range", true},
+		{"read outside the workspace", "read_file", `{"path":"/etc/passwd"}`, "path /etc/passwd is outside", true},
+		{"list directory", "list_directory", `{"path":"sub"}`, "b.go (12 B)\n", false},
+		{"grep", "grep", `{"pattern":"func A"}`, "a.go:3: func A() int {\n", false},
+		{"grep without match", "grep", `{"pattern":"func B"}`, "No matches found.", false},
+		{"grep invalid pattern", "grep", `{"pattern":"("}`, "invalid pattern", true},
+		{"file outline", "file_outline", `{"path":"a.go"}`, "func A (lines 3-5)\n", false},
+		{"unknown tool", "write_file", `{"path":"a.go"}`, "unknown tool", true},
+		{"invalid arguments", "read_file", `{"path":`, "invalid arguments for read_file", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.executeAgentTool(tt.tool, tt.arguments)
+			if tt.wantErr {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+					t.Fatalf("executeAgentTool = %q, %v; want an error starting with %q", got, err, tt.want)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("executeAgentTool = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	got, err := s.executeAgentTool("read_file", `{"path":"large.go"}`)
+	if err != nil || !strings.HasSuffix(got, "\n[output truncated]") || len(got) > agentMaxToolOutput+len("\n[output truncated]") {
+		t.Errorf("large read_file output of %d bytes is not truncated to %d (error %v)", len(got), agentMaxToolOutput, err)
+	}
+}
+
+func TestAgentGrepSkipsSymlinks(t *testing.T) {
+	ws, root, _ := testWorkspace(t)
+	if err := os.WriteFile(filepath.Join(root, "sub", "c.go"), []byte("needle here\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outsideFile := filepath.Join(t.TempDir(), "outside.go")
+	if err := os.WriteFile(outsideFile, []byte("needle outside\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideFile, filepath.Join(root, "sub", "link.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &DeepseekServer{config: &Config{}, workspace: ws, fileTypes: NewFileTypeRegistry(nil)}
+	tests := []struct {
+		name string
+		args agentToolArgs
+		want string
+	}{
+		{"whole workspace", agentToolArgs{Pattern: "needle"}, "sub/c.go:1: needle here\n"},
+		{"glob", agentToolArgs{Pattern: "needle", Glob: "*.go"}, "sub/c.go:1: needle here\n"},
+		{"glob without match", agentToolArgs{Pattern: "needle", Glob: "*.txt"}, "No matches found."},
+		{"escaping directory link", agentToolArgs{Pattern: ".", Path: "escape"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.agentGrep(tt.args)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("agentGrep(%+v) = %q, want an error", tt.args, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("agentGrep(%+v) = %q, %v; want %q", tt.args, got, err, tt.want)
+			}
+			if strings.Contains(got, "outside") {
+				t.Error("grep followed a symlink out of the workspace")
+			}
+		})
+	}
+}
+
+func TestAgentGrepHonoursIgnoreFiles(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, root, map[string]string{
+		".gitignore":             "build/\n*.gen.go\n",
+		"main.go":                "needle in main\n",
+		"build/out.go":           "needle in build output\n",
+		"api.gen.go":             "needle in generated code\n",
+		"vendor/.deepseekignore": "secrets.go\n",
+		"vendor/secrets.go":      "needle in vendored secrets\n",
+		"vendor/lib.go":          "needle in vendored library\n",
+	})
+	// Rules of the repository root also apply when a subdirectory is searched
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWorkspace([]string{root}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DeepseekServer{config: &Config{AllowedFileTypes: []string{"text/x-go"}, MaxFileSize: 1024}, workspace: ws, fileTypes: NewFileTypeRegistry(nil)}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{".", "main.go:1: needle in main\nvendor/lib.go:1: needle in vendored library\n"},
+		{"vendor", "vendor/lib.go:1: needle in vendored library\n"},
+		{"build", "No matches found."},
+	}
+	for _, tt := range tests {
+		got, err := s.agentGrep(agentToolArgs{Pattern: "needle", Path: tt.path})
+		if err != nil || got != tt.want {
+			t.Errorf("agentGrep in %s = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestTruncateToolOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		max    int
+		want   string
+	}{
+		{"short", "one\ntwo\n", 20, "one\ntwo\n"},
+		{"at a line boundary", "line one\nline two\nline three\n", 20, "line one\nline two\n[output truncated]"},
+		{"inside a multibyte character", "ééééé", 5, "éé\n[output truncated]"},
+		{"long line", "ab\n" + strings.Repeat("é", 10), 12, "ab\néééé\n[output truncated]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateToolOutput(tt.output, tt.max)
+			if got != tt.want || !utf8.ValidString(got) {
+				t.Errorf("truncateToolOutput(%q, %d) = %q, want %q", tt.output, tt.max, got, tt.want)
+			}
+		})
+	}
+}
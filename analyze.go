@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// Limits of the map-reduce analysis
+const (
+	defaultAnalyzeChunkTokens  = 32000
+	minAnalyzeChunkTokens      = 2000
+	defaultAnalyzeConcurrency  = 4
+	maxAnalyzeConcurrency      = 8
+	analyzePromptReserveTokens = 1000 // Instructions and question sent with every chunk
+	partialTruncatedTokens     = 16   // Note appended to a partial answer cut to fit the synthesis
+)
+
+// analyzeMapInstructions are sent with each chunk of a map-reduce analysis
+const analyzeMapInstructions = `You are analysing part %d of %d of a set of files that is too large to read at once. ` +
+	`Answer the question below using only the files in this part. Cite the evidence you rely on as path:line. ` +
+	`If nothing in this part is relevant to the question, say so in one sentence instead of guessing; ` +
+	`the other parts are analysed separately and the partial answers will be merged afterwards.`
+
+// analyzeReduceInstructions are sent with the synthesis pass of a map-reduce analysis
+const analyzeReduceInstructions = `The files needed to answer the question below were split into %d parts and each part was analysed separately. ` +
+	`Merge the partial answers that follow into one complete answer to the question. ` +
+	`Remove duplication, resolve contradictions between parts where possible and keep the path:line citations. ` +
+	`Ignore parts that found nothing relevant.`
+
+// partialTruncatedNote ends a partial answer that was too long to merge whole
+const partialTruncatedNote = "\n\n[partial answer truncated to fit the synthesis request]"
+
+// AnalysisChunk is a group of files or file excerpts analysed in one request
+type AnalysisChunk struct {
+	Files  []LoadedFile
+	Tokens int
+}
+
+// AnalysisResult is the outcome of one request of a map-reduce analysis
+type AnalysisResult struct {
+	Label  string
+	Files  int
+	Answer string
+	Usage  deepseek.Usage
+	Stats  RequestStats // API requests made, including retries, and time queued for the rate limits
+	Err    error
+}
+
+// chunkFiles packs files into chunks of at most maxTokens, keeping their order.
+// Files larger than a chunk are split along declaration boundaries first.
+func chunkFiles(files []LoadedFile, maxTokens int) []AnalysisChunk {
+	var chunks []AnalysisChunk
+	var current AnalysisChunk
+	for _, file := range files {
+		for _, part := range splitFile(file, maxTokens) {
+			cost := embeddedTokens(&part)
+			if current.Tokens > 0 && current.Tokens+cost > maxTokens {
+				chunks = append(chunks, current)
+				current = AnalysisChunk{}
+			}
+			current.Files = append(current.Files, part)
+			current.Tokens += cost
+		}
+	}
+	if len(current.Files) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// splitFile divides a file larger than maxTokens into excerpts that fit, cutting between top-level
+// declarations where the file has an outline and between lines otherwise
+func splitFile(file LoadedFile, maxTokens int) []LoadedFile {
+	if embeddedTokens(&file) <= maxTokens {
+		return []LoadedFile{file}
+	}
+
+	lines := splitLinesKeepEnds(string(file.Content))
+	first := 1
+	if file.StartLine > 0 {
+		first = file.StartLine
+	}
+
+	// Candidate cut points are the first lines of declarations, relative to the content
+	var boundaries []int
+	for _, symbol := range fileOutline(file.Path, file.Content) {
+		if symbol.StartLine > 1 && symbol.StartLine <= len(lines) {
+			boundaries = append(boundaries, symbol.StartLine-1)
+		}
+	}
+
+	// Token counts are estimated per line so that segments can be sized without re-encoding.
+	// Each line also takes its line number once embedded.
+	lineTokens := make([]int, len(lines))
+	for i, line := range lines {
+		lineTokens[i] = deepseek.EstimateTokenCount(line).EstimatedTokens + lineNumberTokens
+	}
+	limit := maxTokens - fileHeaderTokens
+
+	var parts []LoadedFile
+	start, tokens, lastBoundary := 0, 0, -1
+	next := 0
+	emit := func(end int) {
+		part := file
+		part.Content = []byte(strings.Join(lines[start:end], ""))
+		part.StartLine = first + start
+		part.EndLine = first + end - 1
+		part.Symbol = ""
+		part.Tokens = 0
+		for _, t := range lineTokens[start:end] {
+			part.Tokens += t - lineNumberTokens
+		}
+		parts = append(parts, part)
+		start = end
+	}
+	for i := 0; i < len(lines); i++ {
+		for next < len(boundaries) && boundaries[next] < i {
+			next++
+		}
+		if next < len(boundaries) && boundaries[next] == i && i > start {
+			lastBoundary = i
+		}
+		if tokens+lineTokens[i] > limit && i > start {
+			// Prefer cutting at the last declaration boundary inside the segment
+			if lastBoundary > start {
+				emit(lastBoundary)
+			} else {
+				emit(i)
+			}
+			lastBoundary = -1
+			tokens = 0
+			for _, t := range lineTokens[start:i] {
+				tokens += t
+			}
+		}
+		tokens += lineTokens[i]
+	}
+	if start < len(lines) {
+		emit(len(lines))
+	}
+	return parts
+}
+
+// embeddedTokens returns the estimated tokens a file or excerpt takes in an analysis prompt,
+// where files are embedded with line numbers so that citations point at real lines
+func embeddedTokens(file *LoadedFile) int {
+	budget := ContextBudget{LineNumbers: true}
+	return budget.fileTokens(file)
+}
+
+// analysisPrompt embeds the files of a chunk for the map phase
+func analysisPrompt(chunk AnalysisChunk, index, total int, query string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(analyzeMapInstructions, index, total))
+	sb.WriteString("\n\n# Question\n\n")
+	sb.WriteString(query)
+	sb.WriteString(fmt.Sprintf("\n\n# Files (part %d of %d)\n", index, total))
+	for _, file := range chunk.Files {
+		sb.WriteString("\n" + file.Embed(true) + "\n")
+	}
+	return sb.String()
+}
+
+// handleAnalyzeLarge answers a question about more files than fit in one request by analysing
+// token-bounded chunks concurrently and merging the partial answers in a synthesis pass
+func (s *DeepseekServer) handleAnalyzeLarge(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+
+	query, ok := req.Arguments["query"].(string)
+	if !ok || query == "" {
+		return createErrorResponse("query must be a non-empty string"), nil
+	}
+	filePathsRaw, ok := req.Arguments["file_paths"].([]interface{})
+	if !ok || len(filePathsRaw) == 0 {
+		return createErrorResponse("file_paths must be a non-empty array of file paths"), nil
+	}
+	var filePaths []string
+	for _, pathRaw := range filePathsRaw {
+		if path, ok := pathRaw.(string); ok && path != "" {
+			filePaths = append(filePaths, path)
+		}
+	}
+
+	systemPrompt := s.config.DeepseekSystemPrompt
+	if customPrompt, ok := req.Arguments["systemPrompt"].(string); ok && customPrompt != "" {
+		systemPrompt = customPrompt
+	}
+	modelName := s.config.DeepseekModel
+	if customModel, ok := req.Arguments["model"].(string); ok && customModel != "" {
+		if err := s.ValidateModelID(customModel); err != nil {
+			return createErrorResponse(fmt.Sprintf("Invalid model: %v", err)), nil
+		}
+		modelName = customModel
+	}
+
+	// Chunks must leave room for the instructions, the question and the answer
+	budget := s.newContextBudget(modelName, []deepseek.ChatCompletionMessage{
+		{Role: deepseek.ChatMessageRoleSystem, Content: systemPrompt},
+		{Role: deepseek.ChatMessageRoleUser, Content: query},
+	}, truncateNone)
+	maxChunkTokens := budget.available() - analyzePromptReserveTokens
+	chunkTokens := defaultAnalyzeChunkTokens
+	if chunkTokensRaw, ok := req.Arguments["chunk_tokens"].(float64); ok && chunkTokensRaw > 0 {
+		chunkTokens = max(int(chunkTokensRaw), minAnalyzeChunkTokens)
+	}
+	chunkTokens = min(chunkTokens, maxChunkTokens)
+	if chunkTokens < minAnalyzeChunkTokens {
+		return createErrorResponse(fmt.Sprintf("The system prompt and query leave too little of the %d token context window of %s for file content", budget.ContextWindow, modelName)), nil
+	}
+	concurrency := defaultAnalyzeConcurrency
+	if concurrencyRaw, ok := req.Arguments["concurrency"].(float64); ok && concurrencyRaw >= 1 {
+		concurrency = min(int(concurrencyRaw), maxAnalyzeConcurrency)
+	}
+
+	files, fileReport := s.loadRequestFiles(ctx, filePaths, nil, s.config.AnalyzeMaxTokens)
+	if refusal := secretRefusal(fileReport); refusal != nil {
+		return refusal, nil
+	}
+	if len(files) == 0 {
+		return &protocol.CallToolResponse{
+			IsError: true,
+			Content: []protocol.ToolContent{{Type: "text", Text: "None of the requested files could be loaded.\n\n" + fileReport.Format()}},
+		}, nil
+	}
+
+	chunks := chunkFiles(files, chunkTokens)
+	logger.Info("Analyzing %d file(s) in %d chunk(s) of up to %d tokens with concurrency %d", len(files), len(chunks), chunkTokens, concurrency)
+
+	// Map phase: analyse each chunk on its own, at most concurrency at a time
+	progressToken := progressTokenFromContext(ctx)
+	results := make([]AnalysisResult, len(chunks))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+	completed := 0
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk AnalysisChunk) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i] = AnalysisResult{Label: fmt.Sprintf("Part %d", i+1), Files: len(chunk.Files)}
+			messages := []deepseek.ChatCompletionMessage{
+				{Role: deepseek.ChatMessageRoleSystem, Content: systemPrompt},
+				{Role: deepseek.ChatMessageRoleUser, Content: analysisPrompt(chunk, i+1, len(chunks), query)},
+			}
+			request, _ := buildChatRequest(modelName, messages, s.config.DeepseekTemperature, false)
+			// Streamed progress of concurrent requests would interleave, so progress is reported per chunk
+			response, stats, err := s.executeDeepseekRequest(ctx, request, nil)
+			results[i].Stats = stats
+			if err != nil {
+				logger.Error("Analysis of part %d failed: %v", i+1, err)
+				results[i].Err = err
+			} else {
+				results[i].Usage = response.Usage
+				if len(response.Choices) > 0 {
+					results[i].Answer = response.Choices[0].Message.Content
+				}
+			}
+
+			progressMu.Lock()
+			completed++
+			if progressToken != nil && s.progress != nil {
+				if err := s.progress.NotifyProgress(progressToken, completed, fmt.Sprintf("analysed %d of %d parts", completed, len(chunks))); err != nil {
+					logger.Warn("Failed to send progress notification: %v", err)
+				}
+			}
+			progressMu.Unlock()
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var succeeded []AnalysisResult
+	for _, result := range results {
+		if result.Err == nil {
+			succeeded = append(succeeded, result)
+		}
+	}
+	if len(succeeded) == 0 {
+		return createErrorResponse(fmt.Sprintf("Error from DeepSeek API: all %d parts failed, the first with: %v", len(results), results[0].Err)), nil
+	}
+
+	// Reduce phase: merge the partial answers, unless there was only one part to begin with
+	answer := succeeded[0].Answer
+	var synthesis *AnalysisResult
+	if len(chunks) > 1 {
+		partials := make([]string, len(results))
+		for i, result := range results {
+			partials[i] = result.Answer
+			if result.Err != nil {
+				partials[i] = "(This part could not be analysed; mention that the answer may be incomplete.)"
+			}
+		}
+		synthesis = &AnalysisResult{Label: "Synthesis"}
+		var err error
+		answer, err = s.synthesizeAnswers(ctx, modelName, systemPrompt, query, partials, maxChunkTokens, synthesis)
+		if err != nil {
+			logger.Error("DeepSeek API error: %v", err)
+			return createErrorResponse(fmt.Sprintf("Error from DeepSeek API while merging %d partial answers: %v", len(chunks), err)), nil
+		}
+	}
+	if answer == "" {
+		answer = "The DeepSeek model returned an empty response. This might indicate that the model couldn't generate an appropriate response for your query. Please try rephrasing your question or providing more context."
+	}
+
+	result := &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: answer,
+			},
+			{
+				Type: "text",
+				Text: formatAnalysisBreakdown(modelName, results, synthesis),
+			},
+		},
+	}
+	if citations := extractCitations(answer, files); len(citations) > 0 {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: formatCitations(citations),
+		})
+	}
+	if len(fileReport.Entries) > 0 {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: fileReport.Format(),
+		})
+	}
+	return result, nil
+}
+
+// synthesizeAnswers merges partial answers into one. When they do not fit a single request they are
+// merged in groups first, and the merged groups are merged again. Usage of every pass is added to synthesis.
+func (s *DeepseekServer) synthesizeAnswers(ctx context.Context, model, systemPrompt, query string, partials []string, maxTokens int, synthesis *AnalysisResult) (string, error) {
+	// Group consecutive answers so that each group fits one request. Answers are limited to half
+	// a request so that any two can be merged and every pass reduces their number.
+	var groups [][]string
+	var current []string
+	tokens := 0
+	for _, partial := range partials {
+		partial = truncatePartialAnswer(partial, maxTokens/2-fileHeaderTokens)
+		partialTokens := deepseek.EstimateTokenCount(partial).EstimatedTokens + fileHeaderTokens
+		if len(current) > 0 && tokens+partialTokens > maxTokens {
+			groups = append(groups, current)
+			current, tokens = nil, 0
+		}
+		current = append(current, partial)
+		tokens += partialTokens
+	}
+	groups = append(groups, current)
+
+	merged := make([]string, len(groups))
+	for g, group := range groups {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf(analyzeReduceInstructions, len(group)))
+		sb.WriteString("\n\n# Question\n\n")
+		sb.WriteString(query)
+		for i, partial := range group {
+			sb.WriteString(fmt.Sprintf("\n\n# Partial Answer %d of %d\n\n", i+1, len(group)))
+			sb.WriteString(partial)
+		}
+
+		messages := []deepseek.ChatCompletionMessage{
+			{Role: deepseek.ChatMessageRoleSystem, Content: systemPrompt},
+			{Role: deepseek.ChatMessageRoleUser, Content: sb.String()},
+		}
+		request, _ := buildChatRequest(model, messages, s.config.DeepseekTemperature, false)
+		response, stats, err := s.executeDeepseekRequest(ctx, request, nil)
+		synthesis.Stats.add(stats)
+		if err != nil {
+			return "", err
+		}
+		addUsage(&synthesis.Usage, response.Usage)
+		if len(response.Choices) > 0 {
+			merged[g] = response.Choices[0].Message.Content
+		}
+	}
+
+	if len(merged) == 1 {
+		return merged[0], nil
+	}
+	return s.synthesizeAnswers(ctx, model, systemPrompt, query, merged, maxTokens, synthesis)
+}
+
+// truncatePartialAnswer cuts a partial answer longer than maxTokens at the last line that fits,
+// or within its first line when even that is too long, and says that it was truncated
+func truncatePartialAnswer(partial string, maxTokens int) string {
+	tokens := deepseek.EstimateTokenCount(partial).EstimatedTokens
+	if tokens <= maxTokens {
+		return partial
+	}
+	limit := maxTokens - partialTruncatedTokens
+	var sb strings.Builder
+	used := 0
+	for _, line := range splitLinesKeepEnds(partial) {
+		lineTokens := deepseek.EstimateTokenCount(line).EstimatedTokens
+		if used+lineTokens > limit {
+			if used == 0 {
+				// Keep the share of the line's characters that fits
+				runes := []rune(line)
+				sb.WriteString(string(runes[:max(len(runes)*limit/lineTokens, 0)]))
+			}
+			break
+		}
+		sb.WriteString(line)
+		used += lineTokens
+	}
+	return strings.TrimRight(sb.String(), "\n") + partialTruncatedNote
+}
+
+// formatAnalysisBreakdown renders the token usage and cost of each request of an analysis as markdown
+func formatAnalysisBreakdown(model string, results []AnalysisResult, synthesis *AnalysisResult) string {
+	pricing, priced := GetModelPricing(model)
+	rows := results
+	if synthesis != nil {
+		rows = append(rows[:len(rows):len(rows)], *synthesis)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("## Analysis Breakdown\n\n")
+	sb.WriteString("| Request | Files | Prompt tokens | Cache hit | Completion tokens | Cost (USD) | Attempts | Queued | Status |\n")
+	sb.WriteString("|---------|-------|---------------|-----------|-------------------|------------|----------|--------|--------|\n")
+	var total deepseek.Usage
+	var totalStats RequestStats
+	var totalCost float64
+	failed := 0
+	for _, row := range rows {
+		status := "ok"
+		if row.Err != nil {
+			status = "failed: " + strings.ReplaceAll(row.Err.Error(), "|", "\\|")
+			failed++
+		}
+		files := "-"
+		if row.Files > 0 {
+			files = fmt.Sprintf("%d", row.Files)
+		}
+		cost := "unpriced"
+		if priced {
+			totalCost += pricing.Cost(row.Usage)
+			cost = fmt.Sprintf("%.4f", pricing.Cost(row.Usage))
+		}
+		addUsage(&total, row.Usage)
+		totalStats.add(row.Stats)
+		sb.WriteString(fmt.Sprintf("| %s | %s | %d | %d | %d | %s | %d | %s | %s |\n",
+			row.Label, files, row.Usage.PromptTokens, row.Usage.PromptCacheHitTokens, row.Usage.CompletionTokens, cost,
+			row.Stats.Attempts, row.Stats.QueueWait.Round(time.Millisecond), status))
+	}
+	totalCostText := "unpriced"
+	if priced {
+		totalCostText = fmt.Sprintf("%.4f", totalCost)
+	}
+	sb.WriteString(fmt.Sprintf("| **Total** | | %d | %d | %d | **%s** | %d | %s | |\n",
+		total.PromptTokens, total.PromptCacheHitTokens, total.CompletionTokens, totalCostText,
+		totalStats.Attempts, totalStats.QueueWait.Round(time.Millisecond)))
+
+	if !priced {
+		sb.WriteString(fmt.Sprintf("\n*Note: the price of %s is not known, so no cost is shown.*\n", model))
+	}
+
+	if failed > 0 {
+		sb.WriteString(fmt.Sprintf("\n*Note: %d of %d parts could not be analysed, so the answer may be incomplete.*\n", failed, len(results)))
+	}
+	return sb.String()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// analyzeTestFile creates a loaded file with the given content and its estimated token count
+func analyzeTestFile(path, content string) LoadedFile {
+	return LoadedFile{Path: path, Content: []byte(content), Tokens: deepseek.EstimateTokenCount(content).EstimatedTokens}
+}
+
+// analyzeTestGoFile creates a Go file of funcs functions of ten lines each after a two-line header
+func analyzeTestGoFile(path string, funcs int) LoadedFile {
+	var sb strings.Builder
+	sb.WriteString("package demo\n\n")
+	for i := 0; i < funcs; i++ {
+		sb.WriteString(fmt.Sprintf("func handler%d(input string) (string, error) {\n", i))
+		for j := 0; j < 7; j++ {
+			sb.WriteString(fmt.Sprintf("\tinput = strings.TrimSpace(input) + \"%d-%d\"\n", i, j))
+		}
+		sb.WriteString("\treturn input, nil\n}\n")
+	}
+	return analyzeTestFile(path, sb.String())
+}
+
+func TestSplitFile(t *testing.T) {
+	file := analyzeTestGoFile("big.go", 12)
+	maxTokens := embeddedTokens(&file)/3 + fileHeaderTokens
+
+	parts := splitFile(file, maxTokens)
+	if len(parts) < 3 {
+		t.Fatalf("split into %d parts, want at least 3", len(parts))
+	}
+
+	var joined strings.Builder
+	next := 1
+	for i, part := range parts {
+		if embeddedTokens(&part) > maxTokens {
+			t.Errorf("part %d takes %d tokens, limit %d", i, embeddedTokens(&part), maxTokens)
+		}
+		if part.StartLine != next || part.EndLine != next+len(splitLinesKeepEnds(string(part.Content)))-1 {
+			t.Errorf("part %d covers lines %d-%d, want it to start at %d and match its %d lines",
+				i, part.StartLine, part.EndLine, next, len(splitLinesKeepEnds(string(part.Content))))
+		}
+		// Every part after the first starts at a declaration because the file has an outline
+		if i > 0 && !strings.HasPrefix(string(part.Content), "func handler") {
+			t.Errorf("part %d does not start at a declaration: %q", i, strings.SplitN(string(part.Content), "\n", 2)[0])
+		}
+		next = part.EndLine + 1
+		joined.Write(part.Content)
+	}
+	if joined.String() != string(file.Content) {
+		t.Error("the parts do not add up to the original content")
+	}
+
+	if got := splitFile(file, embeddedTokens(&file)); len(got) != 1 || got[0].StartLine != 0 {
+		t.Errorf("a file that fits was split into %d parts", len(got))
+	}
+}
+
+func TestSplitFileExcerptAndPlainText(t *testing.T) {
+	// An excerpt keeps the line numbers of the file it came from
+	excerpt := analyzeTestGoFile("big.go", 6)
+	excerpt.StartLine, excerpt.EndLine = 101, 100+len(splitLinesKeepEnds(string(excerpt.Content)))
+	parts := splitFile(excerpt, embeddedTokens(&excerpt)/2+fileHeaderTokens)
+	if len(parts) < 2 || parts[0].StartLine != 101 || parts[len(parts)-1].EndLine != excerpt.EndLine {
+		t.Errorf("excerpt parts = %d, first starts at %d, last ends at %d; want lines 101-%d",
+			len(parts), parts[0].StartLine, parts[len(parts)-1].EndLine, excerpt.EndLine)
+	}
+
+	// Without an outline the file is cut between lines
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		sb.WriteString(fmt.Sprintf("line %d of a plain text file without declarations\n", i+1))
+	}
+	text := analyzeTestFile("notes.txt", sb.String())
+	parts = splitFile(text, embeddedTokens(&text)/4+fileHeaderTokens)
+	if len(parts) < 4 {
+		t.Fatalf("split plain text into %d parts, want at least 4", len(parts))
+	}
+	for i, part := range parts {
+		if first := fmt.Sprintf("line %d of", part.StartLine); !strings.HasPrefix(string(part.Content), first) {
+			t.Errorf("part %d starts at line %d but its content does not: %q", i, part.StartLine, string(part.Content[:20]))
+		}
+	}
+}
+
+func TestChunkFiles(t *testing.T) {
+	small := func(name string) LoadedFile {
+		return analyzeTestFile(name, strings.Repeat("word ", 200))
+	}
+	a, b, c := small("a.txt"), small("b.txt"), small("c.txt")
+	big := analyzeTestGoFile("big.go", 12)
+	perFile := embeddedTokens(&a)
+
+	tests := []struct {
+		name      string
+		files     []LoadedFile
+		maxTokens int
+		want      []string // Paths of the files in each chunk, in order
+	}{
+		{"all in one chunk", []LoadedFile{a, b, c}, perFile * 3, []string{"a.txt,b.txt,c.txt"}},
+		{"two per chunk", []LoadedFile{a, b, c}, perFile*2 + 1, []string{"a.txt,b.txt", "c.txt"}},
+		{"one per chunk", []LoadedFile{a, b, c}, perFile, []string{"a.txt", "b.txt", "c.txt"}},
+		{"large file split across chunks", []LoadedFile{a, big}, embeddedTokens(&big)/2 + fileHeaderTokens, nil},
+		{"no files", nil, perFile, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkFiles(tt.files, tt.maxTokens)
+			var got []string
+			total := 0
+			for i, chunk := range chunks {
+				var paths []string
+				tokens := 0
+				for _, file := range chunk.Files {
+					paths = append(paths, file.Path)
+					tokens += embeddedTokens(&file)
+				}
+				if tokens != chunk.Tokens || chunk.Tokens > tt.maxTokens {
+					t.Errorf("chunk %d reports %d tokens, holds %d, limit %d", i, chunk.Tokens, tokens, tt.maxTokens)
+				}
+				total += len(chunk.Files)
+				got = append(got, strings.Join(paths, ","))
+			}
+			if tt.want != nil && strings.Join(got, " | ") != strings.Join(tt.want, " | ") {
+				t.Errorf("chunks = %v, want %v", got, tt.want)
+			}
+			if tt.name == "large file split across chunks" && (len(chunks) < 3 || total < 3) {
+				t.Errorf("chunks = %v, want the large file split over several chunks", got)
+			}
+		})
+	}
+}
+
+func TestAnalysisPromptNumbersLines(t *testing.T) {
+	excerpt := analyzeTestFile("big.go", "func a() {}\nfunc b() {}\n")
+	excerpt.StartLine, excerpt.EndLine = 41, 42
+
+	prompt := analysisPrompt(AnalysisChunk{Files: []LoadedFile{excerpt}}, 2, 3, "What does b do?")
+	if !strings.Contains(prompt, "41 | func a() {}\n42 | func b() {}\n") {
+		t.Errorf("prompt does not number the excerpt's lines from 41:\n%s", prompt)
+	}
+}
+
+func TestTruncatePartialAnswer(t *testing.T) {
+	short := "The handler validates its input.\n"
+	if got := truncatePartialAnswer(short, 100); got != short {
+		t.Errorf("a short answer was changed to %q", got)
+	}
+
+	long := strings.Repeat("Finding about the code in big.go:10 and what it means.\n", 200)
+	oneLine := strings.Repeat("word ", 2000)
+	for name, partial := range map[string]string{"many lines": long, "one long line": oneLine} {
+		t.Run(name, func(t *testing.T) {
+			got := truncatePartialAnswer(partial, 200)
+			if tokens := deepseek.EstimateTokenCount(got).EstimatedTokens; tokens > 200 {
+				t.Errorf("truncated answer takes %d tokens, limit 200", tokens)
+			}
+			if !strings.HasSuffix(got, partialTruncatedNote) || !strings.HasPrefix(partial, strings.TrimSuffix(got, partialTruncatedNote)) {
+				t.Errorf("truncated answer is not a prefix of the original followed by the note: %q", got)
+			}
+		})
+	}
+}
+
+func TestFormatAnalysisBreakdown(t *testing.T) {
+	results := []AnalysisResult{
+		{Label: "Part 1", Files: 2, Usage: deepseek.Usage{PromptTokens: 1000000, PromptCacheMissTokens: 1000000, CompletionTokens: 1000000}, Stats: RequestStats{Attempts: 1}},
+	}
+	tests := []struct {
+		name     string
+		model    string
+		want     string
+		unpriced bool
+	}{
+		{"priced model", deepseek.DeepSeekChat, "| **0.7000** |", false},
+		{"reasoner", deepseek.DeepSeekReasoner, "| **0.7000** |", false},
+		{"unknown model", "deepseek-coder-next", "| **unpriced** |", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatAnalysisBreakdown(tt.model, results, nil)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("breakdown does not contain %q:\n%s", tt.want, got)
+			}
+			if strings.Contains(got, "price of "+tt.model+" is not known") != tt.unpriced {
+				t.Errorf("breakdown note on the unknown price is wrong:\n%s", got)
+			}
+		})
+	}
+}
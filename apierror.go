@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Kinds of failed DeepSeek API requests
+const (
+	apiErrorInvalidRequest      = "invalid_request"      // 400 and 422: the request itself is wrong
+	apiErrorContextLength       = "context_length"       // 400: the prompt exceeds the model's context window
+	apiErrorAuthentication      = "authentication"       // 401: the API key is invalid
+	apiErrorInsufficientBalance = "insufficient_balance" // 402: the account has run out of credit
+	apiErrorRateLimited         = "rate_limited"         // 429: too many requests
+	apiErrorServer              = "server_error"         // 500 and 502
+	apiErrorOverloaded          = "overloaded"           // 503: the servers are busy
+	apiErrorTimeout             = "timeout"              // No response within DEEPSEEK_TIMEOUT
+	apiErrorNetwork             = "network"              // The connection failed or broke off
+	apiErrorCanceled            = "canceled"             // The tool call was cancelled
+	apiErrorUnknown             = "unknown"
+)
+
+// maxRetryAfter is the longest Retry-After delay that is waited for; longer delays fail the request
+const maxRetryAfter = 2 * time.Minute
+
+// DeepseekAPIError is a failed DeepSeek API request, classified by HTTP status and DeepSeek error code
+type DeepseekAPIError struct {
+	Kind       string
+	StatusCode int           // HTTP status, 0 when no response was received
+	Code       string        // Error code or type from the response body, if any
+	Message    string        // Error message from the response body, if any
+	RetryAfter time.Duration // Delay requested by the Retry-After header, if any
+	Err        error
+}
+
+// Error describes the failure together with what the user can do about it
+func (e *DeepseekAPIError) Error() string {
+	status := ""
+	if e.StatusCode > 0 {
+		status = fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	detail := e.Message
+	if detail == "" && e.Err != nil {
+		detail = e.Err.Error()
+	}
+
+	switch e.Kind {
+	case apiErrorAuthentication:
+		return fmt.Sprintf("the API key was rejected%s; check DEEPSEEK_API_KEY", status)
+	case apiErrorInsufficientBalance:
+		return fmt.Sprintf("insufficient account balance%s; top up at https://platform.deepseek.com and check it with deepseek_balance", status)
+	case apiErrorContextLength:
+		return fmt.Sprintf("the request exceeds the model's context length%s; include fewer files, select line ranges or symbols, or use a truncation strategy: %s", status, detail)
+	case apiErrorRateLimited:
+		return fmt.Sprintf("rate limit reached%s; wait before sending more requests: %s", status, detail)
+	case apiErrorOverloaded:
+		return fmt.Sprintf("DeepSeek's servers are overloaded%s; try again later: %s", status, detail)
+	case apiErrorServer:
+		return fmt.Sprintf("DeepSeek server error%s: %s", status, detail)
+	case apiErrorInvalidRequest:
+		return fmt.Sprintf("invalid request%s: %s", status, detail)
+	case apiErrorTimeout:
+		return fmt.Sprintf("no response within the timeout; raise DEEPSEEK_TIMEOUT for long answers: %s", detail)
+	}
+	return detail + status
+}
+
+// Unwrap returns the underlying error
+func (e *DeepseekAPIError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request may succeed when sent again
+func (e *DeepseekAPIError) Retryable() bool {
+	switch e.Kind {
+	case apiErrorRateLimited, apiErrorServer, apiErrorOverloaded, apiErrorTimeout, apiErrorNetwork:
+		return true
+	}
+	return false
+}
+
+// RetryDelay returns the delay the server asked for before the next attempt
+func (e *DeepseekAPIError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// responseMeta records the parts of an HTTP response the API library does not expose
+type responseMeta struct {
+	mu              sync.Mutex
+	statusCode      int
+	retryAfter      string
+	cacheHitTokens  int // Context cache usage of a streamed response
+	cacheMissTokens int
+}
+
+// responseMetaKey is the context key under which a request's responseMeta is stored
+type responseMetaKey struct{}
+
+// withResponseMeta returns a context whose API responses are recorded in the returned responseMeta
+func withResponseMeta(ctx context.Context) (context.Context, *responseMeta) {
+	meta := &responseMeta{}
+	return context.WithValue(ctx, responseMetaKey{}, meta), meta
+}
+
+// responseRecorder is an HTTP client that records the status and Retry-After header of each
+// response, and the cache usage of streamed responses, in the responseMeta of the request's
+// context, if there is one
+type responseRecorder struct {
+	next deepseek.HTTPDoer
+}
+
+// Do implements deepseek.HTTPDoer
+func (r *responseRecorder) Do(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.Do(req)
+	if meta, ok := req.Context().Value(responseMetaKey{}).(*responseMeta); ok && resp != nil {
+		meta.mu.Lock()
+		meta.statusCode = resp.StatusCode
+		meta.retryAfter = resp.Header.Get("Retry-After")
+		meta.mu.Unlock()
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			resp.Body = &streamUsageReader{body: resp.Body, meta: meta}
+		}
+	}
+	return resp, err
+}
+
+// classifyAPIError converts an error from the API library into a DeepseekAPIError using the
+// status and headers recorded in meta. Errors that did not come from the API are returned as is.
+func classifyAPIError(ctx context.Context, err error, meta *responseMeta) error {
+	var classified *DeepseekAPIError
+	if err == nil || errors.Is(err, ErrSecretDetected) || errors.As(err, &classified) {
+		return err
+	}
+
+	apiErr := &DeepseekAPIError{Err: err}
+	if meta != nil {
+		meta.mu.Lock()
+		apiErr.StatusCode = meta.statusCode
+		apiErr.RetryAfter = parseRetryAfter(meta.retryAfter, time.Now())
+		meta.mu.Unlock()
+	}
+	var libraryErr *deepseek.APIError
+	if errors.As(err, &libraryErr) {
+		if libraryErr.StatusCode > 0 {
+			apiErr.StatusCode = libraryErr.StatusCode
+		}
+		apiErr.Message = libraryErr.Message
+		apiErr.Code, apiErr.Message = parseErrorBody(libraryErr.ResponseBody, apiErr.Message)
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized:
+		apiErr.Kind = apiErrorAuthentication
+	case apiErr.StatusCode == http.StatusPaymentRequired:
+		apiErr.Kind = apiErrorInsufficientBalance
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		apiErr.Kind = apiErrorRateLimited
+	case apiErr.StatusCode == http.StatusServiceUnavailable:
+		apiErr.Kind = apiErrorOverloaded
+	case apiErr.StatusCode == http.StatusInternalServerError, apiErr.StatusCode == http.StatusBadGateway:
+		apiErr.Kind = apiErrorServer
+	case apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		apiErr.Kind = apiErrorInvalidRequest
+		if strings.Contains(strings.ToLower(apiErr.Message), "context length") {
+			apiErr.Kind = apiErrorContextLength
+		}
+	case apiErr.StatusCode >= 500:
+		apiErr.Kind = apiErrorServer
+	case ctx.Err() == context.Canceled:
+		apiErr.Kind = apiErrorCanceled
+	case IsTimeoutError(err):
+		apiErr.Kind = apiErrorTimeout
+	case IsNetworkError(err):
+		apiErr.Kind = apiErrorNetwork
+	default:
+		apiErr.Kind = apiErrorUnknown
+	}
+	return apiErr
+}
+
+// parseErrorBody extracts the error code and message from an OpenAI-style error body,
+// {"error": {"message": ..., "type": ..., "code": ...}}, falling back to the given message
+func parseErrorBody(body string, message string) (string, string) {
+	var parsed struct {
+		Error struct {
+			Message string      `json:"message"`
+			Type    string      `json:"type"`
+			Code    interface{} `json:"code"`
+		} `json:"error"`
+	}
+	if body == "" || json.Unmarshal([]byte(body), &parsed) != nil {
+		return "", message
+	}
+
+	code := parsed.Error.Type
+	if parsed.Error.Code != nil {
+		code = fmt.Sprint(parsed.Error.Code)
+	}
+	if parsed.Error.Message != "" {
+		message = parsed.Error.Message
+	}
+	return code, message
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
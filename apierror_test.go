@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{" 5 ", 5 * time.Second},
+		{"-3", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyAPIError(t *testing.T) {
+	libraryError := func(status int, body string) error {
+		return &deepseek.APIError{StatusCode: status, Message: "failed", ResponseBody: body}
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		err           error
+		meta          *responseMeta
+		wantKind      string
+		wantCode      string
+		wantMessage   string
+		wantRetryable bool
+		wantDelay     time.Duration
+	}{
+		{name: "authentication", err: libraryError(401, ""), wantKind: apiErrorAuthentication},
+		{name: "insufficient balance", err: libraryError(402, ""), wantKind: apiErrorInsufficientBalance},
+		{name: "rate limited with Retry-After", err: libraryError(429, ""), meta: &responseMeta{statusCode: 429, retryAfter: "7"},
+			wantKind: apiErrorRateLimited, wantRetryable: true, wantDelay: 7 * time.Second},
+		{name: "overloaded", err: libraryError(503, ""), wantKind: apiErrorOverloaded, wantRetryable: true},
+		{name: "server error", err: libraryError(502, ""), wantKind: apiErrorServer, wantRetryable: true},
+		{name: "other 5xx", err: libraryError(504, ""), wantKind: apiErrorServer, wantRetryable: true},
+		{name: "invalid request with an error body", err: libraryError(422, `{"error":{"message":"bad field","type":"invalid_request_error","code":"x1"}}`),
+			wantKind: apiErrorInvalidRequest, wantCode: "x1", wantMessage: "bad field"},
+		{name: "context length", err: libraryError(400, `{"error":{"message":"This model's maximum context length is 65536 tokens","type":"invalid_request_error"}}`),
+			wantKind: apiErrorContextLength, wantCode: "invalid_request_error"},
+		{name: "status from the recorded response", err: errors.New("decode failed"), meta: &responseMeta{statusCode: 500}, wantKind: apiErrorServer, wantRetryable: true},
+		{name: "timeout", err: fmt.Errorf("send: %w", context.DeadlineExceeded), wantKind: apiErrorTimeout, wantRetryable: true},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, wantKind: apiErrorNetwork, wantRetryable: true},
+		{name: "dropped stream", err: io.ErrUnexpectedEOF, wantKind: apiErrorNetwork, wantRetryable: true},
+		{name: "cancelled tool call", ctx: canceled, err: context.Canceled, wantKind: apiErrorCanceled},
+		{name: "unknown", err: errors.New("something else"), wantKind: apiErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			var apiErr *DeepseekAPIError
+			if !errors.As(classifyAPIError(ctx, tt.err, tt.meta), &apiErr) {
+				t.Fatalf("classifyAPIError did not return a *DeepseekAPIError")
+			}
+			if apiErr.Kind != tt.wantKind {
+				t.Errorf("kind = %s, want %s", apiErr.Kind, tt.wantKind)
+			}
+			if apiErr.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", apiErr.Code, tt.wantCode)
+			}
+			if tt.wantMessage != "" && apiErr.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", apiErr.Message, tt.wantMessage)
+			}
+			if apiErr.Retryable() != tt.wantRetryable || IsRetryableError(apiErr) != tt.wantRetryable {
+				t.Errorf("retryable = %v, want %v", apiErr.Retryable(), tt.wantRetryable)
+			}
+			if apiErr.RetryDelay() != tt.wantDelay {
+				t.Errorf("retry delay = %v, want %v", apiErr.RetryDelay(), tt.wantDelay)
+			}
+			if !errors.Is(apiErr, tt.err) {
+				t.Errorf("the classified error does not wrap %v", tt.err)
+			}
+		})
+	}
+
+	// Errors that are already classified, and secrets refused before sending, are returned as they are
+	for _, err := range []error{nil, ErrSecretDetected, &DeepseekAPIError{Kind: apiErrorServer}} {
+		if got := classifyAPIError(context.Background(), err, nil); got != err {
+			t.Errorf("classifyAPIError(%v) = %v, want it unchanged", err, got)
+		}
+	}
+}
+
+func TestExecuteDeepseekRequestTimeout(t *testing.T) {
+	// The server starts a stream and then stalls; only the per-attempt deadline ends the request
+	stalled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(stalled)
+
+	client := deepseek.NewClient("key", srv.URL+"/")
+	client.HTTPClient = &responseRecorder{next: &http.Client{}}
+	s := &DeepseekServer{
+		config: &Config{EnableStreaming: true, HTTPTimeout: 100 * time.Millisecond, MaxRetries: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		client: client,
+	}
+	ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+	request := &deepseek.ChatCompletionRequest{Model: deepseek.DeepSeekChat, Messages: []deepseek.ChatCompletionMessage{{Role: "user", Content: "hi"}}}
+
+	start := time.Now()
+	_, stats, err := s.executeDeepseekRequest(ctx, request, nil)
+	var apiErr *DeepseekAPIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != apiErrorTimeout {
+		t.Fatalf("executeDeepseekRequest error = %v, want a timeout", err)
+	}
+	if stats.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", stats.Attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, the per-attempt timeout was not applied", elapsed)
+	}
+}
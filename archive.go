@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Limits protecting against archive bombs. The archive file itself must also be within DEEPSEEK_MAX_FILE_SIZE.
+const (
+	maxArchiveEntries      = 10000             // Most entries, including directories, in one archive
+	maxArchiveExpandedSize = 512 * 1024 * 1024 // Most bytes decompressed from one archive
+	maxArchiveRatio        = 100               // Most bytes decompressed per byte of archive
+)
+
+// Archive formats that are unpacked when named in file_paths
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// MIME types of the archive formats, which must be in DEEPSEEK_ALLOWED_FILE_TYPES for archives to be unpacked
+const (
+	mimeZip  = "application/zip"
+	mimeTar  = "application/x-tar"
+	mimeGzip = "application/gzip"
+)
+
+// ErrArchiveRejected is returned for archives that are too large, malformed or look like archive bombs
+var ErrArchiveRejected = errors.New("archive rejected")
+
+// archiveMember is a file read from an archive
+type archiveMember struct {
+	Name    string // Slash-separated path inside the archive
+	Content []byte
+	Err     error // Why the member was not read, e.g. ErrFileTooLarge
+}
+
+// archiveFormat returns the archive format of a path judged by its extension, or "" for other files
+func archiveFormat(path string) string {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	}
+	return ""
+}
+
+// expansionLimiter fails reads once more than limit bytes have been decompressed
+type expansionLimiter struct {
+	r     io.Reader
+	read  int64
+	limit int64
+}
+
+// Read implements io.Reader
+func (l *expansionLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, fmt.Errorf("%w: expands to more than %s (possible archive bomb)", ErrArchiveRejected, humanReadableSize(l.limit))
+	}
+	return n, err
+}
+
+// readArchive unpacks an archive in memory after checking the archive file against the allowed
+// types and maxFileSize. Members larger than maxFileSize are returned with ErrFileTooLarge instead
+// of their content. The whole archive is rejected when it has too many entries or decompresses to
+// more than maxArchiveRatio times its size.
+func readArchive(archivePath string, fileTypes *FileTypeRegistry, allowedTypes []string, maxFileSize int64) ([]archiveMember, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, archivePath)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %s is not a regular file", ErrFileUnreadable, archivePath)
+	}
+	fileType, err := fileTypes.DetectFile(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	if len(allowedTypes) > 0 && !mimeAllowed(fileType.MIME, allowedTypes) {
+		return nil, fmt.Errorf("%w: %s (type: %s)", ErrFileTypeNotAllowed, archivePath, fileType.MIME)
+	}
+	if maxFileSize > 0 && info.Size() > maxFileSize {
+		return nil, fmt.Errorf("%w: %s (%s, limit %s)", ErrFileTooLarge, archivePath,
+			humanReadableSize(info.Size()), humanReadableSize(maxFileSize))
+	}
+	limit := min(maxArchiveRatio*info.Size(), maxArchiveExpandedSize)
+
+	var members []archiveMember
+	if archiveFormat(archivePath) == archiveZip {
+		members, err = readZip(archivePath, maxFileSize, limit)
+	} else {
+		members, err = readTar(archivePath, maxFileSize, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members, nil
+}
+
+// readZip reads the regular files of a zip archive
+func readZip(archivePath string, maxFileSize int64, limit int64) ([]archiveMember, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrArchiveRejected, err)
+	}
+	defer reader.Close()
+	if len(reader.File) > maxArchiveEntries {
+		return nil, fmt.Errorf("%w: %d entries, limit %d", ErrArchiveRejected, len(reader.File), maxArchiveEntries)
+	}
+
+	var members []archiveMember
+	var expanded int64
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		member := archiveMember{Name: file.Name}
+		if maxFileSize > 0 && file.UncompressedSize64 > uint64(maxFileSize) {
+			member.Err = fmt.Errorf("%w: %s (%s, limit %s)", ErrFileTooLarge, file.Name,
+				humanReadableSize(int64(file.UncompressedSize64)), humanReadableSize(maxFileSize))
+			members = append(members, member)
+			continue
+		}
+		if file.CompressedSize64 > 0 && file.UncompressedSize64/file.CompressedSize64 > maxArchiveRatio {
+			return nil, fmt.Errorf("%w: %s is compressed more than %d:1 (possible archive bomb)", ErrArchiveRejected, file.Name, maxArchiveRatio)
+		}
+
+		// Declared sizes can lie, so the bytes actually decompressed are counted as well
+		rc, err := file.Open()
+		if err != nil {
+			member.Err = fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+			members = append(members, member)
+			continue
+		}
+		limiter := &expansionLimiter{r: rc, limit: limit - expanded}
+		member.Content, err = readMemberContent(limiter, file.Name, maxFileSize)
+		rc.Close()
+		expanded += limiter.read
+		if errors.Is(err, ErrArchiveRejected) {
+			return nil, err
+		}
+		member.Err = err
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// readTar reads the regular files of a tar archive, decompressing it first when it is gzipped
+func readTar(archivePath string, maxFileSize int64, limit int64) ([]archiveMember, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	defer file.Close()
+
+	var stream io.Reader = file
+	if archiveFormat(archivePath) == archiveTarGz {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrArchiveRejected, err)
+		}
+		defer gz.Close()
+		stream = gz
+	}
+	tr := tar.NewReader(&expansionLimiter{r: stream, limit: limit})
+
+	var members []archiveMember
+	for entries := 1; ; entries++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			if errors.Is(err, ErrArchiveRejected) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrArchiveRejected, err)
+		}
+		if entries > maxArchiveEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveRejected, maxArchiveEntries)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		member := archiveMember{Name: header.Name}
+		if maxFileSize > 0 && header.Size > maxFileSize {
+			member.Err = fmt.Errorf("%w: %s (%s, limit %s)", ErrFileTooLarge, header.Name,
+				humanReadableSize(header.Size), humanReadableSize(maxFileSize))
+			members = append(members, member)
+			continue
+		}
+		member.Content, err = readMemberContent(tr, header.Name, maxFileSize)
+		if errors.Is(err, ErrArchiveRejected) {
+			return nil, err
+		}
+		member.Err = err
+		members = append(members, member)
+	}
+}
+
+// readMemberContent reads one archive member, failing when it is larger than maxFileSize
+func readMemberContent(r io.Reader, name string, maxFileSize int64) ([]byte, error) {
+	if maxFileSize > 0 {
+		r = io.LimitReader(r, maxFileSize+1)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		if errors.Is(err, ErrArchiveRejected) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	if maxFileSize > 0 && int64(len(content)) > maxFileSize {
+		return nil, fmt.Errorf("%w: %s (more than %s)", ErrFileTooLarge, name, humanReadableSize(maxFileSize))
+	}
+	return content, nil
+}
+
+// cleanArchivePath normalizes a member path, rejecting absolute paths and paths leaving the archive
+func cleanArchivePath(name string) (string, bool) {
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// archiveIgnoreMatcher collects the .gitignore and .deepseekignore rules inside an archive.
+// Members are matched as if the archive were unpacked at the filesystem root.
+func archiveIgnoreMatcher(members []archiveMember) *ignoreMatcher {
+	matcher := newIgnoreMatcher()
+	for _, member := range members {
+		name, ok := cleanArchivePath(member.Name)
+		if ok && member.Err == nil && isIgnoreFile(path.Base(name)) {
+			matcher.loadRules("/"+path.Dir(name), bytes.NewReader(member.Content))
+		}
+	}
+	return matcher
+}
+
+// archiveMemberIgnored reports whether a member is excluded like a file found while expanding
+// a directory: it or one of its directories is ignored, or it lies inside a .git directory
+func archiveMemberIgnored(matcher *ignoreMatcher, name string) bool {
+	if isIgnoreFile(path.Base(name)) {
+		return true
+	}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i-1] == ".git" || matcher.ignored("/"+strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return matcher.ignored("/"+name, false)
+}
+
+// loadArchive unpacks an archive named in file_paths and runs its members through the validator,
+// given that loaded files are already part of the request. Members are reported as `archive!/path`
+// and embedded under their path inside the archive.
+func (s *DeepseekServer) loadArchive(ctx context.Context, validator *FileValidator, report *FileReport, archivePath string, loaded int) []*LoadedFile {
+	logger := getLoggerFromContext(ctx)
+
+	members, err := readArchive(archivePath, validator.fileTypes, validator.allowedTypes, validator.maxFileSize)
+	if err != nil {
+		logger.Warn("Excluding archive %s: %v", archivePath, err)
+		status := fileStatusForError(err)
+		if errors.Is(err, ErrArchiveRejected) {
+			status = fileRejectedSize
+		}
+		report.reject(archivePath, status, err.Error())
+		return nil
+	}
+	report.remove(archivePath)
+
+	matcher := archiveIgnoreMatcher(members)
+	var files []*LoadedFile
+	for _, member := range members {
+		name, ok := cleanArchivePath(member.Name)
+		if !ok {
+			report.add(archivePath+"!/"+member.Name, fileRejectedPath, "path points outside the archive")
+			continue
+		}
+		if archiveMemberIgnored(matcher, name) {
+			continue
+		}
+
+		reportPath := archivePath + "!/" + name
+		if s.config.MaxFilesPerRequest > 0 && loaded+len(files) >= s.config.MaxFilesPerRequest {
+			report.add(reportPath, fileSkipped, fmt.Sprintf("file limit reached (%d files per request)", s.config.MaxFilesPerRequest))
+			continue
+		}
+		report.include(reportPath, int64(len(member.Content)))
+
+		err := member.Err
+		var file *LoadedFile
+		if err == nil {
+			file, err = validator.Inspect(name, member.Content)
+		}
+		if err == nil {
+			err = validator.Admit([]*LoadedFile{file})
+		}
+		if err != nil {
+			report.reject(reportPath, fileStatusForError(err), err.Error())
+			continue
+		}
+		file.Archive = filepath.Base(archivePath)
+		file.Name = name
+		report.setLoaded(reportPath, []*LoadedFile{file})
+		files = append(files, file)
+	}
+
+	logger.Info("Unpacked %d of %d file(s) from archive %s", len(files), len(members), archivePath)
+	return files
+}
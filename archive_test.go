@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArchiveFile is a member written to a test archive
+type testArchiveFile struct {
+	name    string
+	content string
+}
+
+// writeZip creates a zip archive in dir holding the given files
+func writeZip(t *testing.T, dir, name string, files []testArchiveFile) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTar creates a tar archive in dir holding the given files, gzipped when the name ends in .tar.gz
+func writeTar(t *testing.T, dir, name string, files []testArchiveFile) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if strings.HasSuffix(name, ".gz") {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(file.content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadArchive(t *testing.T) {
+	dir := t.TempDir()
+	files := []testArchiveFile{{"src/main.go", "package main\n"}, {"README.md", "# readme\n"}}
+	manyEntries := make([]testArchiveFile, maxArchiveEntries+1)
+	for i := range manyEntries {
+		manyEntries[i] = testArchiveFile{fmt.Sprintf("f%d.txt", i), "x"}
+	}
+	defaultTypes := []string{"text/*", mimeZip, mimeTar, mimeGzip}
+
+	tests := []struct {
+		name         string
+		path         string
+		allowedTypes []string
+		maxFileSize  int64
+		wantErr      error
+		wantMembers  []string
+	}{
+		{"zip", writeZip(t, dir, "ok.zip", files), defaultTypes, 1 << 20, nil, []string{"README.md", "src/main.go"}},
+		{"tar", writeTar(t, dir, "ok.tar", files), defaultTypes, 1 << 20, nil, []string{"README.md", "src/main.go"}},
+		{"tar.gz", writeTar(t, dir, "ok.tar.gz", files), defaultTypes, 1 << 20, nil, []string{"README.md", "src/main.go"}},
+		{"every type allowed", writeZip(t, dir, "any.zip", files), nil, 1 << 20, nil, []string{"README.md", "src/main.go"}},
+		{"archive type not allowed", writeZip(t, dir, "typed.zip", files), []string{"text/*"}, 1 << 20, ErrFileTypeNotAllowed, nil},
+		{"tar.gz type not allowed", writeTar(t, dir, "typed.tar.gz", files), []string{"text/*", mimeTar}, 1 << 20, ErrFileTypeNotAllowed, nil},
+		{"archive larger than max file size", writeTar(t, dir, "big.tar", files), defaultTypes, 1024, ErrFileTooLarge, nil},
+		{"too many entries", writeZip(t, dir, "many.zip", manyEntries), defaultTypes, 10 << 20, ErrArchiveRejected, nil},
+		{"zip bomb", writeZip(t, dir, "bomb.zip", []testArchiveFile{{"zeros", strings.Repeat("\x00", 4<<20)}}), defaultTypes, 10 << 20, ErrArchiveRejected, nil},
+		{"tar.gz bomb", writeTar(t, dir, "bomb.tar.gz", []testArchiveFile{{"zeros", strings.Repeat("\x00", 4<<20)}}), defaultTypes, 10 << 20, ErrArchiveRejected, nil},
+		{"corrupt zip", filepath.Join(dir, "corrupt.zip"), defaultTypes, 1 << 20, ErrArchiveRejected, nil},
+		{"missing", filepath.Join(dir, "missing.zip"), defaultTypes, 1 << 20, ErrFileNotFound, nil},
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.zip"), []byte("PK\x03\x04 not really a zip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fileTypes := NewFileTypeRegistry(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members, err := readArchive(tt.path, fileTypes, tt.allowedTypes, tt.maxFileSize)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("readArchive error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readArchive: %v", err)
+			}
+			var names []string
+			for _, member := range members {
+				if member.Err != nil {
+					t.Errorf("member %s: %v", member.Name, member.Err)
+				}
+				names = append(names, member.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantMembers, ",") {
+				t.Errorf("members = %v, want %v", names, tt.wantMembers)
+			}
+		})
+	}
+}
+
+func TestReadArchiveMemberTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		writeZip(t, dir, "a.zip", []testArchiveFile{{"small.txt", "ok"}, {"large.txt", strings.Repeat("abcdefgh", 200)}}),
+		writeTar(t, dir, "a.tar.gz", []testArchiveFile{{"small.txt", "ok"}, {"large.txt", strings.Repeat("abcdefgh", 200)}}),
+	} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			members, err := readArchive(path, NewFileTypeRegistry(nil), nil, 1000)
+			if err != nil {
+				t.Fatalf("readArchive: %v", err)
+			}
+			if len(members) != 2 {
+				t.Fatalf("got %d members, want 2", len(members))
+			}
+			if members[0].Name != "large.txt" || !errors.Is(members[0].Err, ErrFileTooLarge) || members[0].Content != nil {
+				t.Errorf("large member = %+v, want ErrFileTooLarge without content", members[0])
+			}
+			if members[1].Err != nil || string(members[1].Content) != "ok" {
+				t.Errorf("small member = %+v", members[1])
+			}
+		})
+	}
+}
+
+func TestCleanArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"src/main.go", "src/main.go", true},
+		{"./src//main.go", "src/main.go", true},
+		{`src\main.go`, "src/main.go", true},
+		{"a/../b.go", "b.go", true},
+		{"../evil.go", "", false},
+		{"a/../../evil.go", "", false},
+		{"/etc/passwd", "", false},
+		{".", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := cleanArchivePath(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("cleanArchivePath(%q) = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
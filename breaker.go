@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"    // Requests are sent normally
+	breakerOpen     = "open"      // Requests fail fast until the cooldown has passed
+	breakerHalfOpen = "half_open" // A single probe request decides whether to close or reopen
+)
+
+// maxBreakerOutcomes bounds the number of request outcomes kept for the failure rate
+const maxBreakerOutcomes = 1000
+
+// CircuitOpenError is returned instead of sending a request while the circuit breaker is open
+type CircuitOpenError struct {
+	RetryIn time.Duration // Time until the breaker lets a probe request through
+	Reason  string        // Why the breaker opened
+	LastErr error         // The failure that opened the breaker
+}
+
+// Error implements the error interface
+func (e *CircuitOpenError) Error() string {
+	retry := "shortly"
+	if e.RetryIn > 0 {
+		retry = "in " + e.RetryIn.Round(time.Second).String()
+	}
+	msg := fmt.Sprintf("the DeepSeek API looks unavailable (%s), so requests fail fast for now; retry %s or check deepseek_health",
+		e.Reason, retry)
+	if e.LastErr != nil {
+		msg += fmt.Sprintf(". Last error: %v", e.LastErr)
+	}
+	return msg
+}
+
+// breakerOutcome is the result of one request within the failure rate window
+type breakerOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// BreakerStats is a snapshot of the circuit breaker's state and counters
+type BreakerStats struct {
+	State          string
+	Since          time.Time // When the breaker entered its state
+	WindowRequests int       // Requests within the window
+	WindowFailures int       // Failures within the window
+	Requests       int64     // Requests allowed since startup
+	Failures       int64     // Requests that failed because of the API since startup
+	Rejected       int64     // Requests refused while the breaker was open
+	Trips          int64     // Times the breaker opened
+	LastFailure    string
+	LastFailureAt  time.Time
+	RetryIn        time.Duration // Time until a probe is allowed, while open
+}
+
+// CircuitBreaker stops sending requests to the DeepSeek API while too many of the recent ones
+// failed. It opens when at least failureRate percent of the requests within the window failed,
+// fails fast for the cooldown, then lets one probe request through: its success closes the breaker
+// and its failure reopens it. Only failures that suggest the API is unavailable are counted.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	failureRate int // Percent; 0 disables the breaker
+	minRequests int
+	window      time.Duration
+	cooldown    time.Duration
+	now         func() time.Time
+
+	state      string
+	since      time.Time
+	probing    bool
+	outcomes   []breakerOutcome
+	openReason string
+	lastErr    error
+	lastErrAt  time.Time
+
+	requests int64
+	failures int64
+	rejected int64
+	trips    int64
+}
+
+// NewCircuitBreaker creates a closed circuit breaker from the configured thresholds
+func NewCircuitBreaker(config *Config) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureRate: config.BreakerFailureRate,
+		minRequests: max(config.BreakerMinRequests, 1),
+		window:      config.BreakerWindow,
+		cooldown:    config.BreakerCooldown,
+		now:         time.Now,
+		state:       breakerClosed,
+		since:       time.Now(),
+	}
+}
+
+// Enabled reports whether the breaker can open at all
+func (b *CircuitBreaker) Enabled() bool {
+	return b != nil && b.failureRate > 0
+}
+
+// Allow reports whether a request may be sent now, returning a CircuitOpenError if not, and whether
+// the request is the probe of a half-open breaker. Every allowed request must be followed by a call
+// to Record with its result.
+func (b *CircuitBreaker) Allow() (bool, error) {
+	if !b.Enabled() {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.since.Add(b.cooldown).Sub(now); wait > 0 {
+			b.rejected++
+			return false, &CircuitOpenError{RetryIn: wait, Reason: b.openReason, LastErr: b.lastErr}
+		}
+		b.setState(breakerHalfOpen, now)
+		fallthrough
+	case breakerHalfOpen:
+		// Only one probe is in flight at a time; other requests wait for its verdict
+		if b.probing {
+			b.rejected++
+			return false, &CircuitOpenError{Reason: "a probe request is checking whether it has recovered", LastErr: b.lastErr}
+		}
+		b.probing = true
+		b.requests++
+		return true, nil
+	}
+	b.requests++
+	return false, nil
+}
+
+// Record reports the result of a request allowed by Allow, and whether it was the probe. It returns
+// a CircuitOpenError when this failure opened the breaker, so that callers stop retrying, and err otherwise.
+func (b *CircuitBreaker) Record(ctx context.Context, probe bool, err error) error {
+	if !b.Enabled() {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if probe {
+		b.probing = false
+	}
+	// Requests the caller cancelled or that never reached the API say nothing about its health
+	failed := err != nil && breakerFailure(ctx, err)
+	var apiErr *DeepseekAPIError
+	if err != nil && !failed && (ctx.Err() != nil || !errors.As(err, &apiErr)) {
+		return err
+	}
+
+	if failed {
+		b.failures++
+		b.lastErr, b.lastErrAt = err, now
+	}
+	if probe {
+		if failed {
+			b.trip("the probe request failed", now)
+			return &CircuitOpenError{RetryIn: b.cooldown, Reason: b.openReason, LastErr: err}
+		}
+		b.setState(breakerClosed, now)
+		return err
+	}
+
+	b.outcomes = append(b.outcomes, breakerOutcome{at: now, failed: failed})
+	requests, failures := b.windowCounts(now)
+	if b.state == breakerClosed && failed && requests >= b.minRequests && failures*100 >= b.failureRate*requests {
+		b.trip(fmt.Sprintf("%d of the last %d requests failed within %s", failures, requests, b.window), now)
+		return &CircuitOpenError{RetryIn: b.cooldown, Reason: b.openReason, LastErr: err}
+	}
+	return err
+}
+
+// Stats returns a snapshot of the breaker's state and counters
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	stats := BreakerStats{
+		State:         b.state,
+		Since:         b.since,
+		Requests:      b.requests,
+		Failures:      b.failures,
+		Rejected:      b.rejected,
+		Trips:         b.trips,
+		LastFailureAt: b.lastErrAt,
+	}
+	stats.WindowRequests, stats.WindowFailures = b.windowCounts(now)
+	if b.lastErr != nil {
+		stats.LastFailure = b.lastErr.Error()
+	}
+	if b.state == breakerOpen {
+		stats.RetryIn = max(b.since.Add(b.cooldown).Sub(now), 0)
+	}
+	return stats
+}
+
+// trip opens the breaker
+func (b *CircuitBreaker) trip(reason string, now time.Time) {
+	b.trips++
+	b.openReason = reason
+	b.setState(breakerOpen, now)
+}
+
+// setState moves the breaker to a new state; the failure rate is measured afresh after it closes
+func (b *CircuitBreaker) setState(state string, now time.Time) {
+	if state == breakerClosed {
+		b.outcomes = nil
+	}
+	b.state, b.since = state, now
+}
+
+// windowCounts drops outcomes older than the window and counts the requests and failures left
+func (b *CircuitBreaker) windowCounts(now time.Time) (int, int) {
+	cutoff := now.Add(-b.window)
+	start := 0
+	for start < len(b.outcomes) && (b.outcomes[start].at.Before(cutoff) || len(b.outcomes)-start > maxBreakerOutcomes) {
+		start++
+	}
+	b.outcomes = b.outcomes[start:]
+
+	failures := 0
+	for _, outcome := range b.outcomes {
+		if outcome.failed {
+			failures++
+		}
+	}
+	return len(b.outcomes), failures
+}
+
+// breakerFailure reports whether an error suggests the API is unavailable: timeouts, broken
+// connections and server errors. Rate limits, invalid requests and cancelled calls do not count.
+func breakerFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *DeepseekAPIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Kind {
+		case apiErrorServer, apiErrorOverloaded, apiErrorTimeout, apiErrorNetwork:
+			return true
+		}
+		return false
+	}
+	return IsTimeoutError(err) || IsNetworkError(err)
+}
+
+// formatBreakerStats renders the breaker's state and counters as markdown
+func formatBreakerStats(stats BreakerStats, config *Config) string {
+	var sb strings.Builder
+	sb.WriteString("## Circuit Breaker\n\n")
+	if config.BreakerFailureRate <= 0 {
+		sb.WriteString("**State:** disabled (DEEPSEEK_BREAKER_FAILURE_RATE is 0)\n")
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("**State:** %s since %s", stats.State, stats.Since.Format(time.RFC3339)))
+	if stats.State == breakerOpen {
+		sb.WriteString(fmt.Sprintf(" (probe allowed in %s)", stats.RetryIn.Round(time.Second)))
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(fmt.Sprintf("**Opens at:** %d%% failures of at least %d requests within %s | **Cooldown:** %s\n\n",
+		config.BreakerFailureRate, max(config.BreakerMinRequests, 1), config.BreakerWindow, config.BreakerCooldown))
+
+	sb.WriteString("| Counter | Value |\n")
+	sb.WriteString("|---------|-------|\n")
+	sb.WriteString(fmt.Sprintf("| Requests in window | %d |\n", stats.WindowRequests))
+	sb.WriteString(fmt.Sprintf("| Failures in window | %d |\n", stats.WindowFailures))
+	sb.WriteString(fmt.Sprintf("| Requests since startup | %d |\n", stats.Requests))
+	sb.WriteString(fmt.Sprintf("| Failures since startup | %d |\n", stats.Failures))
+	sb.WriteString(fmt.Sprintf("| Rejected while open | %d |\n", stats.Rejected))
+	sb.WriteString(fmt.Sprintf("| Times opened | %d |\n", stats.Trips))
+
+	if stats.LastFailure != "" {
+		sb.WriteString(fmt.Sprintf("\n**Last failure** (%s): %s\n", stats.LastFailureAt.Format(time.RFC3339), stats.LastFailure))
+	}
+	return sb.String()
+}
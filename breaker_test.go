@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testBreaker creates a breaker that opens at failureRate percent failures of at least 4 requests
+// within a minute, with a clock the test moves forward
+func testBreaker(failureRate int) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(&Config{BreakerFailureRate: failureRate, BreakerMinRequests: 4, BreakerWindow: time.Minute, BreakerCooldown: 30 * time.Second})
+	b.now = func() time.Time { return now }
+	b.since = now
+	return b, &now
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	serverErr := &DeepseekAPIError{Kind: apiErrorServer}
+	rateLimited := &DeepseekAPIError{Kind: apiErrorRateLimited}
+	invalid := &DeepseekAPIError{Kind: apiErrorInvalidRequest}
+
+	// A step advances the clock, asks to send a request and records its result
+	type step struct {
+		advance      time.Duration
+		result       error
+		canceled     bool
+		wantRejected bool // Allow refuses the request
+		wantTrip     bool // Record returns a CircuitOpenError
+		wantState    string
+	}
+	fail := step{result: serverErr, wantState: breakerClosed}
+	ok := step{wantState: breakerClosed}
+	trip := step{result: serverErr, wantTrip: true, wantState: breakerOpen}
+	rejected := step{wantRejected: true, wantState: breakerOpen}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens at the failure rate", []step{ok, ok, fail, trip, rejected}},
+		{"stays closed below the failure rate", []step{ok, ok, ok, fail, ok, fail, ok}},
+		{"needs the minimum number of requests", []step{fail, fail, fail, trip}},
+		{"forgets failures outside the window", []step{fail, fail, fail, {advance: 2 * time.Minute, result: serverErr, wantState: breakerClosed}}},
+		{"probe success closes", []step{fail, fail, fail, trip,
+			{advance: 10 * time.Second, wantRejected: true, wantState: breakerOpen},
+			{advance: 20 * time.Second, wantState: breakerClosed},
+			fail, fail, fail, trip}},
+		{"probe failure reopens", []step{fail, fail, fail, trip,
+			{advance: 30 * time.Second, result: serverErr, wantTrip: true, wantState: breakerOpen},
+			{advance: 29 * time.Second, wantRejected: true, wantState: breakerOpen},
+			{advance: time.Second, wantState: breakerClosed}}},
+		{"rate limits and invalid requests do not count", []step{
+			{result: rateLimited, wantState: breakerClosed}, {result: rateLimited, wantState: breakerClosed},
+			{result: invalid, wantState: breakerClosed}, {result: invalid, wantState: breakerClosed},
+			fail, fail, ok}},
+		{"cancelled calls do not count", []step{
+			{result: serverErr, canceled: true, wantState: breakerClosed}, {result: serverErr, canceled: true, wantState: breakerClosed},
+			{result: serverErr, canceled: true, wantState: breakerClosed}, {result: serverErr, canceled: true, wantState: breakerClosed},
+			fail, fail, fail, trip}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, now := testBreaker(50)
+			for i, s := range tt.steps {
+				*now = now.Add(s.advance)
+				ctx := context.Background()
+				if s.canceled {
+					canceled, cancel := context.WithCancel(ctx)
+					cancel()
+					ctx = canceled
+				}
+
+				probe, err := b.Allow()
+				var openErr *CircuitOpenError
+				if rejected := errors.As(err, &openErr); rejected != s.wantRejected {
+					t.Fatalf("step %d: Allow error = %v, want rejected %v", i, err, s.wantRejected)
+				}
+				if err == nil {
+					err = b.Record(ctx, probe, s.result)
+					if tripped := errors.As(err, &openErr); tripped != s.wantTrip {
+						t.Fatalf("step %d: Record = %v, want the breaker opened %v", i, err, s.wantTrip)
+					}
+					if !s.wantTrip && err != s.result {
+						t.Errorf("step %d: Record = %v, want %v", i, err, s.result)
+					}
+				}
+				if state := b.Stats().State; state != s.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	b, now := testBreaker(50)
+	for i := 0; i < 4; i++ {
+		probe, _ := b.Allow()
+		b.Record(context.Background(), probe, &DeepseekAPIError{Kind: apiErrorTimeout})
+	}
+	*now = now.Add(time.Minute)
+
+	probe, err := b.Allow()
+	if err != nil || !probe {
+		t.Fatalf("first request after the cooldown: probe %v, error %v", probe, err)
+	}
+	// Other requests fail fast while the probe is in flight
+	if _, err := b.Allow(); err == nil {
+		t.Fatal("a second request was allowed while the probe is in flight")
+	}
+	if stats := b.Stats(); stats.State != breakerHalfOpen || stats.Rejected != 1 || stats.Trips != 1 || stats.Failures != 4 {
+		t.Errorf("stats = %+v", stats)
+	}
+	b.Record(context.Background(), probe, nil)
+	if stats := b.Stats(); stats.State != breakerClosed || stats.WindowRequests != 0 {
+		t.Errorf("after the probe succeeded: stats = %+v, want closed with a fresh window", stats)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b, _ := testBreaker(0)
+	for i := 0; i < 10; i++ {
+		probe, err := b.Allow()
+		if err != nil || probe {
+			t.Fatalf("request %d: probe %v, error %v", i, probe, err)
+		}
+		if err := b.Record(context.Background(), probe, &DeepseekAPIError{Kind: apiErrorServer}); errors.As(err, new(*CircuitOpenError)) {
+			t.Fatalf("request %d: a disabled breaker opened", i)
+		}
+	}
+
+	var nilBreaker *CircuitBreaker
+	if _, err := nilBreaker.Allow(); err != nil {
+		t.Errorf("nil breaker: %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Truncation strategies applied when a request does not fit the model's context window
+const (
+	truncateAuto      = "auto"
+	truncateDropFiles = "drop_files"
+	truncateHeadTail  = "head_tail"
+	truncateOutline   = "outline"
+	truncateNone      = "none"
+)
+
+// Token estimates for prompt parts that are not file content
+const (
+	fileHeaderTokens      = 20 // Markdown header and code fence around each embedded file
+	lineNumberTokens      = 3  // Line number prefix of each line when files are embedded with line numbers
+	messageOverheadTokens = 8  // Role and framing of each chat message
+	minHeadTailTokens     = 400
+	contextSafetyPercent  = 95 // Token estimates are approximate, so only this share of the window is used
+)
+
+// Markers left in files that were trimmed to fit the context window
+const (
+	trimmedOutline     = "outline only"
+	omittedLinesFormat = "... [lines %d-%d omitted to fit the context window] ...\n"
+)
+
+// parseTruncationStrategy validates the truncation argument, defaulting to auto
+func parseTruncationStrategy(raw interface{}) (string, error) {
+	strategy, _ := raw.(string)
+	switch strategy {
+	case "":
+		return truncateAuto, nil
+	case truncateAuto, truncateDropFiles, truncateHeadTail, truncateOutline, truncateNone:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("truncation must be one of %q, %q, %q, %q or %q",
+		truncateAuto, truncateDropFiles, truncateHeadTail, truncateOutline, truncateNone)
+}
+
+// TrimAction records how one file was shortened to fit the context window
+type TrimAction struct {
+	Path   string
+	Action string
+	Before int
+	After  int
+}
+
+// ContextBudget describes how a request's prompt fits the model's context window
+type ContextBudget struct {
+	Model         string
+	ContextWindow int
+	OutputReserve int
+	FixedTokens   int // System prompt, conversation history and question
+	FileTokens    int
+	Strategy      string
+	LineNumbers   bool // Whether files are embedded with line numbers, which take tokens of their own
+	Actions       []TrimAction
+}
+
+// available returns the number of tokens left for files
+func (b *ContextBudget) available() int {
+	return b.ContextWindow*contextSafetyPercent/100 - b.OutputReserve - b.FixedTokens
+}
+
+// fileTokens returns the estimated tokens a file takes once embedded in the prompt
+func (b *ContextBudget) fileTokens(file *LoadedFile) int {
+	tokens := file.Tokens + fileHeaderTokens
+	if b.LineNumbers && file.Trimmed != trimmedOutline {
+		tokens += countLines(file.Content) * lineNumberTokens
+	}
+	return tokens
+}
+
+// PromptTokens returns the estimated size of the whole prompt
+func (b *ContextBudget) PromptTokens() int {
+	return b.FixedTokens + b.FileTokens
+}
+
+// Format renders the budget and the trimming applied as markdown
+func (b *ContextBudget) Format() string {
+	var sb strings.Builder
+	sb.WriteString("## Context Budget\n\n")
+	sb.WriteString(fmt.Sprintf("**Model:** %s | **Context window:** %d | **Reserved for the answer:** %d | **Prompt:** ~%d tokens (files ~%d)\n",
+		b.Model, b.ContextWindow, b.OutputReserve, b.PromptTokens(), b.FileTokens))
+	if len(b.Actions) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("\nThe files did not fit, so the `%s` strategy trimmed them:\n\n", b.Strategy))
+	sb.WriteString("| File | Action | Tokens before | Tokens after |\n")
+	sb.WriteString("|------|--------|---------------|--------------|\n")
+	for _, action := range b.Actions {
+		sb.WriteString(fmt.Sprintf("| `%s` | %s | %d | %d |\n", action.Path, action.Action, action.Before, action.After))
+	}
+	return sb.String()
+}
+
+// newContextBudget sizes the window for a request to model whose non-file messages are given
+func (s *DeepseekServer) newContextBudget(model string, messages []deepseek.ChatCompletionMessage, strategy string) *ContextBudget {
+	capabilities := GetModelCapabilities(model)
+	budget := &ContextBudget{
+		Model:         model,
+		ContextWindow: capabilities.ContextWindow,
+		OutputReserve: capabilities.DefaultMaxOutput,
+		Strategy:      strategy,
+	}
+	if s.config.ContextWindow > 0 {
+		budget.ContextWindow = s.config.ContextWindow
+	}
+	for _, message := range messages {
+		budget.FixedTokens += deepseek.EstimateTokenCount(message.Content).EstimatedTokens + messageOverheadTokens
+	}
+	return budget
+}
+
+// fitContextWindow trims files until they fit the budget, applying the budget's strategy to the
+// lowest-priority files first. It returns the files to embed, or an error when they cannot fit.
+func fitContextWindow(files []LoadedFile, budget *ContextBudget) ([]LoadedFile, error) {
+	total := 0
+	for i := range files {
+		total += budget.fileTokens(&files[i])
+	}
+	budget.FileTokens = total
+	available := budget.available()
+	if total <= available {
+		return files, nil
+	}
+
+	// Visit files from the least to the most important: expanded before explicitly named files,
+	// later file_paths entries before earlier ones, larger files before smaller ones
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		fa, fb := files[order[a]], files[order[b]]
+		if fa.Explicit != fb.Explicit {
+			return !fa.Explicit
+		}
+		if fa.Priority != fb.Priority {
+			return fa.Priority > fb.Priority
+		}
+		return fa.Tokens > fb.Tokens
+	})
+
+	var steps []string
+	switch budget.Strategy {
+	case truncateAuto:
+		steps = []string{truncateOutline, truncateHeadTail, truncateDropFiles}
+	case truncateNone:
+	default:
+		steps = []string{budget.Strategy}
+	}
+
+	dropped := make([]bool, len(files))
+	for _, step := range steps {
+		for _, i := range order {
+			if total <= available {
+				break
+			}
+			if dropped[i] {
+				continue
+			}
+			file := &files[i]
+			before, cost := file.Tokens, budget.fileTokens(file)
+
+			switch step {
+			case truncateOutline:
+				// Automatic outlining is limited to files that were not named explicitly
+				if (budget.Strategy == truncateAuto && file.Explicit) || !outlineFile(file) {
+					continue
+				}
+			case truncateHeadTail:
+				if !headTailFile(file, before-(total-available)) {
+					continue
+				}
+			case truncateDropFiles:
+				dropped[i] = true
+				file.Tokens = 0
+				total -= cost
+				budget.Actions = append(budget.Actions, TrimAction{Path: file.Path, Action: "dropped", Before: before})
+				continue
+			}
+
+			total -= cost - budget.fileTokens(file)
+			budget.Actions = append(budget.Actions, TrimAction{Path: file.Path, Action: file.Trimmed, Before: before, After: file.Tokens})
+		}
+	}
+	budget.FileTokens = total
+
+	if total > available {
+		return nil, fmt.Errorf("the request needs ~%d tokens but %s accepts %d, including %d reserved for the answer; "+
+			"use a truncation strategy other than %q, select line ranges or symbols, or include fewer files",
+			budget.PromptTokens()+budget.OutputReserve, budget.Model, budget.ContextWindow, budget.OutputReserve, budget.Strategy)
+	}
+
+	var kept []LoadedFile
+	for i, file := range files {
+		if !dropped[i] {
+			kept = append(kept, file)
+		}
+	}
+	return kept, nil
+}
+
+// outlineFile replaces a file's content with the list of its top-level declarations.
+// It reports false when the file has no declarations or the outline would not be smaller.
+func outlineFile(file *LoadedFile) bool {
+	symbols := fileOutline(file.Path, file.Content)
+	if len(symbols) == 0 {
+		return false
+	}
+
+	offset := 0
+	if file.StartLine > 0 {
+		offset = file.StartLine - 1
+	}
+	var sb strings.Builder
+	for _, symbol := range symbols {
+		sb.WriteString(fmt.Sprintf("%s %s (lines %d-%d)\n", symbol.Kind, symbol.Name, symbol.StartLine+offset, symbol.EndLine+offset))
+	}
+	content := []byte(sb.String())
+	tokens := deepseek.EstimateTokenCount(sb.String()).EstimatedTokens
+	if tokens >= file.Tokens {
+		return false
+	}
+
+	file.Content = content
+	file.Tokens = tokens
+	file.Type.Language = "text"
+	file.Trimmed = trimmedOutline
+	return true
+}
+
+// headTailFile keeps the beginning and end of a file and omits its middle so that it takes about
+// target tokens, but never less than minHeadTailTokens. It reports false when nothing can be cut.
+func headTailFile(file *LoadedFile, target int) bool {
+	if target < minHeadTailTokens {
+		target = minHeadTailTokens
+	}
+	if file.Tokens <= target {
+		return false
+	}
+
+	lines := splitLinesKeepEnds(string(file.Content))
+	first := 1
+	if file.StartLine > 0 {
+		first = file.StartLine
+	}
+
+	// Token counts are not spread evenly over lines, so shrink again if the first cut is too large
+	keep := len(lines) * target / file.Tokens
+	var content string
+	var omittedFrom, omittedTo, tokens int
+	for attempt := 0; attempt < 5; attempt++ {
+		if keep >= len(lines)-1 || keep < 2 {
+			return false
+		}
+		head := keep * 2 / 3
+		tail := keep - head
+		omittedFrom, omittedTo = first+head, first+len(lines)-tail-1
+
+		var sb strings.Builder
+		for _, line := range lines[:head] {
+			sb.WriteString(line)
+		}
+		if !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fmt.Sprintf(omittedLinesFormat, omittedFrom, omittedTo))
+		for _, line := range lines[len(lines)-tail:] {
+			sb.WriteString(line)
+		}
+
+		content = sb.String()
+		tokens = deepseek.EstimateTokenCount(content).EstimatedTokens
+		if tokens <= target {
+			break
+		}
+		keep = keep*target/tokens - 1
+	}
+
+	file.Content = []byte(content)
+	file.Tokens = tokens
+	file.Trimmed = fmt.Sprintf("lines %d-%d omitted", omittedFrom, omittedTo)
+	file.OmitFrom, file.OmitTo = omittedFrom, omittedTo
+	return true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// budgetTestFile creates a loaded Go file with the given number of functions
+func budgetTestFile(path string, funcs int, explicit bool, priority int) LoadedFile {
+	var sb strings.Builder
+	sb.WriteString("package demo\n\n")
+	for i := 0; i < funcs; i++ {
+		sb.WriteString(fmt.Sprintf("func handler%d(input string) (string, error) {\n", i))
+		sb.WriteString("\tresult := strings.TrimSpace(input) + strings.Repeat(\"x\", 10)\n")
+		sb.WriteString("\tif result == \"\" {\n\t\treturn \"\", fmt.Errorf(\"empty input\")\n\t}\n")
+		sb.WriteString("\treturn result, nil\n}\n\n")
+	}
+	content := sb.String()
+	return LoadedFile{
+		Path:     path,
+		Content:  []byte(content),
+		Tokens:   deepseek.EstimateTokenCount(content).EstimatedTokens,
+		Explicit: explicit,
+		Priority: priority,
+	}
+}
+
+func TestFitContextWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		strategy    string
+		fit         float64 // Share of the files' tokens the window leaves room for
+		fixed       bool    // The fixed part of the prompt alone fills the window
+		wantKept    []string
+		wantActions []string // Path and start of the action of each trim, in order
+		wantErr     bool
+	}{
+		{name: "fits", strategy: truncateNone, fit: 1, wantKept: []string{"main.go", "pkg/a.go", "pkg/b.go"}},
+		{name: "none", strategy: truncateNone, fit: 0.7, wantErr: true},
+		{name: "drop files", strategy: truncateDropFiles, fit: 0.75,
+			wantKept: []string{"main.go", "pkg/b.go"}, wantActions: []string{"pkg/a.go: dropped"}},
+		{name: "outline", strategy: truncateOutline, fit: 0.75,
+			wantKept: []string{"main.go", "pkg/a.go", "pkg/b.go"}, wantActions: []string{"pkg/a.go: outline only"}},
+		{name: "head and tail", strategy: truncateHeadTail, fit: 0.75,
+			wantKept: []string{"main.go", "pkg/a.go", "pkg/b.go"}, wantActions: []string{"pkg/a.go: lines"}},
+		{name: "auto outlines expanded files first", strategy: truncateAuto, fit: 0.75,
+			wantKept: []string{"main.go", "pkg/a.go", "pkg/b.go"}, wantActions: []string{"pkg/a.go: outline only"}},
+		{name: "auto keeps explicit files whole while it can", strategy: truncateAuto, fit: 0.6,
+			wantKept: []string{"main.go", "pkg/a.go", "pkg/b.go"}, wantActions: []string{"pkg/a.go: outline only", "pkg/b.go: outline only"}},
+		{name: "auto trims explicit files last", strategy: truncateAuto, fit: 0.3,
+			wantKept: []string{"main.go", "pkg/a.go", "pkg/b.go"}, wantActions: []string{"pkg/a.go: outline only", "pkg/b.go: outline only", "main.go: lines"}},
+		{name: "fixed prompt too large", strategy: truncateAuto, fit: 1, fixed: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []LoadedFile{
+				budgetTestFile("main.go", 60, true, 0),
+				budgetTestFile("pkg/a.go", 40, false, 1),
+				budgetTestFile("pkg/b.go", 20, false, 1),
+			}
+			budget := &ContextBudget{Model: "test", Strategy: tt.strategy}
+			total := 0
+			for i := range files {
+				total += files[i].Tokens + fileHeaderTokens
+			}
+			budget.ContextWindow = int(float64(total)*tt.fit)*100/contextSafetyPercent + 1
+			if tt.fixed {
+				budget.FixedTokens = budget.ContextWindow
+			}
+
+			kept, err := fitContextWindow(files, budget)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fitContextWindow error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var paths []string
+			for _, file := range kept {
+				paths = append(paths, file.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.wantKept, ",") {
+				t.Errorf("kept %v, want %v", paths, tt.wantKept)
+			}
+			if len(budget.Actions) != len(tt.wantActions) {
+				t.Fatalf("actions = %+v, want %v", budget.Actions, tt.wantActions)
+			}
+			for i, action := range budget.Actions {
+				if got := action.Path + ": " + action.Action; !strings.HasPrefix(got, tt.wantActions[i]) {
+					t.Errorf("action %d = %q, want %q", i, got, tt.wantActions[i])
+				}
+			}
+			if budget.FileTokens > budget.available() {
+				t.Errorf("files take %d tokens, only %d available", budget.FileTokens, budget.available())
+			}
+		})
+	}
+}
+
+func TestHeadTailFile(t *testing.T) {
+	file := budgetTestFile("main.go", 60, true, 0)
+	file.StartLine = 101 // An excerpt keeps its real line numbers
+	before := file.Tokens
+
+	if !headTailFile(&file, before/3) {
+		t.Fatal("headTailFile did not trim the file")
+	}
+	if file.Tokens > max(before/3, minHeadTailTokens) {
+		t.Errorf("trimmed to %d tokens, target %d", file.Tokens, before/3)
+	}
+	content := string(file.Content)
+	if !strings.HasPrefix(content, "package demo\n") || !strings.HasSuffix(content, "}\n\n") {
+		t.Errorf("head or tail lost:\n%s", content)
+	}
+	var from, to int
+	if _, err := fmt.Sscanf(file.Trimmed, "lines %d-%d omitted", &from, &to); err != nil || from <= 101 || !strings.Contains(content, fmt.Sprintf("... [lines %d-%d omitted to fit the context window] ...\n", from, to)) {
+		t.Errorf("trimmed = %q, marker missing or not numbered from the excerpt's first line", file.Trimmed)
+	}
+	if file.OmitFrom != from || file.OmitTo != to {
+		t.Errorf("omitted range = %d-%d, want %d-%d", file.OmitFrom, file.OmitTo, from, to)
+	}
+
+	small := budgetTestFile("small.go", 2, true, 0)
+	if headTailFile(&small, small.Tokens) {
+		t.Error("headTailFile trimmed a file already within the target")
+	}
+}
+
+func TestParseTruncationStrategy(t *testing.T) {
+	tests := []struct {
+		raw     interface{}
+		want    string
+		wantErr bool
+	}{
+		{nil, truncateAuto, false},
+		{"", truncateAuto, false},
+		{"head_tail", truncateHeadTail, false},
+		{"none", truncateNone, false},
+		{"shrink", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseTruncationStrategy(tt.raw)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseTruncationStrategy(%v) = %q, %v; want %q, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
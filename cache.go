@@ -1,3 +1,256 @@
-// This file is intentionally empty as caching functionality has been removed
-// DeepSeek API doesn't support conversation caching like Gemini
 package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// CacheKeyParams holds everything that influences a completion and therefore its cache key
+type CacheKeyParams struct {
+	Model        string
+	SystemPrompt string
+	Query        string
+	Temperature  float32
+	JSONMode     bool
+	LineNumbers  bool     // whether files were embedded with line numbers
+	Prefix       string   // assistant prefix the answer must start with
+	FileHashes   []string // "path:sha256" for every included file, in prompt order
+}
+
+// CacheEntry is a cached DeepSeek completion
+type CacheEntry struct {
+	Key       string                           `json:"key"`
+	Model     string                           `json:"model"`
+	Response  *deepseek.ChatCompletionResponse `json:"response"`
+	Size      int64                            `json:"size"`
+	CreatedAt time.Time                        `json:"created_at"`
+	ExpiresAt time.Time                        `json:"expires_at"`
+}
+
+// ResponseCache is a size-bounded LRU cache of completions with an optional on-disk backend
+type ResponseCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List // front = most recently used
+	totalSize  int64
+	maxEntries int
+	maxSize    int64
+	dir        string // empty means memory only
+	logger     Logger
+}
+
+// cacheFilePattern matches the names of cache entry files: a cache key and .json
+var cacheFilePattern = regexp.MustCompile(`^[0-9a-f]{64}\.json$`)
+
+// NewResponseCache creates a new response cache. If dir is not empty, entries are
+// persisted there and loaded back at startup so the cache survives restarts.
+func NewResponseCache(maxEntries int, maxSize int64, dir string, logger Logger) (*ResponseCache, error) {
+	cache := &ResponseCache{
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		dir:        dir,
+		logger:     logger,
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory %s: %w", dir, err)
+		}
+		cache.loadFromDisk()
+	}
+
+	return cache, nil
+}
+
+// hashContent returns the hex-encoded SHA-256 of the content
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheKey derives a stable cache key from the request parameters
+func CacheKey(params CacheKeyParams) string {
+	h := sha256.New()
+	write := func(field string) {
+		// Length-prefix every field so adjacent fields cannot run into each other
+		fmt.Fprintf(h, "%d:%s\x00", len(field), field)
+	}
+	write(params.Model)
+	write(params.SystemPrompt)
+	write(params.Query)
+	write(fmt.Sprintf("%.4f", params.Temperature))
+	write(fmt.Sprintf("%t", params.JSONMode))
+	write(fmt.Sprintf("%t", params.LineNumbers))
+	write(params.Prefix)
+	for _, fileHash := range params.FileHashes {
+		write(fileHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns a non-expired cache entry for the key, or nil
+func (c *ResponseCache) Get(key string) *CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*CacheEntry)
+	if time.Now().After(entry.ExpiresAt) {
+		c.removeLocked(elem)
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+// Put stores a completion in the cache with the given TTL
+func (c *ResponseCache) Put(key string, model string, resp *deepseek.ChatCompletionResponse, ttl time.Duration) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		c.logger.Warn("Failed to serialize response for cache: %v", err)
+		return
+	}
+
+	now := time.Now()
+	entry := &CacheEntry{
+		Key:       key,
+		Model:     model,
+		Response:  resp,
+		Size:      int64(len(data)),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxSize > 0 && entry.Size > c.maxSize {
+		c.logger.Debug("Response of %s exceeds cache size limit, not caching", humanReadableSize(entry.Size))
+		return
+	}
+
+	c.addLocked(entry)
+	if c.dir != "" {
+		c.writeToDisk(entry)
+	}
+}
+
+// addLocked inserts an entry and evicts least recently used entries until the limits hold
+func (c *ResponseCache) addLocked(entry *CacheEntry) {
+	if elem, ok := c.entries[entry.Key]; ok {
+		c.removeLocked(elem)
+	}
+
+	c.entries[entry.Key] = c.lru.PushFront(entry)
+	c.totalSize += entry.Size
+
+	for c.lru.Len() > 1 &&
+		((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxSize > 0 && c.totalSize > c.maxSize)) {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// removeLocked removes an entry from memory and disk; the caller must hold the lock
+func (c *ResponseCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*CacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.Key)
+	c.totalSize -= entry.Size
+
+	if c.dir != "" {
+		if err := os.Remove(c.entryPath(entry.Key)); err != nil && !os.IsNotExist(err) {
+			c.logger.Warn("Failed to remove cache file for %s: %v", entry.Key, err)
+		}
+	}
+}
+
+// entryPath returns the on-disk location of a cache entry
+func (c *ResponseCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// writeToDisk persists an entry, writing to a temporary file first so readers never see partial data
+func (c *ResponseCache) writeToDisk(entry *CacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		c.logger.Warn("Failed to serialize cache entry: %v", err)
+		return
+	}
+
+	tmpPath := c.entryPath(entry.Key) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		c.logger.Warn("Failed to write cache file: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, c.entryPath(entry.Key)); err != nil {
+		c.logger.Warn("Failed to move cache file into place: %v", err)
+		_ = os.Remove(tmpPath)
+	}
+}
+
+// loadFromDisk restores non-expired entries from the cache directory, oldest first. Only files
+// named after a cache key are read, and only expired entries are removed, so that pointing
+// DEEPSEEK_CACHE_DIR at a directory holding other files never deletes them.
+func (c *ResponseCache) loadFromDisk() {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		c.logger.Warn("Failed to read cache directory %s: %v", c.dir, err)
+		return
+	}
+
+	var loaded []*CacheEntry
+	now := time.Now()
+	for _, file := range files {
+		if !file.Type().IsRegular() || !cacheFilePattern.MatchString(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(c.dir, file.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Response == nil || entry.Key+".json" != file.Name() {
+			c.logger.Warn("Skipping %s in the cache directory: not a cache entry", path)
+			continue
+		}
+		if now.After(entry.ExpiresAt) {
+			_ = os.Remove(path)
+			continue
+		}
+		loaded = append(loaded, &entry)
+	}
+
+	// Insert oldest first so the most recent entries survive eviction
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].CreatedAt.Before(loaded[j].CreatedAt)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range loaded {
+		c.addLocked(entry)
+	}
+
+	c.logger.Info("Loaded %d cached response(s) from %s", len(c.entries), c.dir)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+func testResponse(content string) *deepseek.ChatCompletionResponse {
+	return &deepseek.ChatCompletionResponse{
+		Choices: []deepseek.Choice{{Message: deepseek.Message{Role: "assistant", Content: content}}},
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := CacheKeyParams{Model: "deepseek-chat", SystemPrompt: "sys", Query: "q", Temperature: 0.4, FileHashes: []string{"a.go:1"}}
+
+	tests := []struct {
+		name   string
+		change func(p *CacheKeyParams)
+	}{
+		{"model", func(p *CacheKeyParams) { p.Model = "deepseek-reasoner" }},
+		{"system prompt", func(p *CacheKeyParams) { p.SystemPrompt = "other" }},
+		{"query", func(p *CacheKeyParams) { p.Query = "other" }},
+		{"temperature", func(p *CacheKeyParams) { p.Temperature = 0.5 }},
+		{"json mode", func(p *CacheKeyParams) { p.JSONMode = true }},
+		{"line numbers", func(p *CacheKeyParams) { p.LineNumbers = true }},
+		{"prefix", func(p *CacheKeyParams) { p.Prefix = "```go" }},
+		{"file content", func(p *CacheKeyParams) { p.FileHashes = []string{"a.go:2"} }},
+		{"adjacent fields", func(p *CacheKeyParams) { p.SystemPrompt, p.Query = "sy", "sq" }},
+	}
+
+	key := CacheKey(base)
+	if len(key) != 64 || CacheKey(base) != key {
+		t.Fatalf("CacheKey is not a stable SHA-256 hex digest: %q", key)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := base
+			params.FileHashes = append([]string(nil), base.FileHashes...)
+			tt.change(&params)
+			if CacheKey(params) == key {
+				t.Errorf("changing the %s did not change the key", tt.name)
+			}
+		})
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, err := NewResponseCache(2, 0, "", NewLogger(LevelError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("a", "m", testResponse("a"), time.Hour)
+	cache.Put("b", "m", testResponse("b"), time.Hour)
+	cache.Get("a")
+	cache.Put("c", "m", testResponse("c"), time.Hour)
+
+	if cache.Get("b") != nil {
+		t.Error("the least recently used entry was not evicted")
+	}
+	if cache.Get("a") == nil || cache.Get("c") == nil {
+		t.Error("recently used entries were evicted")
+	}
+
+	cache.Put("d", "m", testResponse("d"), -time.Second)
+	if cache.Get("d") != nil {
+		t.Error("an expired entry was returned")
+	}
+}
+
+func TestResponseCacheDiskLeavesOtherFilesAlone(t *testing.T) {
+	dir := t.TempDir()
+	logger := NewLogger(LevelError)
+
+	cache, err := NewResponseCache(10, 0, dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validKey := CacheKey(CacheKeyParams{Query: "valid"})
+	expiredKey := CacheKey(CacheKeyParams{Query: "expired"})
+	cache.Put(validKey, "m", testResponse("cached"), time.Hour)
+	cache.Put(expiredKey, "m", testResponse("old"), time.Millisecond)
+
+	// Files that only look like cache entries, or not at all, must survive a reload
+	foreign := map[string]string{
+		"package.json":                    `{"name": "app"}`,
+		"settings.json":                   `{"response": null}`,
+		strings.Repeat("a", 64) + ".json": "not json",
+		strings.Repeat("b", 64) + ".json": `{"key": "other", "response": {}}`,
+		strings.Repeat("C", 64) + ".json": `{}`,
+		"notes.txt":                       "hello",
+	}
+	for name, content := range foreign {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	reloaded, err := NewResponseCache(10, 0, dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := reloaded.Get(validKey)
+	if entry == nil || entry.Response.Choices[0].Message.Content != "cached" {
+		t.Fatalf("valid entry not restored: %+v", entry)
+	}
+	if reloaded.Get(expiredKey) != nil {
+		t.Error("expired entry was restored")
+	}
+	if _, err := os.Stat(filepath.Join(dir, expiredKey+".json")); !os.IsNotExist(err) {
+		t.Error("expired cache file was not removed")
+	}
+	for name, content := range foreign {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s was modified or removed: %v", name, err)
+		}
+	}
+
+	var onDisk CacheEntry
+	data, err := os.ReadFile(filepath.Join(dir, validKey+".json"))
+	if err != nil || json.Unmarshal(data, &onDisk) != nil || onDisk.Key != validKey {
+		t.Errorf("cache file for %s is not a cache entry: %v", validKey, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Citation statuses reported for each `path:line` reference in an answer
+const (
+	citationValid      = "valid"
+	citationNotShown   = "not_shown"         // The lines exist but were not part of the embedded excerpts
+	citationOutOfRange = "line_out_of_range" // The file has fewer lines
+	citationUnknown    = "unknown_file"      // No embedded file has this path
+	citationAmbiguous  = "ambiguous_file"    // The path matches several embedded files
+)
+
+var (
+	// citationPattern matches `path:line`, `path:start-end` and `path:line:column` references
+	citationPattern = regexp.MustCompile(`([\w./@+-]*[\w@+-]):(\d+)(?:[-–](\d+)|:\d+)?`)
+
+	// filePathPattern matches references that look like file paths rather than e.g. host:port
+	filePathPattern = regexp.MustCompile(`/|\.[A-Za-z]\w*$`)
+)
+
+// Citation is a reference to lines of an embedded file found in an answer
+type Citation struct {
+	Path      string // Path as written in the answer
+	File      string // Name of the embedded file the path refers to, if it could be resolved
+	StartLine int
+	EndLine   int
+	Status    string
+}
+
+// Location formats the citation as `path:line` or `path:start-end`, using the resolved file name if known
+func (c Citation) Location() string {
+	path := c.Path
+	if c.File != "" {
+		path = c.File
+	}
+	if c.EndLine != c.StartLine {
+		return fmt.Sprintf("%s:%d-%d", path, c.StartLine, c.EndLine)
+	}
+	return fmt.Sprintf("%s:%d", path, c.StartLine)
+}
+
+// extractCitations finds the `path:line` references in an answer and checks each against the files
+// embedded in the prompt. A path may be given as the file's full name or any unambiguous suffix of it.
+func extractCitations(answer string, files []LoadedFile) []Citation {
+	byName := make(map[string][]LoadedFile)
+	var names []string
+	for _, file := range files {
+		name := file.Name
+		if name == "" {
+			continue
+		}
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], file)
+	}
+
+	var citations []Citation
+	seen := make(map[string]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		citation := Citation{Path: match[1]}
+		citation.StartLine, _ = strconv.Atoi(match[2])
+		citation.EndLine = citation.StartLine
+		if match[3] != "" {
+			citation.EndLine, _ = strconv.Atoi(match[3])
+		}
+
+		// URLs such as http://host:port are not citations
+		if strings.Contains(citation.Path, "//") {
+			continue
+		}
+		name, status := resolveCitationPath(strings.TrimPrefix(citation.Path, "./"), names)
+		if status == citationUnknown && !filePathPattern.MatchString(citation.Path) {
+			continue
+		}
+		citation.File, citation.Status = name, status
+		if name != "" {
+			citation.Status = citationLineStatus(citation, byName[name])
+		}
+
+		key := citation.Location()
+		if !seen[key] {
+			seen[key] = true
+			citations = append(citations, citation)
+		}
+	}
+	return citations
+}
+
+// resolveCitationPath finds the embedded file a cited path refers to. An exact match wins; otherwise
+// the path must be a suffix of exactly one file name, or that name a suffix of an absolute path.
+func resolveCitationPath(path string, names []string) (string, string) {
+	var matches []string
+	for _, name := range names {
+		if name == path {
+			return name, citationValid
+		}
+		if strings.HasSuffix(name, "/"+path) || strings.HasSuffix(path, "/"+name) {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", citationUnknown
+	case 1:
+		return matches[0], citationValid
+	}
+	return "", citationAmbiguous
+}
+
+// citationLineStatus checks the cited lines against the file and the parts of it that were embedded.
+// Outlined files show no lines at all, and lines cut from the middle of a file were not shown either.
+func citationLineStatus(citation Citation, parts []LoadedFile) string {
+	lines := parts[0].Lines
+	if citation.StartLine < 1 || citation.EndLine < citation.StartLine || citation.EndLine > lines {
+		return citationOutOfRange
+	}
+	for _, part := range parts {
+		if part.Trimmed == trimmedOutline {
+			continue
+		}
+		if part.StartLine > 0 && (citation.StartLine < part.StartLine || citation.EndLine > part.EndLine) {
+			continue
+		}
+		if part.OmitFrom > 0 && citation.StartLine <= part.OmitTo && citation.EndLine >= part.OmitFrom {
+			continue
+		}
+		return citationValid
+	}
+	return citationNotShown
+}
+
+// formatCitations renders the citations found in an answer as a markdown table
+func formatCitations(citations []Citation) string {
+	valid := 0
+	for _, citation := range citations {
+		if citation.Status == citationValid {
+			valid++
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## Citations\n\n**Found:** %d | **Valid:** %d\n\n", len(citations), valid))
+	sb.WriteString("| File | Start | End | Status | Cited As |\n")
+	sb.WriteString("|------|-------|-----|--------|----------|\n")
+	for _, citation := range citations {
+		file := citation.File
+		if file == "" {
+			file = citation.Path
+		}
+		sb.WriteString(fmt.Sprintf("| `%s` | %d | %d | %s | `%s` |\n",
+			file, citation.StartLine, citation.EndLine, citation.Status, citation.Path))
+	}
+	return sb.String()
+}
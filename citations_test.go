@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractCitations(t *testing.T) {
+	files := []LoadedFile{
+		{Name: "cmd/server/main.go", Lines: 100},
+		{Name: "internal/a/util.go", Lines: 50},
+		{Name: "internal/b/util.go", Lines: 50},
+		{Name: "deepseek.go", Lines: 1000, StartLine: 240, EndLine: 330},
+		{Name: "deepseek.go", Lines: 1000, StartLine: 500, EndLine: 510},
+		{Name: "trimmed.go", Lines: 300, Trimmed: "lines 101-250 omitted", OmitFrom: 101, OmitTo: 250},
+		{Name: "outlined.go", Lines: 300, Trimmed: trimmedOutline},
+	}
+
+	tests := []struct {
+		name   string
+		answer string
+		want   []string // Location and status of each citation
+	}{
+		{"exact path", "See cmd/server/main.go:42.", []string{"cmd/server/main.go:42 valid"}},
+		{"range", "In `cmd/server/main.go:10-20` the loop", []string{"cmd/server/main.go:10-20 valid"}},
+		{"en dash range", "cmd/server/main.go:10–20", []string{"cmd/server/main.go:10-20 valid"}},
+		{"line and column", "cmd/server/main.go:42:7: error", []string{"cmd/server/main.go:42 valid"}},
+		{"unambiguous suffix", "server/main.go:3 and ./cmd/server/main.go:4", []string{"cmd/server/main.go:3 valid", "cmd/server/main.go:4 valid"}},
+		{"absolute path", "/home/dev/project/cmd/server/main.go:5", []string{"cmd/server/main.go:5 valid"}},
+		{"ambiguous suffix", "util.go:7", []string{"util.go:7 ambiguous_file"}},
+		{"line out of range", "cmd/server/main.go:101", []string{"cmd/server/main.go:101 line_out_of_range"}},
+		{"inverted range", "cmd/server/main.go:20-10", []string{"cmd/server/main.go:20-10 line_out_of_range"}},
+		{"inside an excerpt", "deepseek.go:250 and deepseek.go:505", []string{"deepseek.go:250 valid", "deepseek.go:505 valid"}},
+		{"outside the excerpts", "deepseek.go:400", []string{"deepseek.go:400 not_shown"}},
+		{"across excerpts", "deepseek.go:320-505", []string{"deepseek.go:320-505 not_shown"}},
+		{"kept head and tail", "trimmed.go:100 and trimmed.go:251-260", []string{"trimmed.go:100 valid", "trimmed.go:251-260 valid"}},
+		{"omitted middle", "trimmed.go:150", []string{"trimmed.go:150 not_shown"}},
+		{"overlapping the omitted middle", "trimmed.go:95-105", []string{"trimmed.go:95-105 not_shown"}},
+		{"outlined file", "outlined.go:12", []string{"outlined.go:12 not_shown"}},
+		{"unknown file", "other/file.go:12", []string{"other/file.go:12 unknown_file"}},
+		{"duplicates", "main.go:1, cmd/server/main.go:1 and server/main.go:1", []string{"cmd/server/main.go:1 valid"}},
+		{"not citations", "Listen on localhost:8080, see http://example.com:80/docs and ratio 16:9", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, citation := range extractCitations(tt.answer, files) {
+				got = append(got, citation.Location()+" "+citation.Status)
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("citations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCitations(t *testing.T) {
+	citations := []Citation{
+		{Path: "main.go", File: "cmd/server/main.go", StartLine: 3, EndLine: 3, Status: citationValid},
+		{Path: "util.go", StartLine: 7, EndLine: 9, Status: citationAmbiguous},
+	}
+	got := formatCitations(citations)
+	for _, want := range []string{
+		"**Found:** 2 | **Valid:** 1",
+		"| `cmd/server/main.go` | 3 | 3 | valid | `main.go` |",
+		"| `util.go` | 7 | 9 | ambiguous_file | `util.go` |",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatCitations output does not contain %q:\n%s", want, got)
+		}
+	}
+}
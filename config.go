@@ -3,7 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -13,15 +16,65 @@ import (
 type Config struct {
 	// API configuration
 	DeepseekAPIKey       string
+	DeepseekBaseURL      string
 	DeepseekModel        string
 	DeepseekSystemPrompt string
 	MaxFileSize          int64
+	MaxFilesPerRequest   int
+	MaxTotalFileSize     int64
+	MaxRequestTokens     int
+	ContextWindow        int
+	AnalyzeMaxTokens     int
 	AllowedFileTypes     []string
+	FileTypeOverrides    map[string]FileType
+	RedactionMode        string
+	RedactionAllow       []*regexp.Regexp
+	RedactionDeny        []*regexp.Regexp
+	RedactionKey         string // Secret placeholders are derived from; empty uses RedactionKeyFile
+	RedactionKeyFile     string // File holding a generated key ("" for a new key on every start)
 	DeepseekTemperature  float32
 	HTTPTimeout          time.Duration
 	MaxRetries           int
 	InitialBackoff       time.Duration
 	MaxBackoff           time.Duration
+	BreakerFailureRate   int
+	BreakerMinRequests   int
+	BreakerWindow        time.Duration
+	BreakerCooldown      time.Duration
+	RateLimitRPM         int
+	RateLimitTPM         int
+	QueueSize            int
+	QueueTimeout         time.Duration
+	MaxConversations     int
+	EnableCaching        bool
+	DefaultCacheTTL      time.Duration
+	CacheMaxEntries      int
+	CacheMaxSize         int64
+	CacheDir             string
+	EnableStreaming      bool
+	WorkspaceRoots       []string // Explicitly configured roots; the client's MCP roots are ignored when set
+	DefaultWorkspaceRoot string   // Root used when none are configured or provided by the client ("" for none)
+	AgentMaxSteps        int
+	AgentMaxTokens       int
+}
+
+// parseBaseURL checks that an API base URL is an absolute http or https URL. The client library
+// does not report invalid URLs, so they would only fail on the first request.
+func parseBaseURL(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%q must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%q has no host", raw)
+	}
+	return raw, nil
 }
 
 // NewConfig creates a new configuration instance from environment variables
@@ -32,6 +85,12 @@ func NewConfig() (*Config, error) {
 		return nil, errors.New("DEEPSEEK_API_KEY environment variable is required")
 	}
 
+	// Read base URL (optional, defaults to the DeepSeek API used by the client library)
+	baseURL, err := parseBaseURL(os.Getenv("DEEPSEEK_BASE_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEEPSEEK_BASE_URL: %w", err)
+	}
+
 	// Read model (optional, defaults to "deepseek-chat")
 	model := os.Getenv("DEEPSEEK_MODEL")
 	if model == "" {
@@ -69,19 +128,94 @@ func NewConfig() (*Config, error) {
 		}
 	}
 
-	// Read allowed file types (optional, defaults to common code file types)
+	// Read per-request file limits (optional, defaults to 100 files and 20MB in total)
+	maxFilesPerRequest := 100
+	if maxFilesStr := os.Getenv("DEEPSEEK_MAX_FILES"); maxFilesStr != "" {
+		var err error
+		maxFilesPerRequest, err = strconv.Atoi(maxFilesStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_MAX_FILES: %w", err)
+		}
+	}
+
+	var maxTotalFileSize int64 = 20 * 1024 * 1024
+	if maxTotalFileSizeStr := os.Getenv("DEEPSEEK_MAX_TOTAL_FILE_SIZE"); maxTotalFileSizeStr != "" {
+		var err error
+		maxTotalFileSize, err = strconv.ParseInt(maxTotalFileSizeStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_MAX_TOTAL_FILE_SIZE: %w", err)
+		}
+	}
+
+	// Read the per-request token budget for included files (optional, defaults to 100000 tokens)
+	maxRequestTokens := 100000
+	if maxRequestTokensStr := os.Getenv("DEEPSEEK_MAX_REQUEST_TOKENS"); maxRequestTokensStr != "" {
+		var err error
+		maxRequestTokens, err = strconv.Atoi(maxRequestTokensStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_MAX_REQUEST_TOKENS: %w", err)
+		}
+	}
+
+	// Read the context window override (optional, 0 uses each model's own limit)
+	contextWindow := 0
+	if contextWindowStr := os.Getenv("DEEPSEEK_CONTEXT_WINDOW"); contextWindowStr != "" {
+		var err error
+		contextWindow, err = strconv.Atoi(contextWindowStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_CONTEXT_WINDOW: %w", err)
+		}
+	}
+
+	// Read the token budget for deepseek_analyze_large inputs (optional, defaults to 2000000 tokens)
+	analyzeMaxTokens := 2000000
+	if analyzeMaxTokensStr := os.Getenv("DEEPSEEK_ANALYZE_MAX_TOKENS"); analyzeMaxTokensStr != "" {
+		var err error
+		analyzeMaxTokens, err = strconv.Atoi(analyzeMaxTokensStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_ANALYZE_MAX_TOKENS: %w", err)
+		}
+	}
+
+	// Read allowed file types (optional, defaults to all text types, JSON, XML, the documents text is extracted from
+	// and the archives that are unpacked). A type ending in "/*" allows every subtype.
 	allowedFileTypesStr := os.Getenv("DEEPSEEK_ALLOWED_FILE_TYPES")
 	var allowedFileTypes []string
 	if allowedFileTypesStr == "" {
 		// Default allowed file types
-		allowedFileTypes = []string{
-			"text/plain", "text/x-go", "text/x-python", "text/javascript",
-			"text/markdown", "text/x-java", "text/x-c", "text/x-c++",
-			"text/csv", "application/json", "text/x-yaml", "text/x-toml",
-			"text/html", "text/css", "application/xml",
-		}
+		allowedFileTypes = []string{"text/*", "application/json", "application/xml", "image/svg+xml",
+			mimeNotebook, mimePDF, mimeDocx, mimeXlsx, mimeZip, mimeTar, mimeGzip}
 	} else {
-		allowedFileTypes = strings.Split(allowedFileTypesStr, ",")
+		for _, fileType := range strings.Split(allowedFileTypesStr, ",") {
+			if fileType = strings.TrimSpace(fileType); fileType != "" {
+				allowedFileTypes = append(allowedFileTypes, fileType)
+			}
+		}
+	}
+
+	// Read custom file types (optional), e.g. "vue=text/x-vue:vue,tpl=text/plain"
+	fileTypeOverrides, err := parseFileTypeOverrides(os.Getenv("DEEPSEEK_FILE_TYPES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEEPSEEK_FILE_TYPES: %w", err)
+	}
+
+	// Read secret redaction settings (optional, defaults to redacting with the built-in patterns).
+	// Allow and deny lists are comma-separated regular expressions.
+	redactionMode, err := parseRedactionMode(os.Getenv("DEEPSEEK_REDACTION"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEEPSEEK_REDACTION: %w", err)
+	}
+	redactionAllow, err := parseRedactionPatterns(os.Getenv("DEEPSEEK_REDACTION_ALLOW"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEEPSEEK_REDACTION_ALLOW: %w", err)
+	}
+	redactionDeny, err := parseRedactionPatterns(os.Getenv("DEEPSEEK_REDACTION_DENY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEEPSEEK_REDACTION_DENY: %w", err)
+	}
+	redactionKey := os.Getenv("DEEPSEEK_REDACTION_KEY")
+	if redactionKey != "" && len(redactionKey) < minRedactionKeyLength {
+		return nil, fmt.Errorf("invalid DEEPSEEK_REDACTION_KEY: must be at least %d characters", minRedactionKeyLength)
 	}
 
 	// Read temperature (optional, defaults to 0.4)
@@ -139,16 +273,246 @@ func NewConfig() (*Config, error) {
 		}
 	}
 
+	// Read circuit breaker failure rate in percent (optional, defaults to 50, 0 disables the breaker)
+	breakerFailureRateStr := os.Getenv("DEEPSEEK_BREAKER_FAILURE_RATE")
+	breakerFailureRate := 50
+	if breakerFailureRateStr != "" {
+		var err error
+		breakerFailureRate, err = strconv.Atoi(breakerFailureRateStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_BREAKER_FAILURE_RATE: %w", err)
+		}
+	}
+
+	// Read the number of requests in the window before the breaker may open (optional, defaults to 5)
+	breakerMinRequestsStr := os.Getenv("DEEPSEEK_BREAKER_MIN_REQUESTS")
+	breakerMinRequests := 5
+	if breakerMinRequestsStr != "" {
+		var err error
+		breakerMinRequests, err = strconv.Atoi(breakerMinRequestsStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_BREAKER_MIN_REQUESTS: %w", err)
+		}
+	}
+
+	// Read the window over which the failure rate is measured (optional, defaults to 1 minute)
+	breakerWindowStr := os.Getenv("DEEPSEEK_BREAKER_WINDOW")
+	breakerWindow := time.Minute
+	if breakerWindowStr != "" {
+		var err error
+		breakerWindow, err = time.ParseDuration(breakerWindowStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_BREAKER_WINDOW: %w", err)
+		}
+	}
+
+	// Read how long the breaker stays open before probing the API again (optional, defaults to 30 seconds)
+	breakerCooldownStr := os.Getenv("DEEPSEEK_BREAKER_COOLDOWN")
+	breakerCooldown := 30 * time.Second
+	if breakerCooldownStr != "" {
+		var err error
+		breakerCooldown, err = time.ParseDuration(breakerCooldownStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_BREAKER_COOLDOWN: %w", err)
+		}
+	}
+
+	// Read requests per minute sent to the API (optional, defaults to 0, which means unlimited)
+	rateLimitRPMStr := os.Getenv("DEEPSEEK_RATE_LIMIT_RPM")
+	rateLimitRPM := 0
+	if rateLimitRPMStr != "" {
+		var err error
+		rateLimitRPM, err = strconv.Atoi(rateLimitRPMStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_RATE_LIMIT_RPM: %w", err)
+		}
+	}
+
+	// Read estimated tokens per minute sent to the API (optional, defaults to 0, which means unlimited)
+	rateLimitTPMStr := os.Getenv("DEEPSEEK_RATE_LIMIT_TPM")
+	rateLimitTPM := 0
+	if rateLimitTPMStr != "" {
+		var err error
+		rateLimitTPM, err = strconv.Atoi(rateLimitTPMStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_RATE_LIMIT_TPM: %w", err)
+		}
+	}
+
+	// Read the number of requests that may wait for the rate limits (optional, defaults to 100)
+	queueSizeStr := os.Getenv("DEEPSEEK_QUEUE_SIZE")
+	queueSize := 100
+	if queueSizeStr != "" {
+		var err error
+		queueSize, err = strconv.Atoi(queueSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_QUEUE_SIZE: %w", err)
+		}
+	}
+
+	// Read how long a request may wait for the rate limits (optional, defaults to 2 minutes, 0 waits indefinitely)
+	queueTimeoutStr := os.Getenv("DEEPSEEK_QUEUE_TIMEOUT")
+	queueTimeout := 2 * time.Minute
+	if queueTimeoutStr != "" {
+		var err error
+		queueTimeout, err = time.ParseDuration(queueTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_QUEUE_TIMEOUT: %w", err)
+		}
+	}
+
+	// Read max conversations (optional, defaults to 100)
+	maxConversationsStr := os.Getenv("DEEPSEEK_MAX_CONVERSATIONS")
+	maxConversations := 100
+	if maxConversationsStr != "" {
+		var err error
+		maxConversations, err = strconv.Atoi(maxConversationsStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_MAX_CONVERSATIONS: %w", err)
+		}
+	}
+
+	// Read caching settings (optional, caching defaults to enabled with a 1 hour TTL)
+	enableCaching := true
+	if enableCachingStr := os.Getenv("DEEPSEEK_ENABLE_CACHING"); enableCachingStr != "" {
+		var err error
+		enableCaching, err = strconv.ParseBool(enableCachingStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_ENABLE_CACHING: %w", err)
+		}
+	}
+
+	defaultCacheTTL := 1 * time.Hour
+	if cacheTTLStr := os.Getenv("DEEPSEEK_DEFAULT_CACHE_TTL"); cacheTTLStr != "" {
+		var err error
+		defaultCacheTTL, err = time.ParseDuration(cacheTTLStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_DEFAULT_CACHE_TTL: %w", err)
+		}
+	}
+
+	// Read cache limits (optional, defaults to 500 entries and 50MB)
+	cacheMaxEntries := 500
+	if cacheMaxEntriesStr := os.Getenv("DEEPSEEK_CACHE_MAX_ENTRIES"); cacheMaxEntriesStr != "" {
+		var err error
+		cacheMaxEntries, err = strconv.Atoi(cacheMaxEntriesStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_CACHE_MAX_ENTRIES: %w", err)
+		}
+	}
+
+	var cacheMaxSize int64 = 50 * 1024 * 1024
+	if cacheMaxSizeStr := os.Getenv("DEEPSEEK_CACHE_MAX_SIZE"); cacheMaxSizeStr != "" {
+		var err error
+		cacheMaxSize, err = strconv.ParseInt(cacheMaxSizeStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_CACHE_MAX_SIZE: %w", err)
+		}
+	}
+
+	// Read cache directory (optional, empty keeps the cache in memory only)
+	cacheDir := os.Getenv("DEEPSEEK_CACHE_DIR")
+
+	// Without a configured redaction key, a generated one is kept in the cache directory, or else
+	// in the user's cache directory, so that placeholders stay the same across restarts
+	var redactionKeyFile string
+	if cacheDir != "" {
+		redactionKeyFile = filepath.Join(cacheDir, redactionKeyFileName)
+	} else if userCacheDir, err := os.UserCacheDir(); err == nil {
+		redactionKeyFile = filepath.Join(userCacheDir, "deepseek-mcp", redactionKeyFileName)
+	}
+
+	// Read streaming setting (optional, defaults to enabled)
+	enableStreaming := true
+	if enableStreamingStr := os.Getenv("DEEPSEEK_ENABLE_STREAMING"); enableStreamingStr != "" {
+		var err error
+		enableStreaming, err = strconv.ParseBool(enableStreamingStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_ENABLE_STREAMING: %w", err)
+		}
+	}
+
+	// Read workspace roots (optional, defaults to the client's MCP roots, or else the current
+	// working directory unless it is / or the home directory)
+	var workspaceRoots []string
+	var defaultWorkspaceRoot string
+	if workspaceRootsStr := os.Getenv("DEEPSEEK_WORKSPACE_ROOTS"); workspaceRootsStr != "" {
+		for _, root := range strings.Split(workspaceRootsStr, ",") {
+			if root = strings.TrimSpace(root); root != "" {
+				workspaceRoots = append(workspaceRoots, root)
+			}
+		}
+	} else {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine working directory for workspace root: %w", err)
+		}
+		// MCP clients often start servers from / or the home directory, which expose far too much
+		if !isBroadRoot(cwd) {
+			defaultWorkspaceRoot = cwd
+		}
+	}
+
+	// Read agent budgets (optional, defaults to 10 tool steps and 100000 tokens)
+	agentMaxSteps := 10
+	if agentMaxStepsStr := os.Getenv("DEEPSEEK_AGENT_MAX_STEPS"); agentMaxStepsStr != "" {
+		var err error
+		agentMaxSteps, err = strconv.Atoi(agentMaxStepsStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_AGENT_MAX_STEPS: %w", err)
+		}
+	}
+
+	agentMaxTokens := 100000
+	if agentMaxTokensStr := os.Getenv("DEEPSEEK_AGENT_MAX_TOKENS"); agentMaxTokensStr != "" {
+		var err error
+		agentMaxTokens, err = strconv.Atoi(agentMaxTokensStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEEPSEEK_AGENT_MAX_TOKENS: %w", err)
+		}
+	}
+
 	return &Config{
 		DeepseekAPIKey:       apiKey,
+		DeepseekBaseURL:      baseURL,
 		DeepseekModel:        model,
 		DeepseekSystemPrompt: systemPrompt,
 		MaxFileSize:          maxFileSize,
+		MaxFilesPerRequest:   maxFilesPerRequest,
+		MaxTotalFileSize:     maxTotalFileSize,
+		MaxRequestTokens:     maxRequestTokens,
+		ContextWindow:        contextWindow,
+		AnalyzeMaxTokens:     analyzeMaxTokens,
 		AllowedFileTypes:     allowedFileTypes,
+		FileTypeOverrides:    fileTypeOverrides,
+		RedactionMode:        redactionMode,
+		RedactionAllow:       redactionAllow,
+		RedactionDeny:        redactionDeny,
+		RedactionKey:         redactionKey,
+		RedactionKeyFile:     redactionKeyFile,
 		DeepseekTemperature:  temperature,
 		HTTPTimeout:          timeout,
 		MaxRetries:           maxRetries,
 		InitialBackoff:       initialBackoff,
 		MaxBackoff:           maxBackoff,
+		BreakerFailureRate:   breakerFailureRate,
+		BreakerMinRequests:   breakerMinRequests,
+		BreakerWindow:        breakerWindow,
+		BreakerCooldown:      breakerCooldown,
+		RateLimitRPM:         rateLimitRPM,
+		RateLimitTPM:         rateLimitTPM,
+		QueueSize:            queueSize,
+		QueueTimeout:         queueTimeout,
+		MaxConversations:     maxConversations,
+		EnableCaching:        enableCaching,
+		DefaultCacheTTL:      defaultCacheTTL,
+		CacheMaxEntries:      cacheMaxEntries,
+		CacheMaxSize:         cacheMaxSize,
+		CacheDir:             cacheDir,
+		EnableStreaming:      enableStreaming,
+		WorkspaceRoots:       workspaceRoots,
+		DefaultWorkspaceRoot: defaultWorkspaceRoot,
+		AgentMaxSteps:        agentMaxSteps,
+		AgentMaxTokens:       agentMaxTokens,
 	}, nil
 }
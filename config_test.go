@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseBaseURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{"", false},
+		{"https://api.deepseek.com/", false},
+		{"http://localhost:8080/v1", false},
+		{"api.deepseek.com", true},
+		{"localhost:8080", true},
+		{"ftp://example.com/", true},
+		{"https://", true},
+		{"http://[::1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseBaseURL(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBaseURL(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.raw {
+				t.Errorf("parseBaseURL(%q) = %q", tt.raw, got)
+			}
+		})
+	}
+}
+
+func TestNewConfigRejectsInvalidBaseURL(t *testing.T) {
+	t.Setenv("DEEPSEEK_API_KEY", "key")
+	t.Setenv("DEEPSEEK_BASE_URL", "api.deepseek.com")
+	if _, err := NewConfig(); err == nil {
+		t.Error("NewConfig accepted a base URL without a scheme")
+	}
+}
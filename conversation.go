@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// Conversation holds the message history of a multi-turn deepseek_ask session
+type Conversation struct {
+	ID        string
+	Model     string
+	Messages  []deepseek.ChatCompletionMessage
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ConversationSummary is a lightweight view of a conversation used for listings
+type ConversationSummary struct {
+	ID           string
+	Model        string
+	MessageCount int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// ConversationStore keeps conversations in memory, evicting the least recently
+// updated conversation when the configured maximum is reached
+type ConversationStore struct {
+	mu            sync.RWMutex
+	conversations map[string]*Conversation
+	turns         map[string]*turnLock // Locks of conversations with a turn in progress
+	maxSize       int
+}
+
+// turnLock serializes the turns of one conversation
+type turnLock struct {
+	held chan struct{}
+	refs int // Turns holding or waiting for the lock
+}
+
+// NewConversationStore creates a new conversation store
+func NewConversationStore(maxSize int) *ConversationStore {
+	return &ConversationStore{
+		conversations: make(map[string]*Conversation),
+		turns:         make(map[string]*turnLock),
+		maxSize:       maxSize,
+	}
+}
+
+// LockTurn waits until no other turn of the conversation is in progress, so that concurrent
+// requests each build on the other's reply instead of overwriting it. It returns the function
+// that ends the turn, or an error if ctx is done first.
+func (cs *ConversationStore) LockTurn(ctx context.Context, id string) (func(), error) {
+	cs.mu.Lock()
+	lock, ok := cs.turns[id]
+	if !ok {
+		lock = &turnLock{held: make(chan struct{}, 1)}
+		cs.turns[id] = lock
+	}
+	lock.refs++
+	cs.mu.Unlock()
+
+	release := func() {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(cs.turns, id)
+		}
+	}
+
+	select {
+	case lock.held <- struct{}{}:
+		return func() {
+			<-lock.held
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, fmt.Errorf("waiting for the previous turn of conversation %s: %w", id, ctx.Err())
+	}
+}
+
+// Get returns a copy of the conversation with the given ID, or nil if it does not exist
+func (cs *ConversationStore) Get(id string) *Conversation {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	conv, ok := cs.conversations[id]
+	if !ok {
+		return nil
+	}
+	return conv.clone()
+}
+
+// Save stores the conversation, replacing any previous version with the same ID
+func (cs *ConversationStore) Save(conv *Conversation) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, exists := cs.conversations[conv.ID]; !exists && cs.maxSize > 0 && len(cs.conversations) >= cs.maxSize {
+		cs.evictOldestLocked()
+	}
+	cs.conversations[conv.ID] = conv.clone()
+}
+
+// Delete removes a conversation and reports whether it existed
+func (cs *ConversationStore) Delete(id string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, ok := cs.conversations[id]; !ok {
+		return false
+	}
+	delete(cs.conversations, id)
+	return true
+}
+
+// List returns summaries of all conversations, most recently updated first
+func (cs *ConversationStore) List() []ConversationSummary {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	summaries := make([]ConversationSummary, 0, len(cs.conversations))
+	for _, conv := range cs.conversations {
+		summaries = append(summaries, ConversationSummary{
+			ID:           conv.ID,
+			Model:        conv.Model,
+			MessageCount: len(conv.Messages),
+			CreatedAt:    conv.CreatedAt,
+			UpdatedAt:    conv.UpdatedAt,
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+	return summaries
+}
+
+// evictOldestLocked removes the least recently updated conversation; the caller must hold the write lock
+func (cs *ConversationStore) evictOldestLocked() {
+	var oldestID string
+	var oldest time.Time
+	for id, conv := range cs.conversations {
+		if oldestID == "" || conv.UpdatedAt.Before(oldest) {
+			oldestID = id
+			oldest = conv.UpdatedAt
+		}
+	}
+	if oldestID != "" {
+		delete(cs.conversations, oldestID)
+	}
+}
+
+// clone returns a copy of the conversation so callers cannot mutate stored history
+func (c *Conversation) clone() *Conversation {
+	copied := *c
+	copied.Messages = append([]deepseek.ChatCompletionMessage(nil), c.Messages...)
+	return &copied
+}
+
+// conversationHistoryShare is the percentage of the usable context window a conversation's history
+// may take, leaving the rest for the next question, its files and the answer
+const conversationHistoryShare = 50
+
+// conversationFileReferences replaces the embedded files of a turn in the stored history. The
+// contents would otherwise be replayed with every later turn, so only their labels are kept.
+func conversationFileReferences(files []LoadedFile, query string) string {
+	var sb strings.Builder
+	sb.WriteString("# Reference Files\n\n")
+	sb.WriteString("These files were included with this question. Their contents are not kept in the conversation history:\n\n")
+	for _, file := range files {
+		sb.WriteString(fmt.Sprintf("- `%s`\n", file.Label()))
+	}
+	sb.WriteString("\n# Question\n\n")
+	sb.WriteString(query)
+	return sb.String()
+}
+
+// trimConversationHistory drops the oldest exchanges after the system prompt until the history
+// takes at most maxTokens. The latest exchange is always kept.
+func trimConversationHistory(messages []deepseek.ChatCompletionMessage, maxTokens int) []deepseek.ChatCompletionMessage {
+	tokens := 0
+	for _, message := range messages {
+		tokens += deepseek.EstimateTokenCount(message.Content).EstimatedTokens + messageOverheadTokens
+	}
+
+	start := 1
+	for tokens > maxTokens {
+		// An exchange runs from a user message up to the next one
+		end := start + 1
+		for end < len(messages) && messages[end].Role != deepseek.ChatMessageRoleUser {
+			end++
+		}
+		if end >= len(messages) {
+			break
+		}
+		for _, message := range messages[start:end] {
+			tokens -= deepseek.EstimateTokenCount(message.Content).EstimatedTokens + messageOverheadTokens
+		}
+		start = end
+	}
+	if start == 1 {
+		return messages
+	}
+	return append(messages[:1:1], messages[start:]...)
+}
+
+// recordConversationTurn stores the messages sent in this turn together with the assistant reply,
+// dropping the oldest turns when the history outgrows its share of the model's context window
+func (s *DeepseekServer) recordConversationTurn(id string, previous *Conversation, model string,
+	sent []deepseek.ChatCompletionMessage, resp *deepseek.ChatCompletionResponse) int {
+	now := time.Now()
+	conv := &Conversation{
+		ID:        id,
+		Model:     model,
+		CreatedAt: now,
+	}
+	if previous != nil {
+		conv.CreatedAt = previous.CreatedAt
+	}
+	conv.UpdatedAt = now
+
+	conv.Messages = append(conv.Messages, sent...)
+	reply := deepseek.ChatCompletionMessage{Role: deepseek.ChatMessageRoleAssistant}
+	if len(resp.Choices) > 0 {
+		reply.Content = resp.Choices[0].Message.Content
+		// Kept for inspection only; it is stripped before the history is sent back to the API
+		reply.ReasoningContent = resp.Choices[0].Message.ReasoningContent
+	}
+	conv.Messages = append(conv.Messages, reply)
+
+	maxTokens := s.newContextBudget(model, nil, "").available() * conversationHistoryShare / 100
+	conv.Messages = trimConversationHistory(conv.Messages, maxTokens)
+
+	s.conversations.Save(conv)
+	return len(conv.Messages)
+}
+
+// handleListConversations handles requests to the deepseek_conversations tool
+func (s *DeepseekServer) handleListConversations(ctx context.Context) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+	logger.Info("Listing conversations")
+
+	summaries := s.conversations.List()
+
+	var formattedContent strings.Builder
+	formattedContent.WriteString("# DeepSeek Conversations\n\n")
+	if len(summaries) == 0 {
+		formattedContent.WriteString("*No active conversations*\n")
+	} else {
+		formattedContent.WriteString("| Conversation ID | Model | Messages | Created | Last Updated |\n")
+		formattedContent.WriteString("|-----------------|-------|----------|---------|--------------|\n")
+		for _, summary := range summaries {
+			formattedContent.WriteString(fmt.Sprintf("| `%s` | %s | %d | %s | %s |\n",
+				summary.ID,
+				summary.Model,
+				summary.MessageCount,
+				summary.CreatedAt.Format(time.RFC3339),
+				summary.UpdatedAt.Format(time.RFC3339)))
+		}
+	}
+
+	return &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: formattedContent.String(),
+			},
+		},
+	}, nil
+}
+
+// handleGetConversation handles requests to the deepseek_conversation_get tool
+func (s *DeepseekServer) handleGetConversation(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+
+	id, ok := req.Arguments["conversation_id"].(string)
+	if !ok || id == "" {
+		return createErrorResponse("conversation_id must be a non-empty string"), nil
+	}
+
+	conv := s.conversations.Get(id)
+	if conv == nil {
+		return createErrorResponse(fmt.Sprintf("Conversation not found: %s", id)), nil
+	}
+	logger.Info("Showing conversation %s with %d message(s)", id, len(conv.Messages))
+
+	var formattedContent strings.Builder
+	formattedContent.WriteString(fmt.Sprintf("# Conversation `%s`\n\n", conv.ID))
+	formattedContent.WriteString(fmt.Sprintf("**Model:** %s\n", conv.Model))
+	formattedContent.WriteString(fmt.Sprintf("**Created:** %s\n", conv.CreatedAt.Format(time.RFC3339)))
+	formattedContent.WriteString(fmt.Sprintf("**Last Updated:** %s\n", conv.UpdatedAt.Format(time.RFC3339)))
+	formattedContent.WriteString(fmt.Sprintf("**Messages:** %d\n", len(conv.Messages)))
+
+	for i, msg := range conv.Messages {
+		formattedContent.WriteString(fmt.Sprintf("\n## %d. %s\n\n%s\n", i+1, msg.Role, msg.Content))
+		if msg.ReasoningContent != "" {
+			formattedContent.WriteString(fmt.Sprintf("\n*Reasoning: %d characters (not replayed to the model)*\n", len([]rune(msg.ReasoningContent))))
+		}
+	}
+
+	return &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: formattedContent.String(),
+			},
+		},
+	}, nil
+}
+
+// handleDeleteConversation handles requests to the deepseek_conversation_delete tool
+func (s *DeepseekServer) handleDeleteConversation(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+
+	id, ok := req.Arguments["conversation_id"].(string)
+	if !ok || id == "" {
+		return createErrorResponse("conversation_id must be a non-empty string"), nil
+	}
+
+	if !s.conversations.Delete(id) {
+		return createErrorResponse(fmt.Sprintf("Conversation not found: %s", id)), nil
+	}
+	logger.Info("Deleted conversation %s", id)
+
+	return &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: fmt.Sprintf("Conversation `%s` deleted.", id),
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+func TestConversationStoreLockTurnSerializesTurns(t *testing.T) {
+	store := NewConversationStore(10)
+
+	endFirst, err := store.LockTurn(context.Background(), "c1")
+	if err != nil {
+		t.Fatalf("LockTurn: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		endSecond, err := store.LockTurn(context.Background(), "c1")
+		if err != nil {
+			t.Errorf("second LockTurn: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- endSecond
+	}()
+
+	// Other conversations are not held up
+	endOther, err := store.LockTurn(context.Background(), "c2")
+	if err != nil {
+		t.Fatalf("LockTurn of another conversation: %v", err)
+	}
+	endOther()
+
+	select {
+	case <-acquired:
+		t.Fatal("second turn started before the first ended")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	endFirst()
+	select {
+	case endSecond := <-acquired:
+		if endSecond == nil {
+			t.FailNow()
+		}
+		endSecond()
+	case <-time.After(time.Second):
+		t.Fatal("second turn did not start after the first ended")
+	}
+
+	if len(store.turns) != 0 {
+		t.Errorf("turn locks left behind: %d", len(store.turns))
+	}
+}
+
+func TestConversationStoreLockTurnCancelled(t *testing.T) {
+	store := NewConversationStore(10)
+	endFirst, err := store.LockTurn(context.Background(), "c1")
+	if err != nil {
+		t.Fatalf("LockTurn: %v", err)
+	}
+	defer endFirst()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := store.LockTurn(ctx, "c1"); err == nil {
+		t.Fatal("expected an error when the context ends while waiting")
+	}
+	if refs := store.turns["c1"].refs; refs != 1 {
+		t.Errorf("refs = %d after a cancelled wait, want 1", refs)
+	}
+}
+
+func TestConversationStoreEvictsOldest(t *testing.T) {
+	store := NewConversationStore(2)
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c"} {
+		store.Save(&Conversation{ID: id, UpdatedAt: now.Add(time.Duration(i) * time.Minute)})
+	}
+
+	if store.Get("a") != nil {
+		t.Error("the least recently updated conversation was not evicted")
+	}
+	for _, id := range []string{"b", "c"} {
+		if store.Get(id) == nil {
+			t.Errorf("conversation %s was evicted", id)
+		}
+	}
+}
+
+func TestConversationStoreGetReturnsCopy(t *testing.T) {
+	store := NewConversationStore(10)
+	store.Save(&Conversation{ID: "a", Messages: []deepseek.ChatCompletionMessage{{Role: "user", Content: "hi"}}})
+
+	conv := store.Get("a")
+	conv.Messages[0].Content = "changed"
+	conv.Messages = append(conv.Messages, deepseek.ChatCompletionMessage{Role: "assistant"})
+
+	stored := store.Get("a")
+	if len(stored.Messages) != 1 || stored.Messages[0].Content != "hi" {
+		t.Errorf("stored history was modified through a copy: %+v", stored.Messages)
+	}
+}
+
+func TestTrimConversationHistory(t *testing.T) {
+	message := func(role, content string) deepseek.ChatCompletionMessage {
+		return deepseek.ChatCompletionMessage{Role: role, Content: content}
+	}
+	long := strings.Repeat("word ", 400)
+	messages := []deepseek.ChatCompletionMessage{
+		message(deepseek.ChatMessageRoleSystem, "You are helpful."),
+		message(deepseek.ChatMessageRoleUser, "first "+long),
+		message(deepseek.ChatMessageRoleAssistant, "first answer"),
+		message(deepseek.ChatMessageRoleUser, "second "+long),
+		message(deepseek.ChatMessageRoleAssistant, "second answer"),
+		message(deepseek.ChatMessageRoleUser, "third "+long),
+		message(deepseek.ChatMessageRoleAssistant, "third answer"),
+	}
+	exchange := deepseek.EstimateTokenCount("first "+long).EstimatedTokens + 2*messageOverheadTokens +
+		deepseek.EstimateTokenCount("first answer").EstimatedTokens
+
+	tests := []struct {
+		name      string
+		maxTokens int
+		want      []string // First word of each kept message after the system prompt
+	}{
+		{"fits", 10 * exchange, []string{"first", "first", "second", "second", "third", "third"}},
+		{"drops the oldest exchange", 2*exchange + 50, []string{"second", "second", "third", "third"}},
+		{"keeps the latest exchange", 1, []string{"third", "third"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimConversationHistory(append([]deepseek.ChatCompletionMessage(nil), messages...), tt.maxTokens)
+			if len(got) != len(tt.want)+1 || got[0].Role != deepseek.ChatMessageRoleSystem {
+				t.Fatalf("kept %d messages, want the system prompt and %d more", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if first := strings.Fields(got[i+1].Content)[0]; first != want {
+					t.Errorf("message %d starts with %q, want %q", i+1, first, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordConversationTurn(t *testing.T) {
+	s := &DeepseekServer{config: &Config{ContextWindow: 100000}, conversations: NewConversationStore(10)}
+	files := []LoadedFile{{Path: "/work/pkg/a.go", Name: "pkg/a.go", Content: []byte(strings.Repeat("package a\n", 500))}}
+	sent := []deepseek.ChatCompletionMessage{
+		{Role: deepseek.ChatMessageRoleSystem, Content: "You are helpful."},
+		{Role: deepseek.ChatMessageRoleUser, Content: conversationFileReferences(files, "What does a.go do?")},
+	}
+	response := &deepseek.ChatCompletionResponse{Choices: []deepseek.Choice{{Message: deepseek.Message{Content: "It declares package a."}}}}
+
+	if count := s.recordConversationTurn("c1", nil, "deepseek-chat", sent, response); count != 3 {
+		t.Errorf("recorded %d messages, want 3", count)
+	}
+	conv := s.conversations.Get("c1")
+	if conv == nil || len(conv.Messages) != 3 {
+		t.Fatalf("stored conversation = %+v, want 3 messages", conv)
+	}
+	question := conv.Messages[1].Content
+	if !strings.Contains(question, "- `pkg/a.go`") || !strings.HasSuffix(question, "# Question\n\nWhat does a.go do?") {
+		t.Errorf("stored question does not reference the file and the question:\n%s", question)
+	}
+	if strings.Contains(question, "package a") {
+		t.Error("the stored history contains the file contents")
+	}
+	if conv.Messages[2].Role != deepseek.ChatMessageRoleAssistant || conv.Messages[2].Content != "It declares package a." {
+		t.Errorf("stored reply = %+v", conv.Messages[2])
+	}
+}
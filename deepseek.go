@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 	
 	"github.com/cohesion-org/deepseek-go"
 	"github.com/gomcpgo/mcp/pkg/protocol"
@@ -18,8 +21,17 @@ import (
 type DeepseekServer struct {
 	config  *Config
 	client  *deepseek.Client
+	betaClient *deepseek.Client // Client for beta endpoints such as FIM completion
 	models  []DeepseekModelInfo   // Dynamically discovered models
 	modelsMu sync.RWMutex         // Mutex for thread-safe model access
+	conversations *ConversationStore // Multi-turn conversation histories
+	cache         *ResponseCache     // Completion cache (nil when caching is disabled)
+	progress      ProgressNotifier   // Sends MCP progress notifications while streaming (nil sends none)
+	workspace     *Workspace         // Directories agent tools may access
+	fileTypes     *FileTypeRegistry  // Detects MIME types and fence languages of files
+	redactor      *Redactor          // Removes secrets from outbound content
+	breaker       *CircuitBreaker    // Fails requests fast while the API is unavailable
+	limiter       *RateLimiter       // Keeps requests within the configured rate limits
 }
 
 
@@ -34,19 +46,78 @@ func NewDeepseekServer(ctx context.Context, config *Config) (*DeepseekServer, er
 		return nil, errors.New("DeepSeek API key is required")
 	}
 
-	// Initialize the DeepSeek client
-	client := deepseek.NewClient(config.DeepseekAPIKey)
-	
-	// No error is returned by NewClient in the current library version
+	// Initialize the DeepSeek client, optionally against a custom endpoint
+	var client *deepseek.Client
+	if config.DeepseekBaseURL != "" {
+		client = deepseek.NewClient(config.DeepseekAPIKey, config.DeepseekBaseURL)
+	} else {
+		client = deepseek.NewClient(config.DeepseekAPIKey)
+	}
+
+	// FIM and prefix completion are served from the beta endpoint
+	betaClient := deepseek.NewClient(config.DeepseekAPIKey, betaBaseURL(config.DeepseekBaseURL))
+
+	// NewClient returns nil instead of an error for an invalid base URL
+	if client == nil || betaClient == nil {
+		return nil, fmt.Errorf("failed to create DeepSeek client: invalid base URL %q", config.DeepseekBaseURL)
+	}
+
+	// Record response statuses and Retry-After headers, which the library does not expose, for error classification.
+	// The client has no timeout of its own: each attempt is bounded by DEEPSEEK_TIMEOUT through its context,
+	// so a streamed answer is limited by the same deadline as any other request.
+	client.HTTPClient = &responseRecorder{next: &http.Client{}}
+	betaClient.HTTPClient = client.HTTPClient
+
+	workspace, err := NewWorkspace(config.WorkspaceRoots, config.DefaultWorkspaceRoot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace configuration: %w", err)
+	}
+
+	// Placeholders are only written when secrets are redacted, so the key is only loaded then. A key
+	// that cannot be stored outside a configured cache directory falls back to one for this run.
+	redactionKey, err := newRedactionKey()
+	if err != nil {
+		return nil, err
+	}
+	if config.RedactionMode == redactionRedact {
+		key, err := loadRedactionKey(config.RedactionKey, config.RedactionKeyFile)
+		switch {
+		case err == nil:
+			redactionKey = key
+		case config.CacheDir != "":
+			return nil, err
+		default:
+			getLoggerFromContext(ctx).Warn("%v; secret placeholders will change when the server restarts (set DEEPSEEK_REDACTION_KEY to keep them)", err)
+		}
+	}
+	redactor, err := NewRedactor(config.RedactionMode, redactionKey, config.RedactionAllow, config.RedactionDeny)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create a simplified DeepseekServer without cache storage
 	server := &DeepseekServer{
-		config: config,
-		client: client,
+		config:        config,
+		client:        client,
+		betaClient:    betaClient,
+		conversations: NewConversationStore(config.MaxConversations),
+		workspace:     workspace,
+		fileTypes:     NewFileTypeRegistry(config.FileTypeOverrides),
+		redactor:      redactor,
+		breaker:       NewCircuitBreaker(config),
+		limiter:       NewRateLimiter(config),
+	}
+
+	// Set up the completion cache if enabled
+	if config.EnableCaching {
+		cache, err := NewResponseCache(config.CacheMaxEntries, config.CacheMaxSize, config.CacheDir, getLoggerFromContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create response cache: %w", err)
+		}
+		server.cache = cache
 	}
 	
 	// Discover available models at startup
-	err := server.discoverModels(ctx)
+	err = server.discoverModels(ctx)
 	if err != nil {
 		// Log warning but continue - we'll use fallback models if needed
 		logger := getLoggerFromContext(ctx)
@@ -133,11 +204,67 @@ func (s *DeepseekServer) ListTools(ctx context.Context) (*protocol.ListToolsResp
 						"items": {
 							"type": "string"
 						},
-						"description": "Optional: Paths to files to include in the request context"
+						"description": "Optional: Files to include in the request context. Entries may be files, directories, recursive patterns like './internal/...' or globs like 'pkg/**/*.go'; directories and patterns honour .gitignore and .deepseekignore. Select part of a file with a line range ('deepseek.go:240-330') or a symbol ('deepseek.go#handleAskDeepseek', 'server.go#Server.Start')."
+					},
+					"files": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"properties": {
+								"name": {
+									"type": "string",
+									"description": "File name used as the header, e.g. 'src/server.go'; its extension determines the file type"
+								},
+								"content": {
+									"type": "string",
+									"description": "Full text of the file"
+								},
+								"language": {
+									"type": "string",
+									"description": "Optional: Language for the code fence, e.g. 'go' (detected from the name by default)"
+								}
+							},
+							"required": ["name", "content"]
+						},
+						"description": "Optional: File contents sent inline, for clients that do not share a filesystem with the server. They pass the same type, size, budget and redaction checks as file_paths."
+					},
+					"truncation": {
+						"type": "string",
+						"enum": ["auto", "drop_files", "head_tail", "outline", "none"],
+						"description": "Optional: How to shrink the files when the request exceeds the model's context window. 'auto' (default) outlines expanded files, then keeps the head and tail of large files, then drops the least important files; 'none' fails instead."
+					},
+					"line_numbers": {
+						"type": "boolean",
+						"description": "Optional: Prefix every line of the files with its line number and ask the model to cite code as path:line. Citations found in the answer are listed and checked against the files either way."
 					},
 					"json_mode": {
 						"type": "boolean",
 						"description": "Optional: Enable JSON mode to receive structured JSON responses. Set to true when you expect JSON output."
+					},
+					"conversation_id": {
+						"type": "string",
+						"description": "Optional: Conversation identifier. Requests with the same ID share the message history of previous questions and answers. Included files are kept as references only, so pass them again to ask about their contents. An unknown ID starts a new conversation."
+					},
+					"use_cache": {
+						"type": "boolean",
+						"description": "Optional: Reuse a cached answer for an identical request over unchanged files (defaults to the server configuration)"
+					},
+					"include_reasoning": {
+						"type": "string",
+						"enum": ["none", "summary", "full"],
+						"description": "Optional: For reasoning models such as deepseek-reasoner, return the model's chain of thought as a separate block ('full') or a shortened version of it ('summary'). Defaults to 'none'."
+					},
+					"assistant_prefix": {
+						"type": "string",
+						"description": "Optional: Text the answer must start with, e.g. an opening code fence or '{'. DeepSeek continues from this prefix and the returned answer includes it."
+					},
+					"agent": {
+						"type": "boolean",
+						"description": "Optional: Let DeepSeek investigate the workspace itself by calling read_file, list_directory, grep and file_outline tools until it can answer. The response includes a trace of the tool calls."
+					},
+					"cache_ttl": {
+						"type": "string",
+						"description": "Optional: How long to keep this answer in the cache, as a Go duration (e.g. '10m', '2h')"
 					}
 				},
 				"required": ["query"]
@@ -161,6 +288,15 @@ func (s *DeepseekServer) ListTools(ctx context.Context) (*protocol.ListToolsResp
 				"required": []
 			}`),
 		},
+		{
+			Name:        "deepseek_health",
+			Description: "Show the state and counters of the circuit breaker and rate limiter around the DeepSeek API, and the retry settings",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {},
+				"required": []
+			}`),
+		},
 		{
 			Name:        "deepseek_token_estimate",
 			Description: "Estimate the number of tokens in text or a file",
@@ -179,6 +315,160 @@ func (s *DeepseekServer) ListTools(ctx context.Context) (*protocol.ListToolsResp
 				"required": []
 			}`),
 		},
+		{
+			Name:        "deepseek_complete",
+			Description: "Complete code at a cursor position using DeepSeek fill-in-the-middle (FIM) completion. Returns the inserted code and a unified diff patch.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"file_path": {
+						"type": "string",
+						"description": "Path to the file to complete. Requires line and column; cannot be combined with prefix."
+					},
+					"line": {
+						"type": "integer",
+						"description": "1-based line of the cursor in file_path"
+					},
+					"column": {
+						"type": "integer",
+						"description": "1-based column (in characters) of the cursor in file_path"
+					},
+					"prefix": {
+						"type": "string",
+						"description": "Explicit code before the cursor (instead of file_path, line and column)"
+					},
+					"suffix": {
+						"type": "string",
+						"description": "Optional explicit code after the cursor"
+					},
+					"model": {
+						"type": "string",
+						"description": "Optional: Model to use (defaults to deepseek-chat)"
+					},
+					"max_tokens": {
+						"type": "integer",
+						"description": "Optional: Maximum number of tokens to generate (default 512, max 4096)"
+					}
+				},
+				"required": []
+			}`),
+		},
+		{
+			Name:        "deepseek_review_diff",
+			Description: "Review a git change with DeepSeek. Computes the diff locally, adds surrounding context and the full content of small touched files, and returns findings that cite new-file line numbers.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"repo_path": {
+						"type": "string",
+						"description": "Optional: Path to the git repository (defaults to the first workspace root)"
+					},
+					"base": {
+						"type": "string",
+						"description": "Optional: Base commit, branch or tag (defaults to HEAD for working tree and staged reviews; required otherwise)"
+					},
+					"head": {
+						"type": "string",
+						"description": "Optional: Commit, branch or tag to review against base, or 'working_tree' (default) or 'staged' for uncommitted changes"
+					},
+					"context_lines": {
+						"type": "integer",
+						"description": "Optional: Lines of unchanged context around each change (default 10, max 200)"
+					},
+					"full_file_max_lines": {
+						"type": "integer",
+						"description": "Optional: Include the full content of touched files up to this many lines (default 400, 0 to disable)"
+					},
+					"focus": {
+						"type": "string",
+						"description": "Optional: Aspects the review should concentrate on, e.g. 'concurrency and error handling'"
+					},
+					"model": {
+						"type": "string",
+						"description": "Optional: Specific DeepSeek model to use (overrides default configuration)"
+					},
+					"systemPrompt": {
+						"type": "string",
+						"description": "Optional: Custom system prompt to use for this review (overrides default configuration)"
+					}
+				},
+				"required": []
+			}`),
+		},
+		{
+			Name:        "deepseek_analyze_large",
+			Description: "Answer a question about more files than fit in one request. Splits the files into token-bounded chunks along file and declaration boundaries, analyses the chunks concurrently and merges the partial answers. Reports token usage and cost per chunk.",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"query": {
+						"type": "string",
+						"description": "The question to answer about the files"
+					},
+					"file_paths": {
+						"type": "array",
+						"items": {
+							"type": "string"
+						},
+						"description": "Paths, directories or glob patterns of the files to analyse; entries may select lines or a symbol like deepseek_ask"
+					},
+					"chunk_tokens": {
+						"type": "integer",
+						"description": "Optional: Maximum tokens of file content per chunk (default 32000, capped by the model context window)"
+					},
+					"concurrency": {
+						"type": "integer",
+						"description": "Optional: Number of chunks analysed at the same time (default 4, max 8)"
+					},
+					"model": {
+						"type": "string",
+						"description": "Optional: Specific DeepSeek model to use (overrides default configuration)"
+					},
+					"systemPrompt": {
+						"type": "string",
+						"description": "Optional: Custom system prompt to use for every request (overrides default configuration)"
+					}
+				},
+				"required": ["query", "file_paths"]
+			}`),
+		},
+		{
+			Name:        "deepseek_conversations",
+			Description: "List active deepseek_ask conversations",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {},
+				"required": []
+			}`),
+		},
+		{
+			Name:        "deepseek_conversation_get",
+			Description: "Show the message history of a deepseek_ask conversation",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"conversation_id": {
+						"type": "string",
+						"description": "The conversation to inspect"
+					}
+				},
+				"required": ["conversation_id"]
+			}`),
+		},
+		{
+			Name:        "deepseek_conversation_delete",
+			Description: "Delete a deepseek_ask conversation and its history",
+			InputSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"conversation_id": {
+						"type": "string",
+						"description": "The conversation to delete"
+					}
+				},
+				"required": ["conversation_id"]
+			}`),
+		},
 	}
 
 	return &protocol.ListToolsResponse{
@@ -213,6 +503,9 @@ func createErrorResponse(message string) *protocol.CallToolResponse {
 
 // CallTool implements the ToolHandler interface for DeepseekServer
 func (s *DeepseekServer) CallTool(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	// Identify the call so that the rate limiter can share capacity fairly between concurrent calls
+	ctx = withCallID(ctx)
+
 	switch req.Name {
 	case "deepseek_ask":
 		return s.handleAskDeepseek(ctx, req)
@@ -220,8 +513,22 @@ func (s *DeepseekServer) CallTool(ctx context.Context, req *protocol.CallToolReq
 		return s.handleDeepseekModels(ctx)
 	case "deepseek_balance":
 		return s.handleDeepseekBalance(ctx)
+	case "deepseek_health":
+		return s.handleHealth(ctx)
 	case "deepseek_token_estimate":
 		return s.handleTokenEstimate(ctx, req)
+	case "deepseek_complete":
+		return s.handleComplete(ctx, req)
+	case "deepseek_review_diff":
+		return s.handleReviewDiff(ctx, req)
+	case "deepseek_analyze_large":
+		return s.handleAnalyzeLarge(ctx, req)
+	case "deepseek_conversations":
+		return s.handleListConversations(ctx)
+	case "deepseek_conversation_get":
+		return s.handleGetConversation(ctx, req)
+	case "deepseek_conversation_delete":
+		return s.handleDeleteConversation(ctx, req)
 	default:
 		return createErrorResponse(fmt.Sprintf("unknown tool: %s", req.Name)), nil
 	}
@@ -237,8 +544,30 @@ func (s *DeepseekServer) handleAskDeepseek(ctx context.Context, req *protocol.Ca
 		return createErrorResponse("query must be a string"), nil
 	}
 
+	// Extract optional conversation ID for multi-turn sessions
+	conversationID, _ := req.Arguments["conversation_id"].(string)
+	var conversation *Conversation
+	if conversationID != "" {
+		// Turns of one conversation run one at a time, so each sees the history of the one before
+		endTurn, err := s.conversations.LockTurn(ctx, conversationID)
+		if err != nil {
+			return createErrorResponse(err.Error()), nil
+		}
+		defer endTurn()
+
+		conversation = s.conversations.Get(conversationID)
+		if conversation != nil {
+			logger.Info("Continuing conversation %s with %d previous message(s)", conversationID, len(conversation.Messages))
+		} else {
+			logger.Info("Starting new conversation %s", conversationID)
+		}
+	}
+
 	// Extract optional model parameter
 	modelName := s.config.DeepseekModel
+	if conversation != nil && conversation.Model != "" {
+		modelName = conversation.Model
+	}
 	if customModel, ok := req.Arguments["model"].(string); ok && customModel != "" {
 		// Validate the custom model
 		if err := s.ValidateModelID(customModel); err != nil {
@@ -251,9 +580,14 @@ func (s *DeepseekServer) handleAskDeepseek(ctx context.Context, req *protocol.Ca
 
 	// Extract optional systemPrompt parameter
 	systemPrompt := s.config.DeepseekSystemPrompt
+	customSystemPrompt := false
+	if conversation != nil && len(conversation.Messages) > 0 {
+		systemPrompt = conversation.Messages[0].Content
+	}
 	if customPrompt, ok := req.Arguments["systemPrompt"].(string); ok && customPrompt != "" {
 		logger.Info("Using request-specific system prompt")
 		systemPrompt = customPrompt
+		customSystemPrompt = true
 	}
 
 	// Extract file paths if provided
@@ -266,6 +600,22 @@ func (s *DeepseekServer) handleAskDeepseek(ctx context.Context, req *protocol.Ca
 		}
 	}
 
+	// Extract file contents supplied inline by clients that do not share the server's filesystem
+	inlineFiles, err := parseInlineFiles(req.Arguments["files"])
+	if err != nil {
+		return createErrorResponse(err.Error()), nil
+	}
+
+	// Expand directories and glob patterns, then validate every file against the configured limits
+	var fileReport *FileReport
+	var files []LoadedFile
+	if len(filePaths) > 0 || len(inlineFiles) > 0 {
+		files, fileReport = s.loadRequestFiles(ctx, filePaths, inlineFiles, s.config.MaxRequestTokens)
+		if refusal := secretRefusal(fileReport); refusal != nil {
+			return refusal, nil
+		}
+	}
+
 	// Extract optional JSON mode parameter
 	jsonMode := false
 	if jsonModeRaw, ok := req.Arguments["json_mode"].(bool); ok {
@@ -273,87 +623,237 @@ func (s *DeepseekServer) handleAskDeepseek(ctx context.Context, req *protocol.Ca
 		logger.Info("JSON mode is enabled: %v", jsonMode)
 	}
 
+	// Extract optional reasoning output mode (only reasoning models return reasoning)
+	reasoningMode, err := parseReasoningMode(req.Arguments["include_reasoning"])
+	if err != nil {
+		return createErrorResponse(err.Error()), nil
+	}
 
-	// Create ChatCompletionMessage from user query and system prompt
-	chatMessages := []deepseek.ChatCompletionMessage{
-		{
-			Role:    deepseek.ChatMessageRoleSystem,
-			Content: systemPrompt,
-		},
-		{
-			Role:    deepseek.ChatMessageRoleUser,
-			Content: query,
-		},
+	// Extract optional truncation strategy used when the files do not fit the context window
+	truncation, err := parseTruncationStrategy(req.Arguments["truncation"])
+	if err != nil {
+		return createErrorResponse(err.Error()), nil
+	}
+
+	// Extract optional line numbering of the embedded files
+	lineNumbers, _ := req.Arguments["line_numbers"].(bool)
+
+	// Extract optional caching parameters
+	useCache := s.cache != nil
+	if useCacheRaw, ok := req.Arguments["use_cache"].(bool); ok {
+		useCache = useCacheRaw && s.cache != nil
 	}
+	cacheTTL := s.config.DefaultCacheTTL
+	if cacheTTLRaw, ok := req.Arguments["cache_ttl"].(string); ok && cacheTTLRaw != "" {
+		parsedTTL, err := time.ParseDuration(cacheTTLRaw)
+		if err != nil || parsedTTL <= 0 {
+			return createErrorResponse(fmt.Sprintf("Invalid cache_ttl: %s. Use a positive duration such as '10m' or '2h'", cacheTTLRaw)), nil
+		}
+		cacheTTL = parsedTTL
+	}
+	// Extract optional assistant prefix the answer must start with
+	assistantPrefix, _ := req.Arguments["assistant_prefix"].(string)
 
-	// Create the request
-	request := &deepseek.ChatCompletionRequest{
-		Model:       modelName,
-		Messages:    chatMessages,
-		Temperature: s.config.DeepseekTemperature,
-		JSONMode:    jsonMode,
+	// Extract optional agent mode parameter
+	agentMode, _ := req.Arguments["agent"].(bool)
+	if agentMode && assistantPrefix != "" {
+		return createErrorResponse("assistant_prefix cannot be combined with agent mode"), nil
+	}
+	if agentMode && !GetModelCapabilities(modelName).Tools {
+		return createErrorResponse(fmt.Sprintf("Agent mode requires function calling, which %s does not support", modelName)), nil
 	}
 
-	// Log the temperature setting
-	logger.Debug("Using temperature: %v for model %s", s.config.DeepseekTemperature, modelName)
+	// Answers that depend on earlier turns or on files the agent reads cannot be shared between requests
+	if (conversation != nil && len(conversation.Messages) > 0) || agentMode {
+		useCache = false
+	}
+	originalQuery := query
+	var fileHashes []string
+
+	// Fit the files into the model's context window, trimming the least important ones if needed
+	var contextBudget *ContextBudget
+	if len(files) > 0 {
+		fixedMessages := []deepseek.ChatCompletionMessage{{Role: deepseek.ChatMessageRoleSystem, Content: systemPrompt}}
+		if conversation != nil && len(conversation.Messages) > 1 {
+			fixedMessages = append(fixedMessages, conversation.Messages[1:]...)
+		}
+		fixedMessages = append(fixedMessages,
+			deepseek.ChatCompletionMessage{Role: deepseek.ChatMessageRoleUser, Content: originalQuery},
+			deepseek.ChatCompletionMessage{Role: deepseek.ChatMessageRoleAssistant, Content: assistantPrefix})
+
+		contextBudget = s.newContextBudget(modelName, fixedMessages, truncation)
+		contextBudget.LineNumbers = lineNumbers
+		files, err = fitContextWindow(files, contextBudget)
+		if err != nil {
+			logger.Error("Request does not fit the context window: %v", err)
+			return createErrorResponse(fmt.Sprintf("Request too large: %v\n\n%s\n\n%s", err, contextBudget.Format(), fileReport.Format())), nil
+		}
+		if len(contextBudget.Actions) > 0 {
+			logger.Warn("Trimmed %d file(s) with the %s strategy to fit the %d token context window of %s",
+				len(contextBudget.Actions), truncation, contextBudget.ContextWindow, modelName)
+		}
+	}
 
 	// Add file contents if provided
-	if len(filePaths) > 0 {
+	if len(files) > 0 {
 		// First, gather file contents to be included in the prompt
-		fileContents := "\n\n# Reference Files\n"
-		successfulFiles := 0
-		fileSizes := []int64{}
-		
-		for _, filePath := range filePaths {
-			// Read file content using our readFile function
-			content, err := readFile(filePath)
-			if err != nil {
-				logger.Error("Failed to read file %s: %v", filePath, err)
-				continue
-			}
-			
-			// Record successful file read and size
-			successfulFiles++
-			fileSizes = append(fileSizes, int64(len(content)))
-			
-			// Get language extension for markdown highlighting
-			language := getLanguageFromPath(filePath)
-			
-			// Add file content to the combined contents with file name as header and proper markdown formatting
-			fileContents += fmt.Sprintf("\n\n## %s\n\n```%s\n%s\n```", 
-				filepath.Base(filePath), language, string(content))
+		fileContents := "# Reference Files\n"
+		if lineNumbers {
+			fileContents += "\nEvery line starts with its line number. Cite code as `path:line` or `path:start-end`, using the path in the file's header.\n"
 		}
-		
-		// Log some statistics about the files
-		logger.Info("Including %d file(s) in the query, total size: %s", 
-			successfulFiles, humanReadableSize(sumSizes(fileSizes)))
-		
-		// Create a chat request with file contents embedded in the query
-		if successfulFiles > 0 {
-			query = query + fileContents
+		for _, file := range files {
+			fileHashes = append(fileHashes, fmt.Sprintf("%s:%d-%d:%s", file.Path, file.StartLine, file.EndLine, hashContent(file.Content)))
+
+			// Add file content to the combined contents with its path (and excerpt lines) as header and proper markdown formatting
+			fileContents += "\n\n" + file.Embed(lineNumbers)
+		}
+
+		// Put the stable file contents ahead of the query so they form part of the cacheable prefix
+		query = fileContents + "\n\n# Question\n\n" + query
+	} else if fileReport != nil {
+		logger.Warn("No files were successfully read to include in the query")
+	}
+
+	// Create ChatCompletionMessages from the conversation history (if any), system prompt and user query
+	var chatMessages []deepseek.ChatCompletionMessage
+	if conversation != nil && len(conversation.Messages) > 0 {
+		chatMessages = append(chatMessages, conversation.Messages...)
+		if customSystemPrompt {
+			chatMessages[0].Content = systemPrompt
+		}
+	} else {
+		chatMessages = append(chatMessages, deepseek.ChatCompletionMessage{
+			Role:    deepseek.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		})
+	}
+	chatMessages = append(chatMessages, deepseek.ChatCompletionMessage{
+		Role:    deepseek.ChatMessageRoleUser,
+		Content: query,
+	})
+
+	// Create the request with only the parameters the model supports
+	requestMessages := chatMessages
+	if assistantPrefix != "" {
+		logger.Info("Forcing the answer to start with a %d-byte assistant prefix", len(assistantPrefix))
+		requestMessages = withAssistantPrefix(chatMessages, assistantPrefix)
+	}
+	request, requestNotes := buildChatRequest(modelName, requestMessages, s.config.DeepseekTemperature, jsonMode)
+	for _, note := range requestNotes {
+		logger.Warn("%s", note)
+	}
+
+	// Log the temperature setting
+	logger.Debug("Using temperature: %v for model %s", request.Temperature, modelName)
+
+	// Serve identical requests over unchanged files from the cache
+	var cacheKey string
+	var cachedEntry *CacheEntry
+	if useCache {
+		cacheKey = CacheKey(CacheKeyParams{
+			Model:        modelName,
+			SystemPrompt: systemPrompt,
+			Query:        originalQuery,
+			Temperature:  s.config.DeepseekTemperature,
+			JSONMode:     jsonMode,
+			LineNumbers:  lineNumbers,
+			Prefix:       assistantPrefix,
+			FileHashes:   fileHashes,
+		})
+		cachedEntry = s.cache.Get(cacheKey)
+	}
+
+	var response *deepseek.ChatCompletionResponse
+	var agentTrace []AgentTraceEntry
+	var stats RequestStats
+	if cachedEntry != nil {
+		logger.Info("Serving response from cache (key %s)", cacheKey[:12])
+		response = cachedEntry.Response
+	} else {
+		// Send the request to the DeepSeek API
+		var err error
+		if agentMode {
+			response, agentTrace, stats, err = s.runAgent(ctx, request, progressTokenFromContext(ctx))
 		} else {
-			logger.Warn("No files were successfully read to include in the query")
+			response, stats, err = s.executeDeepseekRequest(ctx, request, progressTokenFromContext(ctx))
+		}
+		if err != nil {
+			logger.Error("DeepSeek API error: %v", err)
+			errorMsg := fmt.Sprintf("Error from DeepSeek API: %v", err)
+
+			// Include additional information in the error response
+			if fileReport != nil {
+				errorMsg += "\n\n" + fileReport.Format()
+			}
+
+			return createErrorResponse(errorMsg), nil
+		}
+
+		if assistantPrefix != "" {
+			prependAssistantPrefix(response, assistantPrefix)
+		}
+
+		if useCache {
+			s.cache.Put(cacheKey, modelName, response, cacheTTL)
 		}
 	}
-	
-	// Update the request with the full query (either original or with file contents)
-	request.Messages[1].Content = query
-	
-	// Send the request to the DeepSeek API
-	response, err := s.client.CreateChatCompletion(ctx, request)
-	if err != nil {
-		logger.Error("DeepSeek API error: %v", err)
-		errorMsg := fmt.Sprintf("Error from DeepSeek API: %v", err)
-		
-		// Include additional information in the error response
-		if len(filePaths) > 0 {
-			errorMsg += fmt.Sprintf("\n\nThe request included %d file(s).", len(filePaths))
+
+	result := s.formatResponse(response, reasoningMode, stats)
+	if len(files) > 0 && len(response.Choices) > 0 {
+		if citations := extractCitations(response.Choices[0].Message.Content, files); len(citations) > 0 {
+			result.Content = append(result.Content, protocol.ToolContent{
+				Type: "text",
+				Text: formatCitations(citations),
+			})
 		}
-		
-		return createErrorResponse(errorMsg), nil
 	}
-	
-	return s.formatResponse(response), nil
+	if fileReport != nil {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: fileReport.Format(),
+		})
+	}
+	if contextBudget != nil && len(contextBudget.Actions) > 0 {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: contextBudget.Format(),
+		})
+	}
+	if agentMode {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: formatAgentTrace(agentTrace),
+		})
+	}
+	for _, note := range requestNotes {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: "*Note: " + note + "*",
+		})
+	}
+	if cachedEntry != nil {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: fmt.Sprintf("*Served from cache (cached %s, expires %s).*",
+				cachedEntry.CreatedAt.Format(time.RFC3339), cachedEntry.ExpiresAt.Format(time.RFC3339)),
+		})
+	}
+
+	// Record the exchange so follow-up questions see the full history
+	if conversationID != "" {
+		history := append([]deepseek.ChatCompletionMessage(nil), chatMessages...)
+		if len(files) > 0 {
+			history[len(history)-1].Content = conversationFileReferences(files, originalQuery)
+		}
+		messageCount := s.recordConversationTurn(conversationID, conversation, modelName, history, response)
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: fmt.Sprintf("**Conversation ID:** `%s` (%d messages). Pass it as `conversation_id` to continue this conversation.",
+				conversationID, messageCount),
+		})
+	}
+
+	return result, nil
 }
 
 
@@ -375,8 +875,8 @@ func (s *DeepseekServer) handleTokenEstimate(ctx context.Context, req *protocol.
 
 	// Handle input from file path
 	if hasFilePath && filePath != "" {
-		// Read file content
-		fileContent, err := readFile(filePath)
+		// Validate and read file content
+		fileContent, err := s.validateAndReadFile(filePath)
 		if err != nil {
 			logger.Error("Failed to read file: %v", err)
 			return createErrorResponse(fmt.Sprintf("Error reading file: %v", err)), nil
@@ -452,8 +952,10 @@ func (s *DeepseekServer) handleDeepseekBalance(ctx context.Context) (*protocol.C
 	logger.Info("Checking DeepSeek API balance")
 
 	// Get balance information from the API
-	balanceResponse, err := deepseek.GetBalance(s.client, ctx)
+	balanceCtx, meta := withResponseMeta(ctx)
+	balanceResponse, err := deepseek.GetBalance(s.client, balanceCtx)
 	if err != nil {
+		err = classifyAPIError(ctx, err, meta)
 		logger.Error("Failed to get balance from DeepSeek API: %v", err)
 		return createErrorResponse(fmt.Sprintf("Error checking balance: %v", err)), nil
 	}
@@ -588,35 +1090,74 @@ func (s *DeepseekServer) handleDeepseekModels(ctx context.Context) (*protocol.Ca
 	}, nil
 }
 
-// executeDeepseekRequest makes the request to the DeepSeek API with retry capability
-func (s *DeepseekServer) executeDeepseekRequest(ctx context.Context, model string, query string) (*deepseek.ChatCompletionResponse, error) {
+// RequestStats describes how a response was obtained from the API
+type RequestStats struct {
+	Attempts  int           // API requests made, including retries
+	QueueWait time.Duration // Time spent waiting for the rate limits
+}
+
+// add adds the stats of another request, such as one step of an agent run
+func (r *RequestStats) add(other RequestStats) {
+	r.Attempts += other.Attempts
+	r.QueueWait += other.QueueWait
+}
+
+// executeDeepseekRequest is the single path by which tools send chat completion requests. It runs
+// the request through executeWithRetry and returns the number of attempts made and the time spent queued.
+func (s *DeepseekServer) executeDeepseekRequest(ctx context.Context, request *deepseek.ChatCompletionRequest, progressToken interface{}) (*deepseek.ChatCompletionResponse, RequestStats, error) {
+	return executeWithRetry(ctx, s, estimateRequestTokens(request),
+		func(ctx context.Context) (*deepseek.ChatCompletionResponse, error) {
+			return s.createChatCompletion(ctx, request, progressToken)
+		},
+		func(response *deepseek.ChatCompletionResponse) int { return response.Usage.TotalTokens })
+}
+
+// executeWithRetry sends a request to the DeepSeek API, whatever its kind. Each attempt waits for
+// the rate limits and is bounded by DEEPSEEK_TIMEOUT, and timeouts and network errors are retried
+// with exponential backoff up to DEEPSEEK_MAX_RETRIES times, unless the circuit breaker is open.
+// call makes one attempt, tokens estimates its prompt for the rate limiter and usage returns the
+// tokens a response actually used. It returns the number of attempts made and the time spent queued.
+func executeWithRetry[T any](ctx context.Context, s *DeepseekServer, tokens int, call func(ctx context.Context) (T, error), usage func(T) int) (T, RequestStats, error) {
 	logger := getLoggerFromContext(ctx)
 
-	var response *deepseek.ChatCompletionResponse
+	var response T
+	var stats RequestStats
 
 	// Define the operation to retry
 	operation := func() error {
-		var err error
+		// Wait for the rate limits first, so that the breaker judges the API as it is when the
+		// request is sent rather than before a wait in the queue; retries count against the limits too
+		grant, wait, err := s.limiter.Acquire(ctx, tokens)
+		stats.QueueWait += wait
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			logger.Info("Waited %v for the rate limits (about %d tokens)", wait.Round(time.Millisecond), tokens)
+		}
+
+		// Fail fast rather than wait out the timeout while the API is known to be unavailable
+		probe, err := s.breaker.Allow()
+		if err != nil {
+			s.limiter.Release(grant)
+			return err
+		}
+		stats.Attempts++
+
 		// Set timeout context for the API call
 		timeoutCtx, cancel := context.WithTimeout(ctx, s.config.HTTPTimeout)
 		defer cancel()
+		timeoutCtx, meta := withResponseMeta(timeoutCtx)
 
-		request := &deepseek.ChatCompletionRequest{
-			Model: model,
-			Messages: []deepseek.ChatCompletionMessage{
-				{
-					Role:    deepseek.ChatMessageRoleUser,
-					Content: query,
-				},
-			},
-			Temperature: s.config.DeepseekTemperature,
+		response, err = call(timeoutCtx)
+		if err == nil {
+			s.limiter.Settle(grant, usage(response))
 		}
-		response, err = s.client.CreateChatCompletion(timeoutCtx, request)
+		err = s.breaker.Record(ctx, probe, classifyAPIError(ctx, err, meta))
 		if err != nil {
-			logger.Error("DeepSeek API error: %v", err)
+			logger.Error("DeepSeek API error (attempt %d): %v", stats.Attempts, err)
 			return err
 		}
-
 		return nil
 	}
 
@@ -632,18 +1173,24 @@ func (s *DeepseekServer) executeDeepseekRequest(ctx context.Context, model strin
 	)
 
 	if err != nil {
-		return nil, err
+		var zero T
+		return zero, stats, err
+	}
+	if stats.Attempts > 1 {
+		logger.Info("DeepSeek request succeeded after %d attempts", stats.Attempts)
 	}
 
-	return response, nil
+	return response, stats, nil
 }
 
-// formatResponse formats the DeepSeek API response
-func (s *DeepseekServer) formatResponse(resp *deepseek.ChatCompletionResponse) *protocol.CallToolResponse {
-	// Extract text from the response
-	var content string
+// formatResponse formats the DeepSeek API response. Stats are zero when the response did not
+// come from the API.
+func (s *DeepseekServer) formatResponse(resp *deepseek.ChatCompletionResponse, reasoningMode string, stats RequestStats) *protocol.CallToolResponse {
+	// Extract text and reasoning from the response
+	var content, reasoning string
 	if len(resp.Choices) > 0 {
 		content = resp.Choices[0].Message.Content
+		reasoning = resp.Choices[0].Message.ReasoningContent
 	}
 
 	// Check for empty content and provide a fallback message
@@ -651,7 +1198,7 @@ func (s *DeepseekServer) formatResponse(resp *deepseek.ChatCompletionResponse) *
 		content = "The DeepSeek model returned an empty response. This might indicate that the model couldn't generate an appropriate response for your query. Please try rephrasing your question or providing more context."
 	}
 
-	return &protocol.CallToolResponse{
+	result := &protocol.CallToolResponse{
 		Content: []protocol.ToolContent{
 			{
 				Type: "text",
@@ -659,6 +1206,58 @@ func (s *DeepseekServer) formatResponse(resp *deepseek.ChatCompletionResponse) *
 			},
 		},
 	}
+
+	// Return the reasoning of reasoning models as its own content block when requested
+	if formattedReasoning := formatReasoning(reasoning, reasoningMode); formattedReasoning != "" {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: formattedReasoning,
+		})
+	}
+
+	result.Content = append(result.Content, protocol.ToolContent{
+		Type: "text",
+		Text: formatUsage(resp.Usage, stats),
+	})
+	return result
+}
+
+// formatUsage summarizes token usage, including DeepSeek context cache hits and misses, and the
+// number of API attempts and time queued for the rate limits when they are known
+func formatUsage(usage deepseek.Usage, stats RequestStats) string {
+	var sb strings.Builder
+	sb.WriteString("**Token Usage:** ")
+	sb.WriteString(fmt.Sprintf("%d prompt (%d cache hit, %d cache miss), %d completion, %d total",
+		usage.PromptTokens, usage.PromptCacheHitTokens, usage.PromptCacheMissTokens,
+		usage.CompletionTokens, usage.TotalTokens))
+
+	// Effective hit rate is the share of prompt tokens billed at the cached price
+	cachedTotal := usage.PromptCacheHitTokens + usage.PromptCacheMissTokens
+	if cachedTotal > 0 {
+		sb.WriteString(fmt.Sprintf(" | **Context Cache Hit Rate:** %.1f%%",
+			100*float64(usage.PromptCacheHitTokens)/float64(cachedTotal)))
+	}
+	if stats.Attempts > 0 {
+		sb.WriteString(fmt.Sprintf(" | **Attempts:** %d", stats.Attempts))
+	}
+	if stats.QueueWait >= time.Millisecond {
+		sb.WriteString(fmt.Sprintf(" | **Queued:** %s", stats.QueueWait.Round(time.Millisecond)))
+	}
+	return sb.String()
+}
+
+// sortedUniquePaths returns the paths sorted and without duplicates
+func sortedUniquePaths(paths []string) []string {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+
+	unique := sorted[:0]
+	for i, path := range sorted {
+		if i == 0 || path != sorted[i-1] {
+			unique = append(unique, path)
+		}
+	}
+	return unique
 }
 
 // Helper function to read a file
@@ -672,153 +1271,6 @@ func readFile(path string) ([]byte, error) {
 	return content, nil
 }
 
-// Helper function to get MIME type from file path
-func getMimeTypeFromPath(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
-
-	switch ext {
-	case ".txt":
-		return "text/plain"
-	case ".html", ".htm":
-		return "text/html"
-	case ".css":
-		return "text/css"
-	case ".js":
-		return "application/javascript"
-	case ".json":
-		return "application/json"
-	case ".xml":
-		return "application/xml"
-	case ".pdf":
-		return "application/pdf"
-	case ".png":
-		return "image/png"
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".gif":
-		return "image/gif"
-	case ".svg":
-		return "image/svg+xml"
-	case ".mp3":
-		return "audio/mpeg"
-	case ".mp4":
-		return "video/mp4"
-	case ".wav":
-		return "audio/wav"
-	case ".doc", ".docx":
-		return "application/msword"
-	case ".xls", ".xlsx":
-		return "application/vnd.ms-excel"
-	case ".ppt", ".pptx":
-		return "application/vnd.ms-powerpoint"
-	case ".zip":
-		return "application/zip"
-	case ".csv":
-		return "text/csv"
-	case ".go":
-		return "text/x-go"
-	case ".py":
-		return "text/x-python"
-	case ".java":
-		return "text/x-java"
-	case ".c", ".cpp", ".h", ".hpp":
-		return "text/x-c"
-	case ".rb":
-		return "text/plain"
-	case ".php":
-		return "text/plain"
-	case ".md":
-		return "text/markdown"
-	default:
-		return "application/octet-stream"
-	}
-}
-
-// getLanguageFromPath returns the language identifier for syntax highlighting based on file extension
-func getLanguageFromPath(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
-
-	switch ext {
-	case ".go":
-		return "go"
-	case ".py":
-		return "python"
-	case ".js":
-		return "javascript"
-	case ".html", ".htm":
-		return "html"
-	case ".css":
-		return "css"
-	case ".java":
-		return "java"
-	case ".json":
-		return "json"
-	case ".xml":
-		return "xml"
-	case ".md":
-		return "markdown"
-	case ".c":
-		return "c"
-	case ".cpp", ".hpp":
-		return "cpp"
-	case ".h":
-		return "c"
-	case ".rb":
-		return "ruby"
-	case ".php":
-		return "php"
-	case ".ts":
-		return "typescript"
-	case ".sh", ".bash":
-		return "bash"
-	case ".sql":
-		return "sql"
-	case ".yaml", ".yml":
-		return "yaml"
-	case ".rs":
-		return "rust"
-	case ".swift":
-		return "swift"
-	case ".kt":
-		return "kotlin"
-	case ".scala":
-		return "scala"
-	case ".groovy":
-		return "groovy"
-	case ".pl":
-		return "perl"
-	case ".r":
-		return "r"
-	case ".m":
-		return "matlab"
-	case ".ps1":
-		return "powershell"
-	case ".cs":
-		return "csharp"
-	case ".fs":
-		return "fsharp"
-	case ".vb":
-		return "vbnet"
-	case ".dart":
-		return "dart"
-	case ".ex", ".exs":
-		return "elixir"
-	case ".erl":
-		return "erlang"
-	case ".hs":
-		return "haskell"
-	case ".lua":
-		return "lua"
-	case ".jl":
-		return "julia"
-	case ".clj":
-		return "clojure"
-	// Default to text for unknown file types
-	default:
-		return "text"
-	}
-}
-
 // sumSizes calculates the sum of an array of sizes
 func sumSizes(sizes []int64) int64 {
 	var total int64 = 0
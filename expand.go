@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ignoreFileNames are the ignore files honoured when expanding directories and globs
+var ignoreFileNames = []string{".gitignore", ".deepseekignore"}
+
+// expandFilePaths expands directories (`dir`, `dir/...`) and glob patterns (`pkg/**/*.go`) into
+// individual files, honouring .gitignore and .deepseekignore, and stops at maxFiles files.
+// Explicitly named files are included even if an ignore file matches them.
+func expandFilePaths(entries []string, maxFiles int) *FileReport {
+	report := &FileReport{}
+	seen := make(map[string]bool)
+	count := 0
+
+	add := func(path string, size int64) {
+		path = filepath.Clean(path)
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+
+		if maxFiles > 0 && count >= maxFiles {
+			report.add(path, fileSkipped, fmt.Sprintf("file limit reached (%d files per request)", maxFiles))
+			return
+		}
+		count++
+		report.include(path, size)
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var matches []expandedFile
+		var err error
+		switch {
+		case strings.HasSuffix(entry, "/...") || entry == "...":
+			matches, err = walkFiles(strings.TrimSuffix(strings.TrimSuffix(entry, "..."), "/"), nil)
+		case strings.ContainsAny(entry, "*?["):
+			matches, err = expandGlob(entry)
+		default:
+			info, statErr := os.Stat(entry)
+			if statErr != nil {
+				report.add(entry, fileUnreadable, "not found or not accessible")
+				continue
+			}
+			if info.IsDir() {
+				matches, err = walkFiles(entry, nil)
+			} else {
+				matches = []expandedFile{{path: entry, size: info.Size()}}
+			}
+		}
+
+		if err != nil {
+			report.add(entry, fileSkipped, err.Error())
+			continue
+		}
+		if len(matches) == 0 {
+			report.add(entry, fileSkipped, "pattern matched no files")
+			continue
+		}
+		for _, match := range matches {
+			add(match.path, match.size)
+		}
+	}
+
+	return report
+}
+
+// expandedFile is a file found while expanding a directory or pattern
+type expandedFile struct {
+	path string
+	size int64
+}
+
+// expandGlob expands a glob pattern supporting `*`, `?`, `[...]` and `**` (any number of directories)
+func expandGlob(pattern string) ([]expandedFile, error) {
+	pattern = filepath.ToSlash(pattern)
+
+	// Walk from the longest leading part of the pattern that contains no wildcards
+	segments := strings.Split(pattern, "/")
+	var baseSegments []string
+	for _, segment := range segments[:len(segments)-1] {
+		if strings.ContainsAny(segment, "*?[") {
+			break
+		}
+		baseSegments = append(baseSegments, segment)
+	}
+	base := strings.Join(baseSegments, "/")
+	if base == "" {
+		base = "."
+		if strings.HasPrefix(pattern, "/") {
+			base = "/"
+		}
+	}
+
+	re, err := globToRegexp(strings.TrimPrefix(pattern, "./"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	return walkFiles(filepath.FromSlash(base), func(path string) bool {
+		return re.MatchString(strings.TrimPrefix(filepath.ToSlash(path), "./"))
+	})
+}
+
+// walkFiles returns the files below root that are not ignored and satisfy the optional filter, sorted by path
+func walkFiles(root string, filter func(path string) bool) ([]expandedFile, error) {
+	if root == "" {
+		root = "."
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("not found or not accessible")
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory")
+	}
+
+	matcher := newIgnoreMatcher()
+	matcher.loadAncestors(root)
+
+	var files []expandedFile
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (d.Name() == ".git" || matcher.ignored(path, true)) {
+				return filepath.SkipDir
+			}
+			matcher.loadDir(path)
+			return nil
+		}
+		if !d.Type().IsRegular() || isIgnoreFile(d.Name()) || matcher.ignored(path, false) {
+			return nil
+		}
+		if filter != nil && !filter(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, expandedFile{path: path, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// isIgnoreFile reports whether name is one of the ignore files, which are never included themselves
+func isIgnoreFile(name string) bool {
+	for _, ignoreFileName := range ignoreFileNames {
+		if name == ignoreFileName {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRule is a single compiled line of a .gitignore-style file
+type ignoreRule struct {
+	base    string // directory containing the ignore file
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher evaluates .gitignore and .deepseekignore rules collected from several directories
+type ignoreMatcher struct {
+	rules  []ignoreRule
+	loaded map[string]bool
+}
+
+// newIgnoreMatcher creates an empty ignore matcher
+func newIgnoreMatcher() *ignoreMatcher {
+	return &ignoreMatcher{loaded: make(map[string]bool)}
+}
+
+// loadAncestors loads the ignore files of root's parent directories up to the enclosing
+// git repository, so expanding a subdirectory honours the repository's top-level rules
+func (m *ignoreMatcher) loadAncestors(root string) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return
+	}
+
+	// Nothing to inherit when root is itself the top of a repository
+	if _, err := os.Stat(filepath.Join(absRoot, ".git")); err == nil {
+		return
+	}
+
+	var ancestors []string
+	foundRepo := false
+	for dir := filepath.Dir(absRoot); ; dir = filepath.Dir(dir) {
+		ancestors = append(ancestors, dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			foundRepo = true
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if !foundRepo {
+		return
+	}
+
+	// Load from the outermost directory inwards; later rules take precedence
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		m.loadDir(ancestors[i])
+	}
+}
+
+// loadDir reads the ignore files in dir, if any
+func (m *ignoreMatcher) loadDir(dir string) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil || m.loaded[absDir] {
+		return
+	}
+	m.loaded[absDir] = true
+
+	for _, name := range ignoreFileNames {
+		file, err := os.Open(filepath.Join(absDir, name))
+		if err != nil {
+			continue
+		}
+		m.loadRules(absDir, file)
+		file.Close()
+	}
+}
+
+// loadRules adds the rules of an ignore file located in the directory base
+func (m *ignoreMatcher) loadRules(base string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(base, scanner.Text()); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// ignored reports whether a path is excluded; the last matching rule wins
+func (m *ignoreMatcher) ignored(path string, isDir bool) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, absPath)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if rule.pattern.MatchString(filepath.ToSlash(rel)) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// ignoredPath reports whether a file below root, given by its slash-separated relative path, is
+// excluded by the ignore files of root or of the directories leading to it, as a walk from root would find
+func (m *ignoreMatcher) ignoredPath(root, rel string) bool {
+	dir := root
+	m.loadDir(dir)
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if m.ignored(dir, true) {
+			return true
+		}
+		m.loadDir(dir)
+	}
+	return m.ignored(filepath.Join(root, filepath.FromSlash(rel)), false)
+}
+
+// parseIgnoreLine compiles one line of a .gitignore-style file
+func parseIgnoreLine(base string, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// Patterns containing a slash are relative to the ignore file; others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	re, err := globToRegexp(line)
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	if !anchored {
+		re, err = regexp.Compile(`(?:^|/)` + strings.TrimPrefix(re.String(), "^"))
+		if err != nil {
+			return ignoreRule{}, false
+		}
+	}
+	rule.pattern = re
+	return rule, true
+}
+
+// globToRegexp converts a slash-separated glob into an anchored regular expression.
+// `**` matches across directories, `*` and `?` stay within one path segment.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// "**/" matches zero or more directories
+					i++
+					sb.WriteString(`(?:.*/)?`)
+				} else {
+					sb.WriteString(`.*`)
+				}
+			} else {
+				sb.WriteString(`[^/]*`)
+			}
+		case '?':
+			sb.WriteString(`[^/]`)
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/main.go", false},
+		{"pkg/*.go", "pkg/main.go", true},
+		{"pkg/*.go", "pkg/sub/main.go", false},
+		{"pkg/**/*.go", "pkg/main.go", true},
+		{"pkg/**/*.go", "pkg/a/b/main.go", true},
+		{"pkg/**", "pkg/a/b", true},
+		{"**/test", "a/b/test", true},
+		{"**/test", "a/b/test.go", false},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file/.txt", false},
+		{"[abc].md", "b.md", true},
+		{"[!abc].md", "b.md", false},
+		{"[!abc].md", "d.md", true},
+		{"[unclosed", "[unclosed", true},
+		{"a+b(1).txt", "a+b(1).txt", true},
+	}
+	for _, tt := range tests {
+		re, err := globToRegexp(tt.glob)
+		if err != nil {
+			t.Fatalf("globToRegexp(%q): %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.match {
+			t.Errorf("globToRegexp(%q) matches %q = %v, want %v", tt.glob, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	base := t.TempDir()
+	rules := strings.Join([]string{
+		"# build output",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/vendor",
+		"docs/*.tmp",
+		"\\#notes",
+		"",
+	}, "\n")
+	if err := os.WriteFile(filepath.Join(base, ".gitignore"), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := newIgnoreMatcher()
+	m.loadDir(base)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"debug.log", false, true},
+		{"sub/dir/debug.log", false, true},
+		{"keep.log", false, false},
+		{"sub/keep.log", false, false},
+		{"build", true, true},
+		{"sub/build", true, true},
+		{"build", false, false}, // Directory-only rules do not match files
+		{"vendor", true, true},
+		{"sub/vendor", true, false}, // Rules with a slash are anchored to the ignore file
+		{"docs/a.tmp", false, true},
+		{"docs/sub/a.tmp", false, false},
+		{"#notes", false, true},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.ignored(filepath.Join(base, filepath.FromSlash(tt.path)), tt.isDir); got != tt.ignored {
+			t.Errorf("ignored(%q, dir %v) = %v, want %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+
+	// Rules only apply below the directory of their ignore file
+	if m.ignored(filepath.Join(filepath.Dir(base), "debug.log"), false) {
+		t.Error("a rule matched outside its base directory")
+	}
+}
+
+func TestExpandFilePaths(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":           "*.log\ngen/\n",
+		"main.go":              "package main\n",
+		"debug.log":            "log\n",
+		"pkg/a.go":             "package pkg\n",
+		"pkg/a_test.go":        "package pkg\n",
+		"pkg/sub/b.go":         "package sub\n",
+		"pkg/sub/.gitignore":   "b.go\n",
+		"pkg/gen/generated.go": "package gen\n",
+		"docs/readme.md":       "# docs\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Ignore files above an expanded directory apply only within a git repository
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	join := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name     string
+		entries  []string
+		maxFiles int
+		want     []string
+		skipped  int
+	}{
+		{"directory", join("."), 0, join("docs/readme.md", "main.go", "pkg/a.go", "pkg/a_test.go"), 0},
+		{"recursive suffix", join("pkg/..."), 0, join("pkg/a.go", "pkg/a_test.go"), 0},
+		{"glob", join("pkg/**/*.go"), 0, join("pkg/a.go", "pkg/a_test.go"), 0},
+		{"explicit ignored file", join("debug.log", "main.go"), 0, join("debug.log", "main.go"), 0},
+		{"duplicates", join("main.go", "*.go"), 0, join("main.go"), 0},
+		{"file limit", join("."), 2, join("docs/readme.md", "main.go"), 2},
+		{"no matches", join("*.rs"), 0, nil, 1},
+		{"missing", join("missing.go"), 0, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := expandFilePaths(tt.entries, tt.maxFiles)
+			if got := report.Included(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("included = %v, want %v", got, tt.want)
+			}
+			if skipped := len(report.Entries) - len(report.Included()); skipped != tt.skipped {
+				t.Errorf("%d entries not included, want %d: %+v", skipped, tt.skipped, report.Entries)
+			}
+		})
+	}
+}
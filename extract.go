@@ -0,0 +1,564 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MIME types of the documents that are converted to text
+const (
+	mimeNotebook = "application/x-ipynb+json"
+	mimePDF      = "application/pdf"
+	mimeDocx     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeXlsx     = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// Limits on the text extracted from documents
+const (
+	maxNotebookOutputLines = 50               // Lines kept of each cell output
+	maxSpreadsheetRows     = 1000             // Rows kept of each sheet
+	maxSpreadsheetCells    = 100000           // Cells, including empty ones, kept of each sheet
+	maxSpreadsheetColumns  = 16384            // Columns of a worksheet, the last being XFD
+	maxDocumentPartSize    = 64 * 1024 * 1024 // Largest XML part read from an office document
+)
+
+// Extractor converts a document into text that can be embedded in a prompt
+type Extractor interface {
+	// Extract returns the text of a document and the markdown fence language of that text
+	Extract(content []byte) (string, string, error)
+}
+
+// ExtractorFunc adapts a function to the Extractor interface
+type ExtractorFunc func(content []byte) (string, string, error)
+
+// Extract implements the Extractor interface
+func (f ExtractorFunc) Extract(content []byte) (string, string, error) {
+	return f(content)
+}
+
+// builtinExtractors are the extractors available without configuration, keyed by MIME type
+var builtinExtractors = map[string]Extractor{
+	mimeNotebook: ExtractorFunc(extractNotebook),
+	mimePDF:      ExtractorFunc(extractPDF),
+	mimeDocx:     ExtractorFunc(extractDocx),
+	mimeXlsx:     ExtractorFunc(extractXlsx),
+}
+
+// notebookText is a notebook field holding text either as a string or as a list of lines
+type notebookText string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*t = notebookText(text)
+	return nil
+}
+
+// notebook is the part of the Jupyter notebook format (nbformat 4) that is extracted
+type notebook struct {
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []struct {
+		CellType string       `json:"cell_type"`
+		Source   notebookText `json:"source"`
+		Outputs  []struct {
+			OutputType string                  `json:"output_type"`
+			Text       notebookText            `json:"text"`
+			Data       map[string]notebookText `json:"data"`
+			EName      string                  `json:"ename"`
+			EValue     string                  `json:"evalue"`
+		} `json:"outputs"`
+	} `json:"cells"`
+}
+
+// extractNotebook converts a Jupyter notebook into a script in the percent format: `# %%` starts
+// a code cell, `# %% [markdown]` a markdown cell with commented lines, and text outputs follow their
+// cell as comments. Images and other rich outputs are only mentioned.
+func extractNotebook(content []byte) (string, string, error) {
+	var nb notebook
+	if err := json.Unmarshal(content, &nb); err != nil {
+		return "", "", fmt.Errorf("invalid notebook: %w", err)
+	}
+
+	language := strings.ToLower(nb.Metadata.LanguageInfo.Name)
+	if language == "" {
+		language = strings.ToLower(nb.Metadata.KernelSpec.Language)
+	}
+	if language == "" {
+		language = "python"
+	}
+	comment := "#"
+	switch language {
+	case "python", "r", "julia", "ruby", "bash", "sh", "perl":
+	default:
+		comment = "//"
+	}
+	commented := func(sb *strings.Builder, text string) {
+		for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			sb.WriteString(strings.TrimRight(comment+" "+line, " ") + "\n")
+		}
+	}
+
+	var sb strings.Builder
+	for i, cell := range nb.Cells {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		switch cell.CellType {
+		case "code":
+			sb.WriteString(comment + " %%\n")
+			sb.WriteString(strings.TrimRight(string(cell.Source), "\n") + "\n")
+		case "markdown":
+			sb.WriteString(comment + " %% [markdown]\n")
+			commented(&sb, string(cell.Source))
+			continue
+		default:
+			sb.WriteString(fmt.Sprintf("%s %%%% [%s]\n", comment, cell.CellType))
+			commented(&sb, string(cell.Source))
+			continue
+		}
+
+		for _, output := range cell.Outputs {
+			var text string
+			switch output.OutputType {
+			case "stream":
+				text = string(output.Text)
+			case "error":
+				text = output.EName + ": " + output.EValue
+			default:
+				if plain, ok := output.Data["text/plain"]; ok {
+					text = string(plain)
+				} else {
+					var types []string
+					for mime := range output.Data {
+						types = append(types, mime)
+					}
+					sort.Strings(types)
+					text = fmt.Sprintf("[%s output omitted]", strings.Join(types, ", "))
+				}
+			}
+			if text == "" {
+				continue
+			}
+			lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+			if len(lines) > maxNotebookOutputLines {
+				omitted := len(lines) - maxNotebookOutputLines
+				lines = append(lines[:maxNotebookOutputLines], fmt.Sprintf("... (%d more lines)", omitted))
+			}
+			sb.WriteString(comment + " Output:\n")
+			commented(&sb, strings.Join(lines, "\n"))
+		}
+	}
+	return sb.String(), language, nil
+}
+
+// readZipPart reads one part of an office document, returning nil when the part does not exist
+func readZipPart(reader *zip.Reader, name string) ([]byte, error) {
+	for _, file := range reader.File {
+		if file.Name != name {
+			continue
+		}
+		if file.UncompressedSize64 > maxDocumentPartSize {
+			return nil, fmt.Errorf("%s is larger than %s", name, humanReadableSize(maxDocumentPartSize))
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, maxDocumentPartSize))
+	}
+	return nil, nil
+}
+
+// openOfficeDocument opens the zip container of a .docx or .xlsx file
+func openOfficeDocument(content []byte) (*zip.Reader, error) {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("not an Office Open XML document: %w", err)
+	}
+	return reader, nil
+}
+
+// attr returns the value of the attribute with the given local name
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// extractDocx converts a Word document into markdown: headings become `#` lines, paragraphs are
+// separated by blank lines and table rows are written with `|` between cells
+func extractDocx(content []byte) (string, string, error) {
+	reader, err := openOfficeDocument(content)
+	if err != nil {
+		return "", "", err
+	}
+	document, err := readZipPart(reader, "word/document.xml")
+	if err != nil {
+		return "", "", err
+	}
+	if document == nil {
+		return "", "", errors.New("word/document.xml is missing")
+	}
+
+	var sb, paragraph strings.Builder
+	heading := 0
+	tableDepth, tableRows := 0, 0
+	var row, cell []string
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("invalid document.xml: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			case "pStyle":
+				// Built-in heading styles are named Heading1 to Heading9, or Title
+				style := strings.ToLower(attr(t, "val"))
+				if level, err := strconv.Atoi(strings.TrimPrefix(style, "heading")); err == nil && strings.HasPrefix(style, "heading") {
+					heading = min(max(level, 1), 6)
+				} else if style == "title" {
+					heading = 1
+				}
+			case "tbl":
+				tableDepth++
+				tableRows = 0
+			case "tr":
+				row = nil
+			case "tc":
+				cell = nil
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(paragraph.String())
+				paragraph.Reset()
+				if tableDepth > 0 {
+					if text != "" {
+						cell = append(cell, text)
+					}
+				} else if text != "" {
+					if heading > 0 {
+						sb.WriteString(strings.Repeat("#", heading) + " ")
+					}
+					sb.WriteString(text + "\n\n")
+				}
+				heading = 0
+			case "tc":
+				// Cells may hold several paragraphs; they are joined into one line
+				row = append(row, strings.Join(cell, " "))
+			case "tr":
+				if tableDepth > 0 {
+					sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+					// The first row is taken as the header, as markdown tables require one
+					if tableRows == 0 {
+						sb.WriteString(strings.Repeat("|---", len(row)) + "|\n")
+					}
+					tableRows++
+				}
+			case "tbl":
+				tableDepth--
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()) + "\n", "markdown", nil
+}
+
+// extractXlsx converts an Excel workbook into markdown with one section per sheet and the sheet's
+// rows as CSV. Formulas are represented by their last calculated value.
+func extractXlsx(content []byte) (string, string, error) {
+	reader, err := openOfficeDocument(content)
+	if err != nil {
+		return "", "", err
+	}
+
+	sharedStrings, err := readSharedStrings(reader)
+	if err != nil {
+		return "", "", err
+	}
+	sheets, err := readSheetList(reader)
+	if err != nil {
+		return "", "", err
+	}
+	if len(sheets) == 0 {
+		return "", "", errors.New("the workbook has no sheets")
+	}
+
+	var sb strings.Builder
+	for _, sheet := range sheets {
+		data, err := readZipPart(reader, sheet.part)
+		if err != nil {
+			return "", "", err
+		}
+		if data == nil {
+			continue
+		}
+		rows, omitted, err := readSheetRows(data, sharedStrings)
+		if err != nil {
+			return "", "", fmt.Errorf("sheet %s: %w", sheet.name, err)
+		}
+
+		sb.WriteString(fmt.Sprintf("## Sheet: %s\n\n", sheet.name))
+		if len(rows) == 0 && omitted == 0 {
+			sb.WriteString("(empty)\n\n")
+			continue
+		}
+		writer := csv.NewWriter(&sb)
+		if err := writer.WriteAll(rows); err != nil {
+			return "", "", err
+		}
+		if omitted > 0 {
+			sb.WriteString(fmt.Sprintf("... (%d more rows)\n", omitted))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), "markdown", nil
+}
+
+// workbookSheet is a sheet listed in a workbook together with the zip part holding its cells
+type workbookSheet struct {
+	name string
+	part string
+}
+
+// readSheetList returns the sheets of a workbook in tab order
+func readSheetList(reader *zip.Reader) ([]workbookSheet, error) {
+	workbook, err := readZipPart(reader, "xl/workbook.xml")
+	if err != nil || workbook == nil {
+		return nil, fmt.Errorf("xl/workbook.xml is missing or unreadable: %v", err)
+	}
+	relationships, err := readZipPart(reader, "xl/_rels/workbook.xml.rels")
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(relationships))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "Relationship" {
+			target := attr(element, "Target")
+			if strings.HasPrefix(target, "/") {
+				target = strings.TrimPrefix(target, "/")
+			} else {
+				target = path.Join("xl", target)
+			}
+			targets[attr(element, "Id")] = target
+		}
+	}
+
+	var sheets []workbookSheet
+	decoder = xml.NewDecoder(bytes.NewReader(workbook))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid workbook.xml: %w", err)
+		}
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "sheet" {
+			sheet := workbookSheet{name: attr(element, "name"), part: targets[attr(element, "id")]}
+			if sheet.part == "" {
+				sheet.part = fmt.Sprintf("xl/worksheets/sheet%d.xml", len(sheets)+1)
+			}
+			sheets = append(sheets, sheet)
+		}
+	}
+	return sheets, nil
+}
+
+// readSharedStrings returns the shared string table cells refer to by index
+func readSharedStrings(reader *zip.Reader) ([]string, error) {
+	data, err := readZipPart(reader, "xl/sharedStrings.xml")
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var strs []string
+	var current strings.Builder
+	inText := false
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return strs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sharedStrings.xml: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				// Phonetic hints repeat the text in another script
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "si":
+				strs = append(strs, current.String())
+			}
+		}
+	}
+}
+
+// readSheetRows returns the cell values of a worksheet as rows, placing cells in the columns
+// given by their references so that empty cells keep their position, and the number of rows left
+// out. Rows are kept until there are maxSpreadsheetRows of them or they would hold more than
+// maxSpreadsheetCells cells.
+func readSheetRows(data []byte, sharedStrings []string) ([][]string, int, error) {
+	var rows [][]string
+	var row []string
+	cells, omitted := 0, 0
+	full := false // No more rows are kept
+	var cellType, cellRef string
+	var value strings.Builder
+	inValue := false
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return rows, omitted, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid worksheet: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = nil
+			case "c":
+				cellType, cellRef = attr(t, "t"), attr(t, "r")
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				if full {
+					continue
+				}
+				text := value.String()
+				switch cellType {
+				case "s":
+					if index, err := strconv.Atoi(text); err == nil && index >= 0 && index < len(sharedStrings) {
+						text = sharedStrings[index]
+					}
+				case "b":
+					text = map[string]string{"0": "FALSE", "1": "TRUE"}[text]
+				}
+				column, err := cellColumn(cellRef)
+				if err != nil {
+					return nil, 0, err
+				}
+				if column < len(row) {
+					column = len(row)
+				}
+				if cells+column+1 > maxSpreadsheetCells {
+					full = true
+					continue
+				}
+				for len(row) < column {
+					row = append(row, "")
+				}
+				row = append(row, text)
+			case "row":
+				if full {
+					omitted++
+					continue
+				}
+				rows = append(rows, row)
+				cells += len(row)
+				full = len(rows) >= maxSpreadsheetRows
+			}
+		}
+	}
+}
+
+// cellColumn returns the zero-based column of a cell reference such as "C7", or -1 without one.
+// References beyond the last column, XFD, are rejected.
+func cellColumn(ref string) (int, error) {
+	column := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		column = column*26 + int(c-'A'+1)
+		if column > maxSpreadsheetColumns {
+			return 0, fmt.Errorf("cell reference %s is beyond the last column XFD", ref)
+		}
+	}
+	return column - 1, nil
+}
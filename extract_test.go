@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// zipDocument builds an office document container from parts given as name, content pairs
+func zipDocument(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(parts); i += 2 {
+		w, err := zw.Create(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(parts[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pdfWithStreams builds a minimal PDF holding the given content streams
+func pdfWithStreams(streams ...string) []byte {
+	var sb strings.Builder
+	sb.WriteString("%PDF-1.4\n")
+	for i, stream := range streams {
+		filter := ""
+		if strings.HasPrefix(stream, "\x78") {
+			filter = " /Filter /FlateDecode"
+		}
+		sb.WriteString(fmt.Sprintf("%d 0 obj\n<< /Length %d%s >>\nstream\n%s\nendstream\nendobj\n", i+1, len(stream), filter, stream))
+	}
+	sb.WriteString("%%EOF\n")
+	return []byte(sb.String())
+}
+
+// deflate compresses data with zlib as PDF FlateDecode streams are
+func deflate(data string) string {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	return buf.String()
+}
+
+func TestExtractNotebook(t *testing.T) {
+	tests := []struct {
+		name     string
+		notebook string
+		want     string
+		language string
+	}{
+		{
+			"python cells and outputs",
+			`{"metadata":{"language_info":{"name":"python"}},"cells":[
+				{"cell_type":"markdown","source":["# Title\n","Intro"]},
+				{"cell_type":"code","source":"print(1)\n","outputs":[{"output_type":"stream","text":["1\n"]}]},
+				{"cell_type":"code","source":"plot()","outputs":[{"output_type":"display_data","data":{"image/png":"AAAA"}}]},
+				{"cell_type":"code","source":"1/0","outputs":[{"output_type":"error","ename":"ZeroDivisionError","evalue":"division by zero"}]}]}`,
+			"# %% [markdown]\n# # Title\n# Intro\n\n# %%\nprint(1)\n# Output:\n# 1\n\n# %%\nplot()\n# Output:\n# [image/png output omitted]\n\n# %%\n1/0\n# Output:\n# ZeroDivisionError: division by zero\n",
+			"python",
+		},
+		{
+			"other language comments",
+			`{"metadata":{"kernelspec":{"language":"JavaScript"}},"cells":[{"cell_type":"markdown","source":"Note"},{"cell_type":"code","source":"x"}]}`,
+			"// %% [markdown]\n// Note\n\n// %%\nx\n",
+			"javascript",
+		},
+		{
+			"long output",
+			`{"cells":[{"cell_type":"code","source":"loop()","outputs":[{"output_type":"execute_result","data":{"text/plain":"` + strings.Repeat(`x\n`, maxNotebookOutputLines+5) + `"}}]}]}`,
+			"# %%\nloop()\n# Output:\n" + strings.Repeat("# x\n", maxNotebookOutputLines) + "# ... (5 more lines)\n",
+			"python",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, language, err := extractNotebook([]byte(tt.notebook))
+			if err != nil {
+				t.Fatalf("extractNotebook: %v", err)
+			}
+			if got != tt.want || language != tt.language {
+				t.Errorf("extractNotebook = %q (%s), want %q (%s)", got, language, tt.want, tt.language)
+			}
+		})
+	}
+
+	if _, _, err := extractNotebook([]byte("not json")); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestExtractDocx(t *testing.T) {
+	document := `<w:document><w:body>
+		<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr><w:r><w:t>Report</w:t></w:r></w:p>
+		<w:p><w:pPr><w:pStyle w:val="Heading2"/></w:pPr><w:r><w:t>Summary</w:t></w:r></w:p>
+		<w:p><w:r><w:t>First</w:t></w:r><w:r><w:tab/><w:t>line</w:t></w:r></w:p>
+		<w:tbl>
+			<w:tr><w:tc><w:p><w:r><w:t>Name</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Value</w:t></w:r></w:p></w:tc></w:tr>
+			<w:tr><w:tc><w:p><w:r><w:t>a</w:t></w:r></w:p><w:p><w:r><w:t>b</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>1</w:t></w:r></w:p></w:tc></w:tr>
+		</w:tbl>
+	</w:body></w:document>`
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr bool
+	}{
+		{"headings, paragraphs and tables", zipDocument(t, "word/document.xml", document),
+			"# Report\n\n## Summary\n\nFirst\tline\n\n| Name | Value |\n|---|---|\n| a b | 1 |\n", false},
+		{"missing document part", zipDocument(t, "word/styles.xml", "<w:styles/>"), "", true},
+		{"not a zip", []byte("plain text"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := extractDocx(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractDocx error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("extractDocx = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCellColumn(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    int
+		wantErr bool
+	}{
+		{"A1", 0, false},
+		{"Z9", 25, false},
+		{"AA1", 26, false},
+		{"XFD1048576", maxSpreadsheetColumns - 1, false},
+		{"", -1, false},
+		{"XFE1", 0, true},
+		{"ZZZZZZZZZZZZZZZZ1", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := cellColumn(tt.ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("cellColumn(%q) = %d, %v; want %d, error %v", tt.ref, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadSheetRows(t *testing.T) {
+	sheet := func(rows ...string) []byte {
+		return []byte("<worksheet><sheetData>" + strings.Join(rows, "") + "</sheetData></worksheet>")
+	}
+	manyRows := make([]string, maxSpreadsheetRows+3)
+	for i := range manyRows {
+		manyRows[i] = fmt.Sprintf(`<row><c r="A%d"><v>%d</v></c></row>`, i+1, i)
+	}
+	// Each row reaches column 1000, so the cell limit is hit long before the row limit
+	wideRows := make([]string, 200)
+	for i := range wideRows {
+		wideRows[i] = fmt.Sprintf(`<row><c r="ALL%d"><v>1</v></c></row>`, i+1)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantRows    int
+		wantOmitted int
+		wantFirst   []string
+		wantErr     bool
+	}{
+		{"values and gaps", sheet(`<row><c r="A1" t="s"><v>1</v></c><c r="C1" t="b"><v>1</v></c><c r="D1" t="inlineStr"><is><t>x</t></is></c></row>`),
+			1, 0, []string{"second", "", "TRUE", "x"}, false},
+		{"row limit", sheet(manyRows...), maxSpreadsheetRows, 3, []string{"0"}, false},
+		{"cell limit", sheet(wideRows...), maxSpreadsheetCells / 1000, 200 - maxSpreadsheetCells/1000, nil, false},
+		{"column beyond XFD", sheet(`<row><c r="XFE1"><v>1</v></c></row>`), 0, 0, nil, true},
+		{"invalid XML", []byte("<worksheet><row>"), 0, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, omitted, err := readSheetRows(tt.data, []string{"first", "second"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readSheetRows error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(rows) != tt.wantRows || omitted != tt.wantOmitted {
+				t.Errorf("got %d rows and %d omitted, want %d and %d", len(rows), omitted, tt.wantRows, tt.wantOmitted)
+			}
+			if tt.wantFirst != nil && strings.Join(rows[0], ",") != strings.Join(tt.wantFirst, ",") {
+				t.Errorf("first row = %q, want %q", rows[0], tt.wantFirst)
+			}
+			cells := 0
+			for _, row := range rows {
+				cells += len(row)
+			}
+			if cells > maxSpreadsheetCells {
+				t.Errorf("kept %d cells, limit %d", cells, maxSpreadsheetCells)
+			}
+		})
+	}
+}
+
+func TestExtractXlsx(t *testing.T) {
+	workbook := zipDocument(t,
+		"xl/workbook.xml", `<workbook><sheets><sheet name="Data" r:id="rId1"/><sheet name="Empty" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels", `<Relationships><Relationship Id="rId1" Target="worksheets/data.xml"/><Relationship Id="rId2" Target="/xl/worksheets/empty.xml"/></Relationships>`,
+		"xl/sharedStrings.xml", `<sst><si><t>name</t></si><si><r><t>a,</t></r><r><t>b</t></r><rPh><t>ignored</t></rPh></si></sst>`,
+		"xl/worksheets/data.xml", `<worksheet><sheetData><row><c r="A1" t="s"><v>0</v></c><c r="B1"><v>2.5</v></c></row><row><c r="B2" t="s"><v>1</v></c></row></sheetData></worksheet>`,
+		"xl/worksheets/empty.xml", `<worksheet><sheetData/></worksheet>`,
+	)
+	got, language, err := extractXlsx(workbook)
+	if err != nil {
+		t.Fatalf("extractXlsx: %v", err)
+	}
+	want := "## Sheet: Data\n\nname,2.5\n,\"a,b\"\n\n## Sheet: Empty\n\n(empty)\n\n"
+	if got != want || language != "markdown" {
+		t.Errorf("extractXlsx = %q (%s), want %q", got, language, want)
+	}
+
+	if _, _, err := extractXlsx(zipDocument(t, "xl/styles.xml", "<styleSheet/>")); err == nil {
+		t.Error("expected an error for a workbook without workbook.xml")
+	}
+}
+
+func TestExtractPDF(t *testing.T) {
+	bomb := pdfWithStreams(deflate("BT (x) Tj ET\n" + strings.Repeat(" ", 8<<20)))
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr string
+	}{
+		{"uncompressed", pdfWithStreams("BT /F1 12 Tf 72 712 Td (Hello, PDF) Tj ET"), "Hello, PDF\n", ""},
+		{"flate", pdfWithStreams(deflate("BT /F1 12 Tf (Compressed) Tj ET")), "Compressed\n", ""},
+		{"several streams", pdfWithStreams("BT (One) Tj ET", deflate("BT (Two) Tj ET")), "One\n\nTwo\n", ""},
+		{"missing header", []byte("BT (x) Tj ET"), "", "missing %PDF- header"},
+		{"encrypted", append(pdfWithStreams("BT (x) Tj ET"), "trailer << /Encrypt 5 0 R >>"...), "", "encrypted"},
+		{"no text", pdfWithStreams("0 0 m 10 10 l S"), "", "no text found"},
+		{"decompression bomb", bomb, "", "possible decompression bomb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := extractPDF(tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractPDF error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("extractPDF = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Errors returned by file validation, so callers can tell why a file was rejected
+var (
+	ErrPathNotAllowed     = errors.New("path not allowed")
+	ErrFileNotFound       = errors.New("file not found or not accessible")
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+	ErrFileTooLarge       = errors.New("file is too large")
+	ErrFileUnreadable     = errors.New("file could not be read")
+	ErrBudgetExceeded     = errors.New("request budget exceeded")
 )
 
 // readFileFromDisk reads a file from disk - a wrapper around os.ReadFile that adds more context to errors
@@ -11,49 +30,646 @@ func readFileFromDisk(filePath string) ([]byte, error) {
 	return readFile(filePath)
 }
 
-// ValidateFilePath validates a file path exists and has a supported extension
-func ValidateFilePath(path string, allowedTypes []string) error {
+// ValidateFilePath validates a file path exists, has an allowed type and is no larger than maxSize,
+// and returns the detected file type. A maxSize of 0 or less disables the size check and an empty
+// allowedTypes allows every type; binary content is rejected unless it is a document with an extractor.
+func ValidateFilePath(path string, fileTypes *FileTypeRegistry, allowedTypes []string, maxSize int64) (FileType, error) {
 	// Check if file exists
 	info, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("file not found or not accessible: %w", err)
+		return FileType{}, fmt.Errorf("%w: %s", ErrFileNotFound, path)
 	}
-	
+
 	// Check if it's a regular file
 	if info.IsDir() {
-		return fmt.Errorf("path is a directory, not a file: %s", path)
+		return FileType{}, fmt.Errorf("path is a directory, not a file: %s", path)
+	}
+
+	// Check the detected file type is allowed
+	fileType, err := fileTypes.DetectFile(path)
+	if err != nil {
+		return FileType{}, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	if err := checkFileType(path, fileType, fileTypes, allowedTypes); err != nil {
+		return FileType{}, err
 	}
-	
+
 	// Check if file is too large
-	if info.Size() > 10*1024*1024 { // 10MB limit by default
-		return fmt.Errorf("file is too large: %s (%s)", path, humanReadableSize(info.Size()))
-	}
-	
-	// Check file extension is allowed
-	if len(allowedTypes) > 0 {
-		mimeType := getMimeTypeFromPath(path)
-		allowed := false
-		for _, allowedType := range allowedTypes {
-			if mimeType == allowedType {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			return fmt.Errorf("file type not allowed: %s (type: %s)", path, mimeType)
+	if maxSize > 0 && info.Size() > maxSize {
+		return FileType{}, fmt.Errorf("%w: %s (%s, limit %s)", ErrFileTooLarge, path,
+			humanReadableSize(info.Size()), humanReadableSize(maxSize))
+	}
+
+	return fileType, nil
+}
+
+// checkFileType rejects types that are not allowed and binary content that cannot be converted to text
+func checkFileType(path string, fileType FileType, fileTypes *FileTypeRegistry, allowedTypes []string) error {
+	if fileType.Binary {
+		if _, ok := fileTypes.Extractor(fileType.MIME); !ok {
+			return fmt.Errorf("%w: %s (binary content of type %s, for which no text extractor exists)", ErrFileTypeNotAllowed, path, fileType.MIME)
 		}
 	}
-	
+	if len(allowedTypes) > 0 && !mimeAllowed(fileType.MIME, allowedTypes) {
+		return fmt.Errorf("%w: %s (type: %s)", ErrFileTypeNotAllowed, path, fileType.MIME)
+	}
 	return nil
 }
 
-// GetFileInfo returns information about a file
-func GetFileInfo(path string) (string, int64, error) {
+// GetFileInfo returns the detected MIME type and the size of a file
+func GetFileInfo(path string, fileTypes *FileTypeRegistry) (string, int64, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		return "", 0, err
 	}
-	
-	mimeType := getMimeTypeFromPath(path)
-	return mimeType, info.Size(), nil
+
+	fileType, err := fileTypes.DetectFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return fileType.MIME, info.Size(), nil
+}
+
+// validateAndReadFile checks that a single file lies inside the workspace and meets the configured
+// type and size limits, then reads it, extracting the text of documents. It is used by every tool that reads a file on behalf of the caller.
+func (s *DeepseekServer) validateAndReadFile(path string) ([]byte, error) {
+	path, err := s.workspace.Resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPathNotAllowed, err)
+	}
+	fileType, err := ValidateFilePath(path, s.fileTypes, s.config.AllowedFileTypes, s.config.MaxFileSize)
+	if err != nil {
+		return nil, err
+	}
+	content, err := readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	text, _, err := s.fileTypes.Decode(fileType, content)
+	return text, err
+}
+
+// FileValidator checks files against the workspace and the per-file limits and keeps a running
+// total of the bytes and estimated tokens included in a single request
+type FileValidator struct {
+	workspace      *Workspace
+	fileTypes      *FileTypeRegistry
+	redactor       *Redactor
+	allowedTypes   []string
+	maxFileSize    int64
+	maxTotalSize   int64
+	maxTotalTokens int
+	totalSize      int64
+	totalTokens    int
+}
+
+// NewFileValidator creates a validator for one request using the configured limits
+func NewFileValidator(config *Config, workspace *Workspace, fileTypes *FileTypeRegistry, redactor *Redactor) *FileValidator {
+	return &FileValidator{
+		workspace:      workspace,
+		fileTypes:      fileTypes,
+		redactor:       redactor,
+		allowedTypes:   config.AllowedFileTypes,
+		maxFileSize:    config.MaxFileSize,
+		maxTotalSize:   config.MaxTotalFileSize,
+		maxTotalTokens: config.MaxRequestTokens,
+	}
+}
+
+// Read validates and reads a file, returning it decoded to UTF-8 with secrets redacted, together
+// with its type. It does not count towards the request budget until the file is admitted.
+func (v *FileValidator) Read(path string) (*LoadedFile, error) {
+	path, err := v.workspace.Resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPathNotAllowed, err)
+	}
+	fileType, err := ValidateFilePath(path, v.fileTypes, v.allowedTypes, v.maxFileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	text, fileType, err := v.fileTypes.Decode(fileType, content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v.redact(&LoadedFile{Path: path, Content: text, Type: fileType, Lines: countLines(text)})
+}
+
+// Inspect validates content that did not come from the workspace, such as a file read from a git
+// revision, against the type and size limits. The path is only used to determine the file type.
+func (v *FileValidator) Inspect(path string, content []byte) (*LoadedFile, error) {
+	fileType := v.fileTypes.Detect(path, content[:min(len(content), sniffLength)])
+	if err := checkFileType(path, fileType, v.fileTypes, v.allowedTypes); err != nil {
+		return nil, err
+	}
+	if v.maxFileSize > 0 && int64(len(content)) > v.maxFileSize {
+		return nil, fmt.Errorf("%w: %s (%s, limit %s)", ErrFileTooLarge, path,
+			humanReadableSize(int64(len(content))), humanReadableSize(v.maxFileSize))
+	}
+	text, fileType, err := v.fileTypes.Decode(fileType, content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v.redact(&LoadedFile{Path: path, Content: text, Type: fileType, Lines: countLines(text)})
+}
+
+// countLines returns the number of lines in content; a final newline does not start another line
+func countLines(content []byte) int {
+	lines := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// redact replaces likely secrets in a file with placeholders. In strict mode a file containing
+// secrets is rejected with ErrSecretDetected instead.
+func (v *FileValidator) redact(file *LoadedFile) (*LoadedFile, error) {
+	content, _, err := v.redactor.Check(string(file.Content))
+	if err != nil {
+		return nil, fmt.Errorf("%s contains %w", file.Path, err)
+	}
+	file.Content = []byte(content)
+	return file, nil
+}
+
+// Admit estimates the tokens of the given files and adds them to the request budget. Files that
+// would push the request over its byte or token budget are rejected together with ErrBudgetExceeded.
+func (v *FileValidator) Admit(files []*LoadedFile) error {
+	var size int64
+	tokens := 0
+	for _, file := range files {
+		file.Tokens = deepseek.EstimateTokenCount(string(file.Content)).EstimatedTokens
+		size += int64(len(file.Content))
+		tokens += file.Tokens
+	}
+
+	if v.maxTotalSize > 0 && v.totalSize+size > v.maxTotalSize {
+		return fmt.Errorf("%w: total size limit of %s reached", ErrBudgetExceeded, humanReadableSize(v.maxTotalSize))
+	}
+	if v.maxTotalTokens > 0 && v.totalTokens+tokens > v.maxTotalTokens {
+		return fmt.Errorf("%w: token limit of %d reached", ErrBudgetExceeded, v.maxTotalTokens)
+	}
+
+	v.totalSize += size
+	v.totalTokens += tokens
+	return nil
+}
+
+// LoadedFile is a validated file, or an excerpt of one, ready to be embedded in a prompt
+type LoadedFile struct {
+	Path      string
+	Content   []byte
+	Type      FileType
+	Tokens    int
+	StartLine int    // First line of an excerpt, 0 for the whole file
+	EndLine   int    // Last line of an excerpt, 0 for the whole file
+	Symbol    string // Symbol the excerpt was selected by, if any
+	Trimmed   string // How the content was shortened to fit the context window, if it was
+	OmitFrom  int    // First line cut from the middle of the file to fit the context window, 0 if none
+	OmitTo    int    // Last line cut from the middle of the file
+	Priority  int    // Index of the file_paths entry the file came from; lower is more important
+	Explicit  bool   // Whether the entry named this file rather than a directory or pattern
+	Inline    bool   // Whether the content was supplied in the request; Path is then the given name
+	Archive   string // Name of the archive the file was unpacked from; Path is then the path inside it
+	Name      string // Path shown to the model and used in citations, e.g. relative to the workspace root
+	Lines     int    // Number of lines in the whole file, which excerpts may cite beyond
+}
+
+// Label describes the file for prompt headers, e.g. `cmd/server/main.go (lines 240-330)`.
+// Files are labelled with their Name, so that files with the same base name remain distinct.
+func (f *LoadedFile) Label() string {
+	label := f.Name
+	if label == "" {
+		label = filepath.Base(f.Path)
+	}
+	if f.StartLine > 0 {
+		if f.Symbol != "" {
+			label += " " + f.Symbol
+		}
+		label = fmt.Sprintf("%s (lines %d-%d)", label, f.StartLine, f.EndLine)
+	}
+	if f.Archive != "" {
+		label += " in " + f.Archive
+	}
+	if f.Trimmed != "" {
+		label += " [" + f.Trimmed + "]"
+	}
+	return label
+}
+
+// Embed renders the file as a prompt section: a header with its label followed by the content in a
+// code fence. With lineNumbers, each line is prefixed with its line number in the whole file so the
+// model can cite it; numbering continues past the lines headTailFile omitted. Outlines are never
+// numbered, as they already give the lines of each declaration.
+func (f *LoadedFile) Embed(lineNumbers bool) string {
+	content := string(f.Content)
+	if lineNumbers && f.Trimmed != trimmedOutline && content != "" {
+		lines := splitLinesKeepEnds(content)
+		number := max(f.StartLine, 1)
+		width := len(strconv.Itoa(max(f.Lines, f.EndLine, number+len(lines)-1)))
+
+		var sb strings.Builder
+		for _, line := range lines {
+			var from, to int
+			if n, _ := fmt.Sscanf(line, omittedLinesFormat, &from, &to); n == 2 {
+				sb.WriteString(line)
+				number = to + 1
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("%*d | %s", width, number, line))
+			number++
+		}
+		content = sb.String()
+	}
+	return fmt.Sprintf("## %s\n\n```%s\n%s\n```", f.Label(), f.Type.Language, content)
+}
+
+// entryPriority returns the index of the file_paths entry a file came from and whether that entry
+// named the file itself. Files from directories and patterns belong to the most specific one.
+func entryPriority(path string, origins []string) (int, bool) {
+	best, bestLength := len(origins), -1
+	for i, origin := range origins {
+		if origin == path {
+			return i, true
+		}
+		base := origin
+		if j := strings.IndexAny(base, "*?["); j >= 0 {
+			base = filepath.Dir(base[:j+1])
+		}
+		if strings.HasPrefix(path, strings.TrimSuffix(base, string(os.PathSeparator))+string(os.PathSeparator)) && len(base) > bestLength {
+			best, bestLength = i, len(base)
+		}
+	}
+	return best, false
+}
+
+// InlineFile is file content supplied with a request instead of a path, for clients that do not
+// share a filesystem with the server
+type InlineFile struct {
+	Name     string
+	Content  string
+	Language string
+}
+
+// parseInlineFiles reads a `files` argument: an array of objects with a name, content and optional language
+func parseInlineFiles(raw interface{}) ([]InlineFile, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New("files must be an array of {name, content, language} objects")
+	}
+
+	var files []InlineFile
+	names := make(map[string]bool)
+	for i, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("files[%d] must be an object with name and content", i)
+		}
+		name, _ := object["name"].(string)
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "\r\n`") {
+			return nil, fmt.Errorf("files[%d] needs a name on a single line without backticks", i)
+		}
+		content, ok := object["content"].(string)
+		if !ok {
+			return nil, fmt.Errorf("files[%d] (%s) needs its content as a string", i, name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("files[%d]: the name %s is used more than once", i, name)
+		}
+		names[name] = true
+		language, _ := object["language"].(string)
+		files = append(files, InlineFile{Name: name, Content: content, Language: strings.TrimSpace(language)})
+	}
+	return files, nil
+}
+
+// loadRequestFiles resolves the requested paths inside the workspace, expands them and runs every
+// resulting file, followed by the inline files, through a FileValidator with a token budget of maxTokens.
+// Entries with a line range or symbol selector contribute only the selected excerpt. The returned
+// report records what happened to each file.
+func (s *DeepseekServer) loadRequestFiles(ctx context.Context, entries []string, inline []InlineFile, maxTokens int) ([]LoadedFile, *FileReport) {
+	logger := getLoggerFromContext(ctx)
+
+	// Resolve each entry before expanding it so directories and patterns outside the workspace are never walked
+	var resolved, origins []string
+	var rejected []FileReportEntry
+	selectors := make(map[string][]FileSelector)
+	wholeFiles := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, selector, err := parseFileSelector(entry)
+		if err != nil {
+			rejected = append(rejected, FileReportEntry{Path: entry, Status: fileSkipped, Reason: err.Error()})
+			continue
+		}
+		recursive := strings.HasSuffix(target, "/...") || target == "..."
+		if selector != nil && (recursive || strings.ContainsAny(target, "*?[")) {
+			rejected = append(rejected, FileReportEntry{Path: entry, Status: fileSkipped,
+				Reason: "line ranges and symbols can only select from a single file"})
+			continue
+		}
+		target = strings.TrimSuffix(strings.TrimSuffix(target, "..."), "/")
+		if target == "" {
+			target = "."
+		}
+		absPath, err := s.workspace.Resolve(target)
+		if err != nil {
+			logger.Warn("Rejecting file path %s: %v", entry, err)
+			rejected = append(rejected, FileReportEntry{Path: entry, Status: fileRejectedPath, Reason: err.Error()})
+			continue
+		}
+		if selector != nil {
+			if info, err := os.Stat(absPath); err == nil && info.IsDir() {
+				rejected = append(rejected, FileReportEntry{Path: entry, Status: fileSkipped,
+					Reason: "line ranges and symbols can only select from a single file"})
+				continue
+			}
+			if !containsSelector(selectors[absPath], *selector) {
+				selectors[absPath] = append(selectors[absPath], *selector)
+			}
+		} else {
+			wholeFiles[absPath] = true
+		}
+		origins = append(origins, absPath)
+		if recursive {
+			absPath += "/..."
+		}
+		resolved = append(resolved, absPath)
+	}
+
+	report := expandFilePaths(resolved, s.config.MaxFilesPerRequest)
+	report.Entries = append(rejected, report.Entries...)
+	validator := NewFileValidator(s.config, s.workspace, s.fileTypes, s.redactor)
+	validator.maxTotalTokens = maxTokens
+
+	// Sort the files so the same set of files always produces the same prompt prefix,
+	// which lets DeepSeek's server-side context cache serve it at a reduced price
+	var files []LoadedFile
+	for _, path := range sortedUniquePaths(report.Included()) {
+		// Archives are unpacked in memory, but only when named directly rather than found in a directory
+		if archiveFormat(path) != "" {
+			priority, explicit := entryPriority(path, origins)
+			switch {
+			case !explicit:
+				report.reject(path, fileRejectedType, "archives are only unpacked when named directly in file_paths")
+			case len(selectors[path]) > 0:
+				report.reject(path, fileSkipped, "line ranges and symbols cannot select from an archive")
+			default:
+				for _, member := range s.loadArchive(ctx, validator, report, path, len(files)) {
+					member.Priority = priority
+					files = append(files, *member)
+				}
+			}
+			continue
+		}
+
+		file, err := validator.Read(path)
+		if err != nil {
+			logger.Warn("Excluding file %s: %v", path, err)
+			report.reject(path, fileStatusForError(err), err.Error())
+			continue
+		}
+
+		// A file requested as a whole makes any excerpts of it redundant
+		parts := []*LoadedFile{file}
+		if pathSelectors := selectors[path]; len(pathSelectors) > 0 && !wholeFiles[path] {
+			parts, err = selectExcerpts(file, pathSelectors)
+			if err != nil {
+				logger.Warn("Excluding file %s: %v", path, err)
+				report.reject(path, fileSkipped, err.Error())
+				continue
+			}
+		}
+
+		if err := validator.Admit(parts); err != nil {
+			logger.Warn("Excluding file %s: %v", path, err)
+			report.reject(path, fileStatusForError(err), err.Error())
+			continue
+		}
+		report.setLoaded(path, parts)
+		priority, explicit := entryPriority(path, origins)
+		for _, part := range parts {
+			part.Priority, part.Explicit = priority, explicit
+			part.Name = s.workspace.RelPath(path)
+			files = append(files, *part)
+		}
+	}
+
+	// Inline files go through the same checks, but their names are never resolved on disk
+	for _, inlineFile := range inline {
+		if len(files) >= s.config.MaxFilesPerRequest {
+			report.add(inlineFile.Name, fileSkipped, fmt.Sprintf("limit of %d files per request reached", s.config.MaxFilesPerRequest))
+			continue
+		}
+		report.include(inlineFile.Name, int64(len(inlineFile.Content)))
+		file, err := validator.Inspect(inlineFile.Name, []byte(inlineFile.Content))
+		if err == nil {
+			err = validator.Admit([]*LoadedFile{file})
+		}
+		if err != nil {
+			logger.Warn("Excluding inline file %s: %v", inlineFile.Name, err)
+			report.reject(inlineFile.Name, fileStatusForError(err), err.Error())
+			continue
+		}
+		if inlineFile.Language != "" {
+			file.Type.Language = inlineFile.Language
+		}
+		file.Inline, file.Explicit = true, true
+		file.Name = inlineFile.Name
+		report.setLoaded(inlineFile.Name, []*LoadedFile{file})
+		files = append(files, *file)
+	}
+
+	logger.Info("Including %d file(s) in the query, total size: %s, ~%d tokens",
+		len(files), humanReadableSize(validator.totalSize), validator.totalTokens)
+	return files, report
+}
+
+// containsSelector reports whether selectors already holds selector
+func containsSelector(selectors []FileSelector, selector FileSelector) bool {
+	for _, existing := range selectors {
+		if existing == selector {
+			return true
+		}
+	}
+	return false
+}
+
+// selectExcerpts applies each selector to a file, returning the excerpts in file order
+func selectExcerpts(file *LoadedFile, selectors []FileSelector) ([]*LoadedFile, error) {
+	var excerpts []*LoadedFile
+	for _, selector := range selectors {
+		excerpt, err := selector.Apply(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: %w", filepath.Base(file.Path), selector, err)
+		}
+		excerpts = append(excerpts, excerpt)
+	}
+	sort.SliceStable(excerpts, func(i, j int) bool { return excerpts[i].StartLine < excerpts[j].StartLine })
+	return excerpts, nil
+}
+
+// maxReportedRejections caps the number of excluded files listed individually in a file report
+const maxReportedRejections = 50
+
+// File report statuses
+const (
+	fileIncluded       = "included"
+	fileRejectedPath   = "rejected_path"
+	fileRejectedType   = "rejected_type"
+	fileRejectedSize   = "rejected_size"
+	fileUnreadable     = "unreadable"
+	fileSkipped        = "skipped"
+	fileRejectedSecret = "rejected_secret"
+)
+
+// fileStatusForError maps a validation error to the report status describing it
+func fileStatusForError(err error) string {
+	switch {
+	case errors.Is(err, ErrPathNotAllowed):
+		return fileRejectedPath
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return fileRejectedType
+	case errors.Is(err, ErrFileTooLarge):
+		return fileRejectedSize
+	case errors.Is(err, ErrFileNotFound), errors.Is(err, ErrFileUnreadable):
+		return fileUnreadable
+	case errors.Is(err, ErrSecretDetected):
+		return fileRejectedSecret
+	default:
+		return fileSkipped
+	}
+}
+
+// FileReportEntry describes what happened to one requested file
+type FileReportEntry struct {
+	Path     string
+	Status   string
+	Reason   string
+	Size     int64
+	Tokens   int
+	Redacted int      // Number of secrets replaced with placeholders
+	Excerpts []string // Line ranges included when only part of the file was selected
+}
+
+// FileReport lists the files included in and excluded from a request
+type FileReport struct {
+	Entries []FileReportEntry
+}
+
+// include records a file selected for the request, before validation
+func (r *FileReport) include(path string, size int64) {
+	r.Entries = append(r.Entries, FileReportEntry{Path: path, Status: fileIncluded, Size: size})
+}
+
+// add records a file with the given status and reason
+func (r *FileReport) add(path string, status string, reason string) {
+	r.Entries = append(r.Entries, FileReportEntry{Path: path, Status: status, Reason: reason})
+}
+
+// remove deletes the entry of a path, e.g. an archive replaced by the entries of its members
+func (r *FileReport) remove(path string) {
+	for i := range r.Entries {
+		if r.Entries[i].Path == path {
+			r.Entries = append(r.Entries[:i], r.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Included returns the paths of all included files
+func (r *FileReport) Included() []string {
+	var paths []string
+	for _, entry := range r.Entries {
+		if entry.Status == fileIncluded {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths
+}
+
+// reject changes an included file to an excluded status, e.g. when it fails validation
+func (r *FileReport) reject(path string, status string, reason string) {
+	for i := range r.Entries {
+		if r.Entries[i].Path == path && r.Entries[i].Status == fileIncluded {
+			r.Entries[i].Status = status
+			r.Entries[i].Reason = reason
+			return
+		}
+	}
+}
+
+// setLoaded records the actual size and estimated tokens of an included file, summed over its excerpts
+func (r *FileReport) setLoaded(path string, parts []*LoadedFile) {
+	for i := range r.Entries {
+		if r.Entries[i].Path == path && r.Entries[i].Status == fileIncluded {
+			entry := &r.Entries[i]
+			entry.Size, entry.Tokens, entry.Redacted, entry.Excerpts = 0, 0, 0, nil
+			for _, part := range parts {
+				entry.Size += int64(len(part.Content))
+				entry.Tokens += part.Tokens
+				entry.Redacted += countRedactions(part.Content)
+				if part.StartLine > 0 {
+					entry.Excerpts = append(entry.Excerpts, fmt.Sprintf("lines %d-%d", part.StartLine, part.EndLine))
+				}
+			}
+			return
+		}
+	}
+}
+
+// Format renders the report as a markdown table
+func (r *FileReport) Format() string {
+	var included, excluded []FileReportEntry
+	var totalSize int64
+	totalTokens := 0
+	for _, entry := range r.Entries {
+		if entry.Status == fileIncluded {
+			included = append(included, entry)
+			totalSize += entry.Size
+			totalTokens += entry.Tokens
+		} else {
+			excluded = append(excluded, entry)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## Files\n\n**Included:** %d file(s), %s, ~%d tokens",
+		len(included), humanReadableSize(totalSize), totalTokens))
+	if len(excluded) > 0 {
+		sb.WriteString(fmt.Sprintf(" | **Excluded:** %d file(s)", len(excluded)))
+	}
+	sb.WriteString("\n\n")
+
+	sb.WriteString("| File | Status | Size | Tokens | Details |\n")
+	sb.WriteString("|------|--------|------|--------|---------|\n")
+	for _, entry := range included {
+		details := entry.Excerpts
+		if entry.Redacted > 0 {
+			details = append(details[:len(details):len(details)], fmt.Sprintf("%d secret(s) redacted", entry.Redacted))
+		}
+		sb.WriteString(fmt.Sprintf("| `%s` | %s | %s | %d | %s |\n",
+			entry.Path, entry.Status, humanReadableSize(entry.Size), entry.Tokens, strings.Join(details, ", ")))
+	}
+	for i, entry := range excluded {
+		if i >= maxReportedRejections {
+			sb.WriteString(fmt.Sprintf("| ... | | | | %d more excluded file(s) |\n", len(excluded)-i))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("| `%s` | %s | | | %s |\n",
+			entry.Path, entry.Status, strings.ReplaceAll(entry.Reason, "|", "\\|")))
+	}
+	return sb.String()
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFiles creates files with the given contents below dir
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestValidateFilePath(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"main.go":   "package main\n",
+		"notes.md":  "# notes\n",
+		"large.go":  strings.Repeat("x", 2048),
+		"pkg/a.go":  "package pkg\n",
+		"image.png": "\x89PNG",
+	})
+	fileTypes := NewFileTypeRegistry(nil)
+	allowed := []string{"text/x-go", "text/markdown"}
+
+	tests := []struct {
+		name    string
+		path    string
+		maxSize int64
+		want    error
+	}{
+		{"allowed", "main.go", 1024, nil},
+		{"other allowed type", "notes.md", 1024, nil},
+		{"type not allowed", "image.png", 1024, ErrFileTypeNotAllowed},
+		{"too large", "large.go", 1024, ErrFileTooLarge},
+		{"no size limit", "large.go", 0, nil},
+		{"missing", "missing.go", 1024, ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateFilePath(filepath.Join(dir, tt.path), fileTypes, allowed, tt.maxSize)
+			if !errors.Is(err, tt.want) || (err != nil) != (tt.want != nil) {
+				t.Errorf("ValidateFilePath(%s) = %v, want %v", tt.path, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := ValidateFilePath(filepath.Join(dir, "pkg"), fileTypes, nil, 0); err == nil {
+		t.Error("ValidateFilePath accepted a directory")
+	}
+}
+
+func TestFileValidatorBudgets(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("word ", 200) // 1000 bytes
+	writeTestFiles(t, dir, map[string]string{"a.txt": content, "b.txt": content, "c.txt": content})
+	ws, err := NewWorkspace([]string{dir}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		config    Config
+		wantLoads []bool // Whether a.txt, b.txt and c.txt are accepted, in turn
+	}{
+		{"no budgets", Config{}, []bool{true, true, true}},
+		{"total size", Config{MaxTotalFileSize: 2500}, []bool{true, true, false}},
+		{"total tokens", Config{MaxRequestTokens: 1}, []bool{false, false, false}},
+		{"file size", Config{MaxFileSize: 999}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewFileValidator(&tt.config, ws, NewFileTypeRegistry(nil), nil)
+			for i, name := range []string{"a.txt", "b.txt", "c.txt"} {
+				file, err := validator.Read(filepath.Join(dir, name))
+				if err == nil {
+					err = validator.Admit([]*LoadedFile{file})
+				}
+				if (err == nil) != tt.wantLoads[i] {
+					t.Fatalf("loading %s: error = %v, want accepted %v", name, err, tt.wantLoads[i])
+				}
+				if err == nil && (string(file.Content) != content || file.Tokens <= 0) {
+					t.Errorf("loaded %s: %d bytes, %d tokens", name, len(file.Content), file.Tokens)
+				}
+			}
+		})
+	}
+
+	// Files over the remaining budget are rejected together, without using up the budget
+	validator := NewFileValidator(&Config{MaxTotalFileSize: 2500}, ws, NewFileTypeRegistry(nil), nil)
+	var files []*LoadedFile
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file, err := validator.Read(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	if err := validator.Admit(files[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.Admit(files[1:]); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Admit over budget error = %v, want %v", err, ErrBudgetExceeded)
+	}
+	if validator.totalSize != 1000 {
+		t.Errorf("total size = %d after a rejected file, want 1000", validator.totalSize)
+	}
+}
+
+func TestLoadRequestFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go":      "package a\n",
+		"b.go":      strings.Repeat("// comment\n", 200),
+		"c.go":      "package c\n",
+		"image.png": "\x89PNG",
+	})
+	ws, err := NewWorkspace([]string{dir}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DeepseekServer{config: &Config{
+		AllowedFileTypes:   []string{"text/x-go"},
+		MaxFileSize:        1024,
+		MaxFilesPerRequest: 3,
+	}, workspace: ws, fileTypes: NewFileTypeRegistry(nil)}
+	ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+	paths := []string{"c.go", "a.go", "b.go", "image.png", "missing.go"}
+	for i := range paths {
+		paths[i] = filepath.Join(dir, paths[i])
+	}
+	paths = append(paths, "../escape.go")
+
+	files, report := s.loadRequestFiles(ctx, paths, nil, s.config.MaxRequestTokens)
+
+	var loaded []string
+	for _, file := range files {
+		loaded = append(loaded, filepath.Base(file.Path))
+	}
+	if strings.Join(loaded, ",") != "a.go,c.go" {
+		t.Errorf("loaded %v, want a.go and c.go in sorted order", loaded)
+	}
+
+	want := map[string]string{
+		"a.go":       fileIncluded,
+		"b.go":       fileRejectedSize,
+		"c.go":       fileIncluded,
+		"image.png":  fileSkipped, // Over the file limit before its type is checked
+		"missing.go": fileUnreadable,
+		"escape.go":  fileRejectedPath,
+	}
+	for _, entry := range report.Entries {
+		name := filepath.Base(entry.Path)
+		if entry.Status != want[name] {
+			t.Errorf("%s: status %s (%s), want %s", name, entry.Status, entry.Reason, want[name])
+		}
+		if entry.Status == fileIncluded && (entry.Size == 0 || entry.Tokens == 0) {
+			t.Errorf("%s: included without size and tokens: %+v", name, entry)
+		}
+	}
+	if len(report.Entries) != len(want) {
+		t.Errorf("report has %d entries, want %d", len(report.Entries), len(want))
+	}
+
+	formatted := report.Format()
+	for _, row := range []string{
+		"**Included:** 2 file(s)",
+		"**Excluded:** 4 file(s)",
+		"| `" + filepath.Join(dir, "a.go") + "` | included | 10 B |",
+		"| `" + filepath.Join(dir, "b.go") + "` | rejected_size | | | file is too large",
+	} {
+		if !strings.Contains(formatted, row) {
+			t.Errorf("report does not contain %q:\n%s", row, formatted)
+		}
+	}
+}
+
+func TestParseInlineFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     interface{}
+		want    []InlineFile
+		wantErr string
+	}{
+		{"absent", nil, nil, ""},
+		{"files", []interface{}{
+			map[string]interface{}{"name": " src/main.go ", "content": "package main\n"},
+			map[string]interface{}{"name": "query.sql", "content": "", "language": "sql"},
+		}, []InlineFile{{Name: "src/main.go", Content: "package main\n"}, {Name: "query.sql", Language: "sql"}}, ""},
+		{"not an array", "main.go", nil, "must be an array"},
+		{"not an object", []interface{}{"main.go"}, nil, "must be an object"},
+		{"missing name", []interface{}{map[string]interface{}{"content": "x"}}, nil, "needs a name"},
+		{"name with a newline", []interface{}{map[string]interface{}{"name": "a\nb", "content": "x"}}, nil, "needs a name"},
+		{"missing content", []interface{}{map[string]interface{}{"name": "a.go"}}, nil, "needs its content"},
+		{"duplicate name", []interface{}{
+			map[string]interface{}{"name": "a.go", "content": "x"},
+			map[string]interface{}{"name": "a.go", "content": "y"},
+		}, nil, "used more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInlineFiles(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || len(got) != len(tt.want) {
+				t.Fatalf("parseInlineFiles = %+v, %v; want %+v", got, err, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("file %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRequestFilesInline(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"disk.go": "package disk\n"})
+	ws, err := NewWorkspace([]string{dir}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DeepseekServer{config: &Config{
+		AllowedFileTypes:   []string{"text/x-go", "text/x-python"},
+		MaxFileSize:        1024,
+		MaxFilesPerRequest: 3,
+	}, workspace: ws, fileTypes: NewFileTypeRegistry(nil)}
+	ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+
+	inline := []InlineFile{
+		{Name: "src/server.go", Content: "package server\n"},
+		{Name: "logo.png", Content: "\x89PNG\r\n\x1a\n\x00\x00"},
+		{Name: "big.go", Content: strings.Repeat("// comment\n", 200)},
+		{Name: "tools/gen.py", Content: "print(1)\n", Language: "python3"},
+		{Name: "extra.go", Content: "package extra\n"},
+	}
+	files, report := s.loadRequestFiles(ctx, []string{filepath.Join(dir, "disk.go")}, inline, 0)
+
+	var loaded []string
+	for _, file := range files {
+		loaded = append(loaded, file.Label())
+		if file.Inline != (file.Path != filepath.Join(dir, "disk.go")) {
+			t.Errorf("%s: inline = %v", file.Path, file.Inline)
+		}
+	}
+	// Inline files keep their full name as the label, files on disk only their base name
+	if strings.Join(loaded, ",") != "disk.go,src/server.go,tools/gen.py" {
+		t.Errorf("loaded %v, want disk.go, src/server.go and tools/gen.py", loaded)
+	}
+	if len(files) == 3 && files[2].Type.Language != "python3" {
+		t.Errorf("language of tools/gen.py = %q, want the one given in the request", files[2].Type.Language)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src")); !os.IsNotExist(err) {
+		t.Error("an inline file was written to the workspace")
+	}
+
+	want := map[string]string{
+		filepath.Join(dir, "disk.go"): fileIncluded,
+		"src/server.go":               fileIncluded,
+		"tools/gen.py":                fileIncluded,
+		"logo.png":                    fileRejectedType,
+		"big.go":                      fileRejectedSize,
+		"extra.go":                    fileSkipped, // Over the file limit
+	}
+	for _, entry := range report.Entries {
+		if entry.Status != want[entry.Path] {
+			t.Errorf("%s: status %s (%s), want %s", entry.Path, entry.Status, entry.Reason, want[entry.Path])
+		}
+	}
+	if len(report.Entries) != len(want) {
+		t.Errorf("report has %d entries, want %d", len(report.Entries), len(want))
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// sniffLength is the number of leading bytes inspected to detect a file's type
+const sniffLength = 8000
+
+// Fallback MIME types for files whose type is not registered
+const (
+	mimePlainText = "text/plain"
+	mimeBinary    = "application/octet-stream"
+)
+
+// FileType describes a kind of file: its MIME type, the markdown fence language used when
+// embedding it in a prompt, and whether its content is binary rather than text
+type FileType struct {
+	MIME     string
+	Language string
+	Binary   bool
+}
+
+// builtinFileTypes lists the file types known without configuration, keyed by extension
+var builtinFileTypes = []struct {
+	extensions []string
+	fileType   FileType
+}{
+	{[]string{".txt", ".text", ".log"}, FileType{MIME: "text/plain", Language: "text"}},
+	{[]string{".md", ".markdown"}, FileType{MIME: "text/markdown", Language: "markdown"}},
+	{[]string{".rst"}, FileType{MIME: "text/x-rst", Language: "rst"}},
+	{[]string{".html", ".htm"}, FileType{MIME: "text/html", Language: "html"}},
+	{[]string{".css"}, FileType{MIME: "text/css", Language: "css"}},
+	{[]string{".scss", ".sass"}, FileType{MIME: "text/x-scss", Language: "scss"}},
+	{[]string{".csv"}, FileType{MIME: "text/csv", Language: "csv"}},
+	{[]string{".json"}, FileType{MIME: "application/json", Language: "json"}},
+	{[]string{".ipynb"}, FileType{MIME: mimeNotebook, Language: "json"}},
+	{[]string{".xml"}, FileType{MIME: "application/xml", Language: "xml"}},
+	{[]string{".yaml", ".yml"}, FileType{MIME: "text/x-yaml", Language: "yaml"}},
+	{[]string{".toml"}, FileType{MIME: "text/x-toml", Language: "toml"}},
+	{[]string{".ini", ".cfg", ".conf"}, FileType{MIME: "text/x-ini", Language: "ini"}},
+	{[]string{".go"}, FileType{MIME: "text/x-go", Language: "go"}},
+	{[]string{".py", ".pyi"}, FileType{MIME: "text/x-python", Language: "python"}},
+	{[]string{".js", ".mjs", ".cjs"}, FileType{MIME: "text/javascript", Language: "javascript"}},
+	{[]string{".jsx"}, FileType{MIME: "text/javascript", Language: "jsx"}},
+	{[]string{".ts", ".mts", ".cts"}, FileType{MIME: "text/x-typescript", Language: "typescript"}},
+	{[]string{".tsx"}, FileType{MIME: "text/x-typescript", Language: "tsx"}},
+	{[]string{".java"}, FileType{MIME: "text/x-java", Language: "java"}},
+	{[]string{".c", ".h"}, FileType{MIME: "text/x-c", Language: "c"}},
+	{[]string{".cpp", ".cc", ".cxx", ".hpp", ".hh"}, FileType{MIME: "text/x-c++", Language: "cpp"}},
+	{[]string{".cs"}, FileType{MIME: "text/x-csharp", Language: "csharp"}},
+	{[]string{".rs"}, FileType{MIME: "text/x-rust", Language: "rust"}},
+	{[]string{".rb"}, FileType{MIME: "text/x-ruby", Language: "ruby"}},
+	{[]string{".php"}, FileType{MIME: "text/x-php", Language: "php"}},
+	{[]string{".sh", ".bash", ".zsh"}, FileType{MIME: "text/x-shellscript", Language: "bash"}},
+	{[]string{".ps1"}, FileType{MIME: "text/x-powershell", Language: "powershell"}},
+	{[]string{".sql"}, FileType{MIME: "text/x-sql", Language: "sql"}},
+	{[]string{".swift"}, FileType{MIME: "text/x-swift", Language: "swift"}},
+	{[]string{".kt", ".kts"}, FileType{MIME: "text/x-kotlin", Language: "kotlin"}},
+	{[]string{".scala"}, FileType{MIME: "text/x-scala", Language: "scala"}},
+	{[]string{".groovy", ".gradle"}, FileType{MIME: "text/x-groovy", Language: "groovy"}},
+	{[]string{".pl", ".pm"}, FileType{MIME: "text/x-perl", Language: "perl"}},
+	{[]string{".r"}, FileType{MIME: "text/x-r", Language: "r"}},
+	{[]string{".m"}, FileType{MIME: "text/x-matlab", Language: "matlab"}},
+	{[]string{".fs", ".fsx"}, FileType{MIME: "text/x-fsharp", Language: "fsharp"}},
+	{[]string{".vb"}, FileType{MIME: "text/x-vb", Language: "vbnet"}},
+	{[]string{".dart"}, FileType{MIME: "text/x-dart", Language: "dart"}},
+	{[]string{".ex", ".exs"}, FileType{MIME: "text/x-elixir", Language: "elixir"}},
+	{[]string{".erl", ".hrl"}, FileType{MIME: "text/x-erlang", Language: "erlang"}},
+	{[]string{".hs"}, FileType{MIME: "text/x-haskell", Language: "haskell"}},
+	{[]string{".lua"}, FileType{MIME: "text/x-lua", Language: "lua"}},
+	{[]string{".jl"}, FileType{MIME: "text/x-julia", Language: "julia"}},
+	{[]string{".clj", ".cljs"}, FileType{MIME: "text/x-clojure", Language: "clojure"}},
+	{[]string{".proto"}, FileType{MIME: "text/x-protobuf", Language: "protobuf"}},
+	{[]string{".graphql", ".gql"}, FileType{MIME: "text/x-graphql", Language: "graphql"}},
+	{[]string{".tf", ".hcl"}, FileType{MIME: "text/x-hcl", Language: "hcl"}},
+	{[]string{".vue"}, FileType{MIME: "text/x-vue", Language: "vue"}},
+	{[]string{".svelte"}, FileType{MIME: "text/x-svelte", Language: "svelte"}},
+	{[]string{".diff", ".patch"}, FileType{MIME: "text/x-diff", Language: "diff"}},
+	{[]string{".svg"}, FileType{MIME: "image/svg+xml", Language: "xml"}},
+	{[]string{".pdf"}, FileType{MIME: mimePDF, Binary: true}},
+	{[]string{".png"}, FileType{MIME: "image/png", Binary: true}},
+	{[]string{".jpg", ".jpeg"}, FileType{MIME: "image/jpeg", Binary: true}},
+	{[]string{".gif"}, FileType{MIME: "image/gif", Binary: true}},
+	{[]string{".mp3"}, FileType{MIME: "audio/mpeg", Binary: true}},
+	{[]string{".wav"}, FileType{MIME: "audio/wav", Binary: true}},
+	{[]string{".mp4"}, FileType{MIME: "video/mp4", Binary: true}},
+	{[]string{".doc"}, FileType{MIME: "application/msword", Binary: true}},
+	{[]string{".docx"}, FileType{MIME: mimeDocx, Binary: true}},
+	{[]string{".xls"}, FileType{MIME: "application/vnd.ms-excel", Binary: true}},
+	{[]string{".xlsx"}, FileType{MIME: mimeXlsx, Binary: true}},
+	{[]string{".ppt", ".pptx"}, FileType{MIME: "application/vnd.ms-powerpoint", Binary: true}},
+	{[]string{".zip"}, FileType{MIME: mimeZip, Binary: true}},
+	{[]string{".tar"}, FileType{MIME: mimeTar, Binary: true}},
+	{[]string{".gz", ".tgz"}, FileType{MIME: mimeGzip, Binary: true}},
+}
+
+// builtinFileNames maps well-known file names without a meaningful extension to their type
+var builtinFileNames = map[string]FileType{
+	"makefile":    {MIME: "text/x-makefile", Language: "makefile"},
+	"gnumakefile": {MIME: "text/x-makefile", Language: "makefile"},
+	"dockerfile":  {MIME: "text/x-dockerfile", Language: "dockerfile"},
+	"jenkinsfile": {MIME: "text/x-groovy", Language: "groovy"},
+	"gemfile":     {MIME: "text/x-ruby", Language: "ruby"},
+	"rakefile":    {MIME: "text/x-ruby", Language: "ruby"},
+	"go.mod":      {MIME: "text/plain", Language: "go"},
+	"go.sum":      {MIME: "text/plain", Language: "text"},
+}
+
+// builtinInterpreters maps shebang interpreters (without version suffix) to the type of their scripts
+var builtinInterpreters = map[string]FileType{
+	"sh":      {MIME: "text/x-shellscript", Language: "bash"},
+	"bash":    {MIME: "text/x-shellscript", Language: "bash"},
+	"zsh":     {MIME: "text/x-shellscript", Language: "bash"},
+	"python":  {MIME: "text/x-python", Language: "python"},
+	"node":    {MIME: "text/javascript", Language: "javascript"},
+	"deno":    {MIME: "text/x-typescript", Language: "typescript"},
+	"ts-node": {MIME: "text/x-typescript", Language: "typescript"},
+	"ruby":    {MIME: "text/x-ruby", Language: "ruby"},
+	"perl":    {MIME: "text/x-perl", Language: "perl"},
+	"php":     {MIME: "text/x-php", Language: "php"},
+	"lua":     {MIME: "text/x-lua", Language: "lua"},
+}
+
+// FileTypeRegistry determines file types from the file name, a shebang line and the file content,
+// and converts documents of the types it has an extractor for into text
+type FileTypeRegistry struct {
+	extensions   map[string]FileType
+	names        map[string]FileType
+	interpreters map[string]FileType
+	extractors   map[string]Extractor
+}
+
+// NewFileTypeRegistry creates a registry with the built-in types and extractors plus the given extension overrides
+func NewFileTypeRegistry(overrides map[string]FileType) *FileTypeRegistry {
+	r := &FileTypeRegistry{
+		extensions:   make(map[string]FileType),
+		names:        make(map[string]FileType),
+		interpreters: make(map[string]FileType),
+		extractors:   make(map[string]Extractor),
+	}
+	for _, entry := range builtinFileTypes {
+		for _, ext := range entry.extensions {
+			r.extensions[ext] = entry.fileType
+		}
+	}
+	for name, fileType := range builtinFileNames {
+		r.names[name] = fileType
+	}
+	for interpreter, fileType := range builtinInterpreters {
+		r.interpreters[interpreter] = fileType
+	}
+	for mime, extractor := range builtinExtractors {
+		r.extractors[mime] = extractor
+	}
+	for ext, fileType := range overrides {
+		r.Register(ext, fileType)
+	}
+	return r
+}
+
+// Register adds or replaces the file type for an extension
+func (r *FileTypeRegistry) Register(ext string, fileType FileType) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if fileType.Language == "" {
+		fileType.Language = "text"
+	}
+	r.extensions[ext] = fileType
+}
+
+// RegisterExtractor adds or replaces the extractor converting documents of a MIME type to text
+func (r *FileTypeRegistry) RegisterExtractor(mime string, extractor Extractor) {
+	r.extractors[mime] = extractor
+}
+
+// Extractor returns the extractor registered for a MIME type
+func (r *FileTypeRegistry) Extractor(mime string) (Extractor, bool) {
+	extractor, ok := r.extractors[mime]
+	return extractor, ok
+}
+
+// Decode converts file content into the text embedded in a prompt. Documents are run through the
+// extractor registered for their type, other text is converted to UTF-8. The returned type has the
+// fence language of the text. Binary content without an extractor is rejected with ErrFileTypeNotAllowed.
+func (r *FileTypeRegistry) Decode(fileType FileType, content []byte) ([]byte, FileType, error) {
+	extractor, ok := r.extractors[fileType.MIME]
+	if !ok {
+		if fileType.Binary {
+			return nil, fileType, fmt.Errorf("%w: no text extractor for %s", ErrFileTypeNotAllowed, fileType.MIME)
+		}
+		return decodeText(content), fileType, nil
+	}
+
+	text, language, err := extractor.Extract(content)
+	if err != nil {
+		return nil, fileType, fmt.Errorf("%w: could not extract text from %s: %v", ErrFileUnreadable, fileType.MIME, err)
+	}
+	fileType.Language = language
+	fileType.Binary = false
+	return []byte(text), fileType, nil
+}
+
+// ForPath returns the file type implied by a file's name alone
+func (r *FileTypeRegistry) ForPath(path string) (FileType, bool) {
+	base := strings.ToLower(filepath.Base(path))
+	if fileType, ok := r.names[base]; ok {
+		return fileType, true
+	}
+	fileType, ok := r.extensions[filepath.Ext(base)]
+	return fileType, ok
+}
+
+// Language returns the markdown fence language for a path, or "text" when it is unknown
+func (r *FileTypeRegistry) Language(path string) string {
+	if fileType, ok := r.ForPath(path); ok && fileType.Language != "" {
+		return fileType.Language
+	}
+	return "text"
+}
+
+// Detect determines a file's type from its name and leading content. The name is tried first,
+// then a shebang line; content that turns out to be binary overrides a text type.
+func (r *FileTypeRegistry) Detect(path string, head []byte) FileType {
+	fileType, known := r.ForPath(path)
+	if !known {
+		if interpreterType, ok := r.interpreters[shebangInterpreter(head)]; ok {
+			fileType, known = interpreterType, true
+		}
+	}
+
+	if isBinaryContent(head) {
+		if known && fileType.Binary {
+			return fileType
+		}
+		return FileType{MIME: mimeBinary, Binary: true}
+	}
+	if !known {
+		return FileType{MIME: mimePlainText, Language: "text"}
+	}
+	return fileType
+}
+
+// DetectFile reads the start of a file and determines its type
+func (r *FileTypeRegistry) DetectFile(path string) (FileType, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileType{}, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return FileType{}, err
+	}
+	return r.Detect(path, head[:n]), nil
+}
+
+// shebangInterpreter returns the interpreter named by a `#!` line, without path or version,
+// e.g. "python" for "#!/usr/bin/env python3.11"
+func shebangInterpreter(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+	line := string(head[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = filepath.Base(field)
+				break
+			}
+		}
+	}
+	return strings.TrimRight(interpreter, "0123456789.")
+}
+
+// Byte order marks recognised when sniffing and decoding text
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// isBinaryContent reports whether content looks binary: it contains a NUL byte, or more than
+// 10% of it is invalid UTF-8 or control characters. Text with a UTF-8 or UTF-16 BOM is never binary.
+func isBinaryContent(head []byte) bool {
+	if bytes.HasPrefix(head, bomUTF8) || bytes.HasPrefix(head, bomUTF16LE) || bytes.HasPrefix(head, bomUTF16BE) {
+		return false
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	suspicious := 0
+	for i := 0; i < len(head); {
+		r, size := utf8.DecodeRune(head[i:])
+		if r == utf8.RuneError && size == 1 {
+			// A multi-byte sequence cut off at the end of the sniffed window is not suspicious
+			if len(head)-i < utf8.UTFMax && !utf8.FullRune(head[i:]) {
+				break
+			}
+			suspicious++
+		} else if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\b' && r != 0x1b {
+			suspicious++
+		}
+		i += size
+	}
+	return suspicious*10 > len(head)
+}
+
+// decodeText converts text content to UTF-8 without a byte order mark.
+// UTF-16 content is recognised by its BOM; anything else is returned unchanged.
+func decodeText(content []byte) []byte {
+	switch {
+	case bytes.HasPrefix(content, bomUTF8):
+		return content[len(bomUTF8):]
+	case bytes.HasPrefix(content, bomUTF16LE):
+		return decodeUTF16(content[len(bomUTF16LE):], false)
+	case bytes.HasPrefix(content, bomUTF16BE):
+		return decodeUTF16(content[len(bomUTF16BE):], true)
+	default:
+		return content
+	}
+}
+
+// decodeUTF16 converts UTF-16 code units to UTF-8, ignoring a trailing odd byte
+func decodeUTF16(content []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(content[2*i])<<8 | uint16(content[2*i+1])
+		} else {
+			units[i] = uint16(content[2*i+1])<<8 | uint16(content[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// parseFileTypeOverrides parses DEEPSEEK_FILE_TYPES entries of the form `ext=mime` or
+// `ext=mime:language`, separated by commas, e.g. `vue=text/x-vue:vue,tpl=text/plain`
+func parseFileTypeOverrides(spec string) (map[string]FileType, error) {
+	overrides := make(map[string]FileType)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ext, value, ok := strings.Cut(entry, "=")
+		ext = strings.TrimSpace(ext)
+		if !ok || ext == "" || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("entry %q must have the form ext=mime or ext=mime:language", entry)
+		}
+		mime, language, _ := strings.Cut(strings.TrimSpace(value), ":")
+		if !strings.Contains(mime, "/") {
+			return nil, fmt.Errorf("entry %q has an invalid MIME type %q", entry, mime)
+		}
+		overrides[ext] = FileType{MIME: mime, Language: language}
+	}
+	return overrides, nil
+}
+
+// mimeAllowed reports whether a MIME type matches one of the allowed types.
+// An allowed type ending in `/*` matches every subtype, e.g. `text/*`.
+func mimeAllowed(mime string, allowedTypes []string) bool {
+	for _, allowedType := range allowedTypes {
+		if allowedType == mime || allowedType == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(allowedType, "/*") && strings.HasPrefix(mime, strings.TrimSuffix(allowedType, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileTypeRegistryDetect(t *testing.T) {
+	r := NewFileTypeRegistry(map[string]FileType{"VUE": {MIME: "text/x-vue", Language: "vue"}, "tpl": {MIME: "text/plain"}})
+
+	tests := []struct {
+		name         string
+		path         string
+		head         string
+		wantMIME     string
+		wantLanguage string
+		wantBinary   bool
+	}{
+		{"extension", "pkg/main.go", "package main\n", "text/x-go", "go", false},
+		{"upper case extension", "README.MD", "# Title\n", "text/markdown", "markdown", false},
+		{"well-known name", "build/Dockerfile", "FROM alpine\n", "text/x-dockerfile", "dockerfile", false},
+		{"shebang", "bin/deploy", "#!/bin/bash\nset -e\n", "text/x-shellscript", "bash", false},
+		{"env shebang with version", "tools/gen", "#!/usr/bin/env -S python3.11 -u\nprint(1)\n", "text/x-python", "python", false},
+		{"extension wins over shebang", "script.rb", "#!/usr/bin/env python\n", "text/x-ruby", "ruby", false},
+		{"unknown text", "notes.unknown", "plain words\n", mimePlainText, "text", false},
+		{"override", "App.vue", "<template></template>\n", "text/x-vue", "vue", false},
+		{"override without language", "page.tpl", "{{ .Title }}\n", "text/plain", "text", false},
+		{"binary content with a text extension", "data.txt", "abc\x00def", mimeBinary, "", true},
+		{"invalid UTF-8", "blob", "\x80\x81\x82\x83\xfd\xfc text", mimeBinary, "", true},
+		{"known binary type", "archive.zip", "PK\x03\x04\x00\x00", "application/zip", "", true},
+		{"UTF-16 text", "notes.txt", "\xff\xfeh\x00i\x00", "text/plain", "text", false},
+		{"UTF-8 cut off at the end", "text.md", "caf\xc3", "text/markdown", "markdown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Detect(tt.path, []byte(tt.head))
+			if got.MIME != tt.wantMIME || got.Language != tt.wantLanguage || got.Binary != tt.wantBinary {
+				t.Errorf("Detect(%q) = %+v, want MIME %q, language %q, binary %v", tt.path, got, tt.wantMIME, tt.wantLanguage, tt.wantBinary)
+			}
+		})
+	}
+
+	if got := r.Language("unknown.xyz"); got != "text" {
+		t.Errorf("Language of an unknown extension = %q, want text", got)
+	}
+}
+
+func TestShebangInterpreter(t *testing.T) {
+	tests := []struct {
+		head string
+		want string
+	}{
+		{"#!/bin/sh\n", "sh"},
+		{"#!/usr/bin/python3\n", "python"},
+		{"#!/usr/bin/env node\n", "node"},
+		{"#!/usr/bin/env -S VAR=1 deno run\n", "deno"},
+		{"#! /usr/local/bin/ruby2.7 -w\n", "ruby"},
+		{"#!\n", ""},
+		{"package main\n", ""},
+	}
+	for _, tt := range tests {
+		if got := shebangInterpreter([]byte(tt.head)); got != tt.want {
+			t.Errorf("shebangInterpreter(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"plain UTF-8", "héllo", "héllo"},
+		{"UTF-8 BOM", "\xef\xbb\xbfhello", "hello"},
+		{"UTF-16 little endian", "\xff\xfeh\x00\xe9\x00\n\x00", "hé\n"},
+		{"UTF-16 big endian", "\xfe\xff\x00h\x00\xe9", "hé"},
+		{"UTF-16 surrogate pair", "\xff\xfe\x3d\xd8\x00\xde", "\U0001F600"},
+		{"UTF-16 odd trailing byte", "\xff\xfeh\x00i", "h"},
+		{"Latin-1 left unchanged", "caf\xe9", "caf\xe9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(decodeText([]byte(tt.content))); got != tt.want {
+				t.Errorf("decodeText(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFileTypeOverrides(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]FileType
+		wantErr string
+	}{
+		{"", map[string]FileType{}, ""},
+		{"vue=text/x-vue:vue, tpl=text/plain", map[string]FileType{"vue": {MIME: "text/x-vue", Language: "vue"}, "tpl": {MIME: "text/plain"}}, ""},
+		{"vue", nil, "must have the form"},
+		{"=text/plain", nil, "must have the form"},
+		{"vue=vue", nil, "invalid MIME type"},
+	}
+	for _, tt := range tests {
+		got, err := parseFileTypeOverrides(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFileTypeOverrides(%q) error = %v, want one containing %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(got) != len(tt.want) {
+			t.Errorf("parseFileTypeOverrides(%q) = %v, %v; want %v", tt.spec, got, err, tt.want)
+			continue
+		}
+		for ext, fileType := range tt.want {
+			if got[ext] != fileType {
+				t.Errorf("parseFileTypeOverrides(%q)[%q] = %+v, want %+v", tt.spec, ext, got[ext], fileType)
+			}
+		}
+	}
+}
+
+func TestMimeAllowed(t *testing.T) {
+	tests := []struct {
+		mime    string
+		allowed []string
+		want    bool
+	}{
+		{"text/x-go", []string{"text/x-go"}, true},
+		{"text/x-go", []string{"text/*"}, true},
+		{"application/json", []string{"text/*"}, false},
+		{"application/zip", []string{"*/*"}, true},
+		{"text/x-go", nil, false},
+		{"textual/x", []string{"text/*"}, false},
+	}
+	for _, tt := range tests {
+		if got := mimeAllowed(tt.mime, tt.allowed); got != tt.want {
+			t.Errorf("mimeAllowed(%q, %v) = %v, want %v", tt.mime, tt.allowed, got, tt.want)
+		}
+	}
+}
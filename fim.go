@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/cohesion-org/deepseek-go/utils"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// Defaults for fill-in-the-middle completions
+const (
+	defaultFIMMaxTokens = 512
+	maxFIMMaxTokens     = 4096
+	defaultFIMModel     = "deepseek-chat"
+	diffContextLines    = 3
+)
+
+// betaBaseURL returns the base URL of DeepSeek's beta API, which hosts FIM and prefix completion
+func betaBaseURL(baseURL string) string {
+	if baseURL == "" {
+		baseURL = "https://api.deepseek.com/"
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/beta/"
+}
+
+// createFIMCompletion sends a FIM completion request to the client's base URL. The library's
+// CreateFIMCompletion always targets api.deepseek.com, so it cannot honor DEEPSEEK_BASE_URL.
+func createFIMCompletion(ctx context.Context, client *deepseek.Client, request *deepseek.FIMCompletionRequest) (*deepseek.FIMCompletionResponse, error) {
+	req, err := utils.NewRequestBuilder(client.AuthToken).
+		SetBaseURL(strings.TrimSuffix(client.BaseURL, "/")).
+		SetPath("/completions").
+		SetBodyFromStruct(request).
+		Build(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error building request: %w", err)
+	}
+
+	resp, err := deepseek.HandleNormalRequest(*client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, deepseek.HandleAPIError(resp)
+	}
+	response, err := deepseek.HandleFIMCompletionRequest(resp)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return response, nil
+}
+
+// splitAtCursor splits content at a 1-based line and column (counted in characters)
+func splitAtCursor(content string, line, column int) (string, string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	if line < 1 || line > len(lines) {
+		return "", "", fmt.Errorf("line %d is out of range (file has %d lines)", line, len(lines))
+	}
+
+	offset := 0
+	for _, l := range lines[:line-1] {
+		offset += len(l)
+	}
+
+	lineRunes := []rune(strings.TrimSuffix(lines[line-1], "\n"))
+	if column < 1 || column > len(lineRunes)+1 {
+		return "", "", fmt.Errorf("column %d is out of range (line %d has %d characters)", column, line, len(lineRunes))
+	}
+	offset += len(string(lineRunes[:column-1]))
+
+	return content[:offset], content[offset:], nil
+}
+
+// unifiedDiff renders a single-hunk unified diff between two versions of a file.
+// It is intended for contiguous edits such as an inserted completion.
+func unifiedDiff(path, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	oldLines := splitLinesKeepEnds(oldText)
+	newLines := splitLinesKeepEnds(newText)
+
+	// Trim the lines both versions share at the start and the end
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	start := prefix - diffContextLines
+	if start < 0 {
+		start = 0
+	}
+	oldEnd := len(oldLines) - suffix + diffContextLines
+	if oldEnd > len(oldLines) {
+		oldEnd = len(oldLines)
+	}
+	newEnd := len(newLines) - suffix + diffContextLines
+	if newEnd > len(newLines) {
+		newEnd = len(newLines)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("--- a/%s\n+++ b/%s\n", path, path))
+	sb.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(start, oldEnd-start), hunkRange(start, newEnd-start)))
+
+	writeLine := func(marker string, line string) {
+		sb.WriteString(marker + strings.TrimSuffix(line, "\n") + "\n")
+		if !strings.HasSuffix(line, "\n") {
+			sb.WriteString("\\ No newline at end of file\n")
+		}
+	}
+	for _, line := range oldLines[start:prefix] {
+		writeLine(" ", line)
+	}
+	for _, line := range oldLines[prefix : len(oldLines)-suffix] {
+		writeLine("-", line)
+	}
+	for _, line := range newLines[prefix : len(newLines)-suffix] {
+		writeLine("+", line)
+	}
+	for _, line := range oldLines[len(oldLines)-suffix : oldEnd] {
+		writeLine(" ", line)
+	}
+	return sb.String()
+}
+
+// splitLinesKeepEnds splits text into lines that keep their trailing newline
+func splitLinesKeepEnds(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunkRange formats the start,count part of a unified diff hunk header
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// handleComplete handles requests to the deepseek_complete tool
+func (s *DeepseekServer) handleComplete(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+
+	filePath, _ := req.Arguments["file_path"].(string)
+	prefix, hasPrefix := req.Arguments["prefix"].(string)
+	suffix, _ := req.Arguments["suffix"].(string)
+
+	if filePath != "" && hasPrefix {
+		return createErrorResponse("Please provide either file_path with line and column, or an explicit prefix (and optional suffix), not both"), nil
+	}
+
+	var original string
+	if filePath != "" {
+		line, lineOK := req.Arguments["line"].(float64)
+		column, columnOK := req.Arguments["column"].(float64)
+		if !lineOK || !columnOK {
+			return createErrorResponse("line and column are required when completing at a position in file_path"), nil
+		}
+
+		// Documents are converted to text when read, so a cursor position cannot be mapped back into them
+		if fileType, ok := s.fileTypes.ForPath(filePath); ok {
+			if _, isDocument := s.fileTypes.Extractor(fileType.MIME); isDocument {
+				return createErrorResponse(fmt.Sprintf("Cannot complete inside %s: %s files are documents, not source code", filePath, fileType.MIME)), nil
+			}
+		}
+
+		content, err := s.validateAndReadFile(filePath)
+		if err != nil {
+			logger.Error("Failed to read file: %v", err)
+			return createErrorResponse(fmt.Sprintf("Error reading file: %v", err)), nil
+		}
+		original = string(content)
+
+		prefix, suffix, err = splitAtCursor(original, int(line), int(column))
+		if err != nil {
+			return createErrorResponse(fmt.Sprintf("Invalid cursor position: %v", err)), nil
+		}
+	} else if !hasPrefix {
+		return createErrorResponse("Please provide either file_path with line and column, or an explicit prefix (and optional suffix)"), nil
+	} else {
+		original = prefix + suffix
+	}
+
+	model := defaultFIMModel
+	if customModel, ok := req.Arguments["model"].(string); ok && customModel != "" {
+		model = customModel
+	}
+
+	maxTokens := defaultFIMMaxTokens
+	if maxTokensRaw, ok := req.Arguments["max_tokens"].(float64); ok && maxTokensRaw > 0 {
+		maxTokens = int(maxTokensRaw)
+		if maxTokens > maxFIMMaxTokens {
+			maxTokens = maxFIMMaxTokens
+		}
+	}
+
+	// Only the text sent is redacted; the patch is computed against the original content
+	outboundPrefix, _, err := s.redactor.Check(prefix)
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("Request refused: the prefix contains %v", err)), nil
+	}
+	outboundSuffix, _, err := s.redactor.Check(suffix)
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("Request refused: the suffix contains %v", err)), nil
+	}
+
+	logger.Info("Requesting FIM completion with %s (prefix %d bytes, suffix %d bytes)", model, len(prefix), len(suffix))
+	tokens := deepseek.EstimateTokenCount(outboundPrefix).EstimatedTokens + deepseek.EstimateTokenCount(outboundSuffix).EstimatedTokens
+	fimRequest := &deepseek.FIMCompletionRequest{
+		Model:       model,
+		Prompt:      outboundPrefix,
+		Suffix:      outboundSuffix,
+		MaxTokens:   maxTokens,
+		Temperature: float64(s.config.DeepseekTemperature),
+	}
+	response, stats, err := executeWithRetry(ctx, s, tokens,
+		func(ctx context.Context) (*deepseek.FIMCompletionResponse, error) {
+			return createFIMCompletion(ctx, s.betaClient, fimRequest)
+		},
+		func(response *deepseek.FIMCompletionResponse) int { return response.Usage.TotalTokens })
+	if err != nil {
+		logger.Error("DeepSeek FIM API error: %v", err)
+		return createErrorResponse(fmt.Sprintf("Error from DeepSeek API: %v", err)), nil
+	}
+
+	var completion string
+	if len(response.Choices) > 0 {
+		completion = response.Choices[0].Text
+	}
+	if completion == "" {
+		return createErrorResponse("The DeepSeek model returned an empty completion for this position."), nil
+	}
+
+	patchPath := filePath
+	if patchPath == "" {
+		patchPath = "snippet"
+	}
+	patch := unifiedDiff(patchPath, original, prefix+completion+suffix)
+
+	var formattedContent strings.Builder
+	formattedContent.WriteString("# Completion\n\n")
+	formattedContent.WriteString(fmt.Sprintf("```%s\n%s\n```\n\n", s.fileTypes.Language(patchPath), completion))
+	formattedContent.WriteString("## Patch\n\n")
+	formattedContent.WriteString(fmt.Sprintf("```diff\n%s```\n", patch))
+	usage := deepseek.Usage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+	}
+	formattedContent.WriteString("\n" + formatUsage(usage, stats) + "\n")
+
+	return &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: formattedContent.String(),
+			},
+			{
+				Type: "text",
+				Text: completion,
+			},
+		},
+	}, nil
+}
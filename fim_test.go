@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+func TestSplitAtCursor(t *testing.T) {
+	content := "func main() {\n\tfmt.Println(\"héllo\")\n}\n"
+	tests := []struct {
+		name       string
+		line       int
+		column     int
+		wantPrefix string
+		wantErr    bool
+	}{
+		{"start of file", 1, 1, "", false},
+		{"middle of a line", 1, 6, "func ", false},
+		{"end of a line", 1, 14, "func main() {", false},
+		{"after a multibyte character", 2, 17, "func main() {\n\tfmt.Println(\"hé", false},
+		{"empty last line", 4, 1, content, false},
+		{"line out of range", 5, 1, "", true},
+		{"line zero", 0, 1, "", true},
+		{"column out of range", 3, 3, "", true},
+		{"column zero", 1, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, suffix, err := splitAtCursor(content, tt.line, tt.column)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitAtCursor error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if prefix != tt.wantPrefix || prefix+suffix != content {
+				t.Errorf("splitAtCursor = %q, %q; want prefix %q", prefix, suffix, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{"unchanged", "a\n", "a\n", ""},
+		{"inserted line", "a\nb\nc\nd\ne\n", "a\nb\nc\nx\nd\ne\n",
+			"--- a/f.go\n+++ b/f.go\n@@ -1,5 +1,6 @@\n a\n b\n c\n+x\n d\n e\n"},
+		{"completed line", "1\n2\n3\n4\nfunc (\n5\n6\n7\n8\n", "1\n2\n3\n4\nfunc f() (\n5\n6\n7\n8\n",
+			"--- a/f.go\n+++ b/f.go\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-func (\n+func f() (\n 5\n 6\n 7\n"},
+		{"no newline at end", "a", "ab", "--- a/f.go\n+++ b/f.go\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+ab\n\\ No newline at end of file\n"},
+		{"empty file", "", "x\n", "--- a/f.go\n+++ b/f.go\n@@ -0,0 +1,1 @@\n+x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f.go", tt.oldText, tt.newText); got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// fimTestServer creates a server whose beta client talks to an httptest server answering FIM
+// requests with the given statuses in turn, then with a completion of "x := 1"
+func fimTestServer(t *testing.T, statuses ...int) (*DeepseekServer, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if r.URL.Path != "/completions" {
+			t.Errorf("FIM request sent to %s", r.URL.Path)
+		}
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			w.Write([]byte(`{"error":{"message":"busy"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"f1","choices":[{"text":"x := 1","index":0}],"usage":{"prompt_tokens":8,"completion_tokens":3,"total_tokens":11}}`))
+	}))
+	t.Cleanup(api.Close)
+
+	betaClient := deepseek.NewClient("key", api.URL+"/")
+	betaClient.HTTPClient = &responseRecorder{next: http.DefaultClient}
+	config := &Config{HTTPTimeout: 5 * time.Second, MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	return &DeepseekServer{
+		config:     config,
+		betaClient: betaClient,
+		fileTypes:  NewFileTypeRegistry(nil),
+		limiter:    NewRateLimiter(config),
+		breaker:    NewCircuitBreaker(config),
+	}, &requests
+}
+
+func TestHandleComplete(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		arguments    string
+		wantErr      string
+		wantText     []string
+		wantRequests int32
+	}{
+		{
+			name:         "prefix and suffix",
+			arguments:    `{"prefix":"func f() {\n\t","suffix":"\n}\n"}`,
+			wantText:     []string{"x := 1", "--- a/snippet", "+\tx := 1", "**Attempts:** 1"},
+			wantRequests: 1,
+		},
+		{
+			name:         "retried after a server error",
+			statuses:     []int{http.StatusServiceUnavailable},
+			arguments:    `{"prefix":"func f() {\n\t"}`,
+			wantText:     []string{"x := 1", "**Attempts:** 2"},
+			wantRequests: 2,
+		},
+		{
+			name:         "not retried after an invalid request",
+			statuses:     []int{http.StatusBadRequest},
+			arguments:    `{"prefix":"func f() {\n\t"}`,
+			wantErr:      "Error from DeepSeek API",
+			wantRequests: 1,
+		},
+		{
+			name:      "file_path with prefix",
+			arguments: `{"file_path":"main.go","line":1,"column":1,"prefix":"x"}`,
+			wantErr:   "not both",
+		},
+		{
+			name:      "nothing to complete",
+			arguments: `{}`,
+			wantErr:   "Please provide either file_path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, requests := fimTestServer(t, tt.statuses...)
+			var arguments map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.arguments), &arguments); err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+
+			resp, err := s.handleComplete(ctx, &protocol.CallToolRequest{Name: "deepseek_complete", Arguments: arguments})
+			if err != nil {
+				t.Fatalf("handleComplete: %v", err)
+			}
+			text := resp.Content[0].Text
+			if tt.wantErr != "" {
+				if !resp.IsError || !strings.Contains(text, tt.wantErr) {
+					t.Errorf("response = %q, want an error containing %q", text, tt.wantErr)
+				}
+			} else {
+				if resp.IsError {
+					t.Fatalf("unexpected error response: %s", text)
+				}
+				for _, want := range tt.wantText {
+					if !strings.Contains(text, want) {
+						t.Errorf("response does not contain %q:\n%s", want, text)
+					}
+				}
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCreateFIMCompletion(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotRequest deepseek.FIMCompletionRequest
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&gotRequest); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if gotRequest.Prompt == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "bad prompt", "type": "invalid_request_error"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "fim-1", "choices": [{"text": "x := 1", "index": 0}], "usage": {"prompt_tokens": 5, "completion_tokens": 3, "total_tokens": 8}}`))
+	}))
+	defer api.Close()
+
+	// The beta client's base URL is derived from DEEPSEEK_BASE_URL, so requests must go there
+	client := deepseek.NewClient("key", betaBaseURL(api.URL))
+	request := &deepseek.FIMCompletionRequest{Model: "deepseek-chat", Prompt: "func main() {\n", Suffix: "}\n", MaxTokens: 32}
+
+	response, err := createFIMCompletion(context.Background(), client, request)
+	if err != nil {
+		t.Fatalf("createFIMCompletion: %v", err)
+	}
+	if gotPath != "/beta/completions" || gotAuth != "Bearer key" {
+		t.Errorf("request sent to %q with authorization %q, want /beta/completions with the API key", gotPath, gotAuth)
+	}
+	if gotRequest.Prompt != request.Prompt || gotRequest.Suffix != request.Suffix || gotRequest.MaxTokens != 32 {
+		t.Errorf("server received %+v, want %+v", gotRequest, *request)
+	}
+	if len(response.Choices) != 1 || response.Choices[0].Text != "x := 1" || response.Usage.TotalTokens != 8 {
+		t.Errorf("response = %+v, want the completion x := 1 using 8 tokens", response)
+	}
+
+	request.Prompt = "fail"
+	if _, err := createFIMCompletion(context.Background(), client, request); err == nil {
+		t.Error("createFIMCompletion succeeded on a 400 response")
+	}
+}
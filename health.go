@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// handleHealth handles requests to the deepseek_health tool
+func (s *DeepseekServer) handleHealth(ctx context.Context) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+	logger.Info("Reporting DeepSeek API health")
+
+	stats := s.breaker.Stats()
+
+	var sb strings.Builder
+	sb.WriteString("# DeepSeek API Health\n\n")
+	switch {
+	case !s.breaker.Enabled():
+		sb.WriteString("**Status:** unknown (the circuit breaker is disabled)\n\n")
+	case stats.State == breakerClosed:
+		sb.WriteString("**Status:** available\n\n")
+	case stats.State == breakerHalfOpen:
+		sb.WriteString("**Status:** recovering (the next request probes the API)\n\n")
+	default:
+		sb.WriteString("**Status:** unavailable (requests fail fast)\n\n")
+	}
+	sb.WriteString(formatBreakerStats(stats, s.config))
+	sb.WriteString("\n")
+	sb.WriteString(formatRateLimiterStats(s.limiter.Stats(), s.config))
+
+	sb.WriteString("\n## Retries\n\n")
+	sb.WriteString(fmt.Sprintf("**Timeout per attempt:** %s | **Max retries:** %d | **Backoff:** %s doubling up to %s\n",
+		s.config.HTTPTimeout, s.config.MaxRetries, s.config.InitialBackoff, s.config.MaxBackoff))
+
+	return &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: sb.String(),
+			},
+		},
+	}, nil
+}
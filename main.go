@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/gomcpgo/mcp/pkg/handler"
-	"github.com/gomcpgo/mcp/pkg/server"
 	_ "github.com/joho/godotenv/autoload"
 )
 
@@ -62,25 +60,17 @@ func main() {
 	// Store config in context for error handler to access
 	ctx = context.WithValue(ctx, configKey, config)
 
-	// Set up handler registry
-	// NewHandlerRegistry is a constructor that doesn't return an error
-	registry := handler.NewHandlerRegistry()
+	// Set up the MCP server on stdio
+	srv := NewStdioServer("deepseek", "1.0.0", os.Stdin, os.Stdout, logger)
 
 	// Create and register the DeepSeek server
-	if err := setupDeepseekServer(ctx, registry, config); err != nil {
+	if err := setupDeepseekServer(ctx, srv, config); err != nil {
 		handleStartupError(ctx, err)
 		return
 	}
 
-	// Start the MCP server
-	srv := server.New(server.Options{
-		Name:     "deepseek",
-		Version:  "1.0.0",
-		Registry: registry,
-	})
-
 	logger.Info("Starting DeepSeek MCP server")
-	if err := srv.Run(); err != nil {
+	if err := srv.Run(ctx); err != nil {
 		logger.Error("Server error: %v", err)
 		os.Exit(1)
 	}
@@ -88,8 +78,8 @@ func main() {
 
 
 
-// setupDeepseekServer creates and registers a DeepSeek server
-func setupDeepseekServer(ctx context.Context, registry *handler.HandlerRegistry, config *Config) error {
+// setupDeepseekServer creates a DeepSeek server and registers it with the MCP server
+func setupDeepseekServer(ctx context.Context, srv *StdioServer, config *Config) error {
 	loggerValue := ctx.Value(loggerKey)
 	logger, ok := loggerValue.(Logger)
 	if !ok {
@@ -105,14 +95,47 @@ func setupDeepseekServer(ctx context.Context, registry *handler.HandlerRegistry,
 	// Wrap the server with logger middleware
 	handlerWithLogger := NewLoggerMiddleware(deepseekServer, logger)
 
-	// Register the wrapped server
-	registry.RegisterToolHandler(handlerWithLogger)
+	// Register the wrapped server; progress notifications go through the MCP server's writer
+	deepseekServer.progress = srv
+	srv.Handle(handlerWithLogger)
 	logger.Info("Registered DeepSeek server in normal mode with model: %s", config.DeepseekModel)
 
 	// Log file handling configuration
 	logger.Info("File handling: max size %s, allowed types: %v",
 		humanReadableSize(config.MaxFileSize),
 		config.AllowedFileTypes)
+	logger.Info("Per-request file budget: %d files, %s, %d tokens",
+		config.MaxFilesPerRequest, humanReadableSize(config.MaxTotalFileSize), config.MaxRequestTokens)
+
+	// Unless DEEPSEEK_WORKSPACE_ROOTS is set, the client's MCP roots define the workspace
+	srv.HandleRoots(func(paths []string) {
+		replaced, err := deepseekServer.workspace.SetClientRoots(paths)
+		if err != nil {
+			logger.Warn("Ignoring the client's roots: %v", err)
+		} else if replaced {
+			logger.Info("Workspace roots from the client: %v", deepseekServer.workspace.Roots())
+		}
+	})
+
+	// Log workspace configuration
+	if roots := deepseekServer.workspace.Roots(); len(roots) > 0 {
+		logger.Info("Workspace roots: %v (agent mode: max %d steps, %d tokens)",
+			roots, config.AgentMaxSteps, config.AgentMaxTokens)
+	} else {
+		logger.Warn("No workspace roots: the working directory is / or the home directory, so file access waits for the client's roots or DEEPSEEK_WORKSPACE_ROOTS")
+	}
+
+	// Log caching configuration
+	if config.EnableCaching {
+		cacheLocation := "memory only"
+		if config.CacheDir != "" {
+			cacheLocation = config.CacheDir
+		}
+		logger.Info("Response caching enabled: default TTL %v, max %d entries / %s, storage: %s",
+			config.DefaultCacheTTL, config.CacheMaxEntries, humanReadableSize(config.CacheMaxSize), cacheLocation)
+	} else {
+		logger.Info("Response caching disabled")
+	}
 
 	// Log a truncated version of the system prompt for security/brevity
 	promptPreview := config.DeepseekSystemPrompt
@@ -160,21 +183,14 @@ func handleStartupError(ctx context.Context, err error) {
 		config:       config,
 	}
 
-	// Set up registry with error server
-	// NewHandlerRegistry is a constructor that doesn't return an error
-	registry := handler.NewHandlerRegistry()
+	// Set up the MCP server with the error server
+	srv := NewStdioServer("deepseek", "1.0.0", os.Stdin, os.Stdout, logger)
 	errorServerWithLogger := NewLoggerMiddleware(errorServer, logger)
-	registry.RegisterToolHandler(errorServerWithLogger)
+	srv.Handle(errorServerWithLogger)
 
 	// Start server in degraded mode
 	logger.Info("Starting DeepSeek MCP server in degraded mode")
-	srv := server.New(server.Options{
-		Name:     "deepseek",
-		Version:  "1.0.0",
-		Registry: registry,
-	})
-
-	if err := srv.Run(); err != nil {
+	if err := srv.Run(ctx); err != nil {
 		logger.Error("Server error in degraded mode: %v", err)
 		os.Exit(1)
 	}
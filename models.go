@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
 )
 
 // DeepseekModelInfo holds information about a DeepSeek model
@@ -114,3 +116,71 @@ func (s *DeepseekServer) GetAvailableDeepseekModels() []DeepseekModelInfo {
 	// Otherwise, return fallback hardcoded models
 	return getFallbackDeepseekModels()
 }
+
+// ModelCapabilities describes which request features a DeepSeek model accepts
+type ModelCapabilities struct {
+	// Reasoning models return their chain of thought in reasoning_content
+	Reasoning bool
+	// Sampling reports whether temperature and related sampling parameters are honoured
+	Sampling bool
+	// JSONMode reports whether structured JSON output is supported
+	JSONMode bool
+	// Tools reports whether function calling is supported
+	Tools bool
+	// ContextWindow is the maximum number of tokens in a request and its answer together
+	ContextWindow int
+	// DefaultMaxOutput is the number of answer tokens generated when max_tokens is not set
+	DefaultMaxOutput int
+}
+
+// GetModelCapabilities returns the capabilities of a model based on its ID
+func GetModelCapabilities(modelID string) ModelCapabilities {
+	if isReasonerModel(modelID) {
+		return ModelCapabilities{
+			Reasoning:        true,
+			ContextWindow:    131072,
+			DefaultMaxOutput: 32768,
+		}
+	}
+	return ModelCapabilities{
+		Sampling:         true,
+		JSONMode:         true,
+		Tools:            true,
+		ContextWindow:    131072,
+		DefaultMaxOutput: 4096,
+	}
+}
+
+// isReasonerModel reports whether the model is a DeepSeek reasoning model such as deepseek-reasoner
+func isReasonerModel(modelID string) bool {
+	return strings.Contains(strings.ToLower(modelID), "reasoner")
+}
+
+// ModelPricing is the price of a model in US dollars per million tokens
+type ModelPricing struct {
+	InputCacheHit  float64
+	InputCacheMiss float64
+	Output         float64
+}
+
+// modelPricing holds the list prices of the models DeepSeek publishes prices for
+var modelPricing = map[string]ModelPricing{
+	deepseek.DeepSeekChat:     {InputCacheHit: 0.028, InputCacheMiss: 0.28, Output: 0.42},
+	deepseek.DeepSeekReasoner: {InputCacheHit: 0.028, InputCacheMiss: 0.28, Output: 0.42},
+}
+
+// GetModelPricing returns the list price of a model, and false when the price of the model is unknown
+func GetModelPricing(modelID string) (ModelPricing, bool) {
+	pricing, ok := modelPricing[modelID]
+	return pricing, ok
+}
+
+// Cost returns the price in US dollars of a request with the given usage. Prompt tokens without
+// a cache hit/miss breakdown are billed as cache misses.
+func (p ModelPricing) Cost(usage deepseek.Usage) float64 {
+	hit, miss := usage.PromptCacheHitTokens, usage.PromptCacheMissTokens
+	if hit+miss == 0 {
+		miss = usage.PromptTokens
+	}
+	return (float64(hit)*p.InputCacheHit + float64(miss)*p.InputCacheMiss + float64(usage.CompletionTokens)*p.Output) / 1e6
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// OutlineSymbol is a top-level declaration found in a source file
+type OutlineSymbol struct {
+	Name      string
+	Kind      string
+	StartLine int
+	EndLine   int // 0 when the end of the declaration is unknown
+}
+
+// heuristicSymbolPattern matches common declaration keywords in languages without a dedicated parser
+var heuristicSymbolPattern = regexp.MustCompile(
+	`^\s*(?:export\s+)?(?:pub(?:\([^)]*\))?\s+)?(?:public\s+|private\s+|protected\s+|static\s+|async\s+|abstract\s+)*` +
+		`(func|function|def|class|interface|struct|enum|trait|impl|fn|type|module|object)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+
+// fileOutline returns the top-level symbols of a source file. Go files are parsed with go/parser;
+// other languages fall back to a keyword-based heuristic.
+func fileOutline(path string, content []byte) []OutlineSymbol {
+	if strings.ToLower(filepath.Ext(path)) == ".go" {
+		if symbols, err := goOutline(path, content); err == nil {
+			return symbols
+		}
+	}
+	return heuristicOutline(content)
+}
+
+// goOutline lists the functions, methods and types declared in a Go file
+func goOutline(path string, content []byte) ([]OutlineSymbol, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []OutlineSymbol
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			kind := "func"
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				kind = "method"
+				name = receiverTypeName(d.Recv.List[0].Type) + "." + name
+			}
+			start := d.Pos()
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			symbols = append(symbols, OutlineSymbol{
+				Name:      name,
+				Kind:      kind,
+				StartLine: fset.Position(start).Line,
+				EndLine:   fset.Position(d.End()).Line,
+			})
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				var name, kind string
+				switch sp := spec.(type) {
+				case *ast.TypeSpec:
+					name, kind = sp.Name.Name, "type"
+				case *ast.ValueSpec:
+					if len(sp.Names) == 0 {
+						continue
+					}
+					name, kind = sp.Names[0].Name, d.Tok.String()
+				default:
+					continue
+				}
+				start, end := spec.Pos(), spec.End()
+				// Single-spec declarations include the keyword and doc comment
+				if len(d.Specs) == 1 {
+					start, end = d.Pos(), d.End()
+					if d.Doc != nil {
+						start = d.Doc.Pos()
+					}
+				}
+				symbols = append(symbols, OutlineSymbol{
+					Name:      name,
+					Kind:      kind,
+					StartLine: fset.Position(start).Line,
+					EndLine:   fset.Position(end).Line,
+				})
+			}
+		}
+	}
+	return symbols, nil
+}
+
+// receiverTypeName returns the type name of a method receiver, without pointer or type parameters
+func receiverTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	default:
+		return fmt.Sprintf("%T", expr)
+	}
+}
+
+// heuristicOutline finds declarations by keyword. The end of each symbol is estimated as the
+// line before the next symbol at the same or lower indentation.
+func heuristicOutline(content []byte) []OutlineSymbol {
+	lines := strings.Split(string(content), "\n")
+
+	var symbols []OutlineSymbol
+	var indents []int
+	for i, line := range lines {
+		match := heuristicSymbolPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		symbols = append(symbols, OutlineSymbol{
+			Name:      match[2],
+			Kind:      match[1],
+			StartLine: i + 1,
+		})
+		indents = append(indents, len(line)-len(strings.TrimLeft(line, " \t")))
+	}
+
+	for i := range symbols {
+		symbols[i].EndLine = len(lines)
+		for j := i + 1; j < len(symbols); j++ {
+			if indents[j] <= indents[i] {
+				symbols[i].EndLine = symbols[j].StartLine - 1
+				break
+			}
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Limits on inflating PDF content streams. A PDF is at most DEEPSEEK_MAX_FILE_SIZE, so the total
+// inflated from one grows with that limit up to maxPDFInflatedSize.
+const (
+	maxPDFStreamSize   = 16 * 1024 * 1024  // Most bytes inflated from one content stream
+	maxPDFInflatedSize = 256 * 1024 * 1024 // Most bytes inflated from all content streams of one PDF
+	maxPDFInflateRatio = 100               // Most bytes inflated from all content streams per byte of PDF
+)
+
+var (
+	// pdfStreamPattern matches the dictionary of a stream object and the start of its data
+	pdfStreamPattern = regexp.MustCompile(`(?s)<<((?:[^<>]|<<(?:[^<>]|<<[^<>]*>>)*>>|<[0-9A-Fa-f\s]*>)*)>>\s*stream\r?\n`)
+
+	// pdfSkippedStreams matches the dictionaries of streams that never hold page text
+	pdfSkippedStreams = regexp.MustCompile(`/Type\s*/(?:XRef|ObjStm|Metadata|XObject)|/Subtype\s*/(?:Image|XML|Type1C|CIDFontType0C|OpenType)|/Length[123]\b`)
+
+	// pdfOtherFilters matches compression filters other than FlateDecode, which are not supported
+	pdfOtherFilters = regexp.MustCompile(`/(?:DCTDecode|JPXDecode|JBIG2Decode|CCITTFaxDecode|LZWDecode|RunLengthDecode|ASCII85Decode|ASCIIHexDecode)`)
+)
+
+// extractPDF returns the text shown by the content streams of a PDF. It handles the common case of
+// uncompressed or Flate-compressed streams drawing text in a standard encoding; scanned pages,
+// encrypted files and fonts with custom encodings yield an error rather than garbled text, and so do
+// files inflating to more than maxPDFInflateRatio times their size.
+func extractPDF(content []byte) (string, string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(content, "\x00\t\r\n "), []byte("%PDF-")) {
+		return "", "", errors.New("missing %PDF- header")
+	}
+	if bytes.Contains(content, []byte("/Encrypt")) {
+		return "", "", errors.New("the PDF is encrypted")
+	}
+
+	budget := min(maxPDFInflateRatio*int64(len(content)), maxPDFInflatedSize)
+	var inflated int64
+
+	var sb strings.Builder
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(content, -1) {
+		dict := content[match[2]:match[3]]
+		if pdfSkippedStreams.Match(dict) || pdfOtherFilters.Match(dict) {
+			continue
+		}
+		start := match[1]
+		end := bytes.Index(content[start:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		data := content[start : start+end]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			// Truncated streams are common; whatever inflated before the error is still used
+			data, _ = io.ReadAll(io.LimitReader(reader, min(maxPDFStreamSize, budget-inflated+1)))
+			reader.Close()
+			if inflated += int64(len(data)); inflated > budget {
+				return "", "", fmt.Errorf("the content streams inflate to more than %s (possible decompression bomb)", humanReadableSize(budget))
+			}
+		}
+		if text := pdfStreamText(data); strings.TrimSpace(text) != "" {
+			sb.WriteString(strings.TrimSpace(text) + "\n\n")
+		}
+	}
+
+	text := strings.TrimSpace(sb.String())
+	if text == "" {
+		return "", "", errors.New("no text found (the PDF may contain only scanned images)")
+	}
+	printable := 0
+	for _, r := range text {
+		if unicode.IsPrint(r) || unicode.IsSpace(r) {
+			printable++
+		}
+	}
+	if printable < len([]rune(text))*9/10 {
+		return "", "", errors.New("the text uses a font encoding that cannot be decoded")
+	}
+	return text + "\n", "text", nil
+}
+
+// pdfStreamText interprets the text operators of a content stream. Operands are collected until an
+// operator is read; text showing operators append their strings and positioning operators that move
+// to another line start a new line.
+func pdfStreamText(data []byte) string {
+	var sb strings.Builder
+	var operands []string
+	var lastY float64
+	inText := false
+
+	newline := func() {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	number := func(i int) float64 {
+		if i < 0 || i >= len(operands) {
+			return 0
+		}
+		value, _ := strconv.ParseFloat(operands[i], 64)
+		return value
+	}
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case isPDFSpace(c):
+			i++
+		case c == '(':
+			text, next := pdfLiteralString(data, i)
+			operands = append(operands, "("+text)
+			i = next
+		case c == '<' && i+1 < len(data) && data[i+1] == '<':
+			// Inline dictionaries, e.g. marked-content properties, carry no text
+			depth := 0
+			for ; i < len(data); i++ {
+				if data[i] == '<' && i+1 < len(data) && data[i+1] == '<' {
+					depth++
+					i++
+				} else if data[i] == '>' && i+1 < len(data) && data[i+1] == '>' {
+					depth--
+					i++
+					if depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+		case c == '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return sb.String()
+			}
+			operands = append(operands, "("+pdfHexString(data[i+1:i+end]))
+			i += end + 1
+		case c == '[':
+			// Arrays only appear as TJ operands: strings and kerning adjustments
+			var parts strings.Builder
+			i++
+			for i < len(data) && data[i] != ']' {
+				switch {
+				case data[i] == '(':
+					text, next := pdfLiteralString(data, i)
+					parts.WriteString(text)
+					i = next
+				case data[i] == '<':
+					end := bytes.IndexByte(data[i:], '>')
+					if end < 0 {
+						return sb.String()
+					}
+					parts.WriteString(pdfHexString(data[i+1 : i+end]))
+					i += end + 1
+				case data[i] == '-' || data[i] == '.' || (data[i] >= '0' && data[i] <= '9'):
+					start := i
+					for i < len(data) && (data[i] == '-' || data[i] == '.' || (data[i] >= '0' && data[i] <= '9')) {
+						i++
+					}
+					// Large negative adjustments move right by about a word space
+					if value, err := strconv.ParseFloat(string(data[start:i]), 64); err == nil && value < -200 {
+						parts.WriteString(" ")
+					}
+				default:
+					i++
+				}
+			}
+			i++
+			operands = append(operands, "("+parts.String())
+		default:
+			start := i
+			for i < len(data) && !isPDFSpace(data[i]) && !strings.ContainsRune("()<>[]{}/%", rune(data[i])) {
+				i++
+			}
+			if i == start {
+				// A name starts with '/'; its characters are read as the token
+				i++
+				for i < len(data) && !isPDFSpace(data[i]) && !strings.ContainsRune("()<>[]{}/%", rune(data[i])) {
+					i++
+				}
+			}
+			token := string(data[start:i])
+			if token == "" || token[0] == '/' || token[0] == '-' || token[0] == '.' || (token[0] >= '0' && token[0] <= '9') {
+				operands = append(operands, token)
+				continue
+			}
+
+			last := ""
+			if len(operands) > 0 && strings.HasPrefix(operands[len(operands)-1], "(") {
+				last = operands[len(operands)-1][1:]
+			}
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				newline()
+			case "Tj", "TJ":
+				if inText {
+					sb.WriteString(last)
+				}
+			case "'", "\"":
+				if inText {
+					newline()
+					sb.WriteString(last)
+				}
+			case "T*":
+				newline()
+			case "Td", "TD":
+				if number(len(operands)-1) != 0 {
+					newline()
+				} else if number(len(operands)-2) > 0 && !strings.HasSuffix(sb.String(), " ") {
+					sb.WriteString(" ")
+				}
+			case "Tm":
+				if y := number(len(operands) - 1); y != lastY {
+					newline()
+					lastY = y
+				}
+			}
+			operands = operands[:0]
+		}
+	}
+	return sb.String()
+}
+
+// isPDFSpace reports whether c is a PDF whitespace character
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+// pdfLiteralString decodes the literal string starting with the '(' at data[start], returning the
+// text and the offset after the closing parenthesis. Bytes are read as Latin-1.
+func pdfLiteralString(data []byte, start int) (string, int) {
+	var raw []byte
+	depth := 0
+	i := start
+	for ; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				raw = append(raw, '\n')
+			case 'r':
+				raw = append(raw, '\r')
+			case 't':
+				raw = append(raw, '\t')
+			case 'b':
+				raw = append(raw, '\b')
+			case 'f':
+				raw = append(raw, '\f')
+			case '\r', '\n':
+				// A backslash at the end of a line continues the string on the next one
+				if e == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					j := i
+					for ; j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7'; j++ {
+						value = value*8 + int(data[j]-'0')
+					}
+					raw = append(raw, byte(value))
+					i = j - 1
+				} else {
+					raw = append(raw, e)
+				}
+			}
+		case c == '(':
+			depth++
+			if depth > 1 {
+				raw = append(raw, c)
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return decodePDFText(raw), i + 1
+			}
+			raw = append(raw, c)
+		default:
+			raw = append(raw, c)
+		}
+	}
+	return decodePDFText(raw), i
+}
+
+// pdfHexString decodes the digits of a hexadecimal string; an odd final digit is padded with 0
+func pdfHexString(digits []byte) string {
+	var raw []byte
+	var high byte
+	odd := false
+	for _, c := range digits {
+		var value byte
+		switch {
+		case c >= '0' && c <= '9':
+			value = c - '0'
+		case c >= 'a' && c <= 'f':
+			value = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			value = c - 'A' + 10
+		default:
+			continue
+		}
+		if odd {
+			raw = append(raw, high<<4|value)
+		} else {
+			high = value
+		}
+		odd = !odd
+	}
+	if odd {
+		raw = append(raw, high<<4)
+	}
+	return decodePDFText(raw)
+}
+
+// decodePDFText decodes string bytes as UTF-16BE when they start with a byte order mark and as
+// Latin-1 otherwise, which matches the standard encodings for the ASCII range
+func decodePDFText(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// withAssistantPrefix appends an assistant message that the model must continue from.
+// DeepSeek only honours such prefix messages on the beta endpoint.
+func withAssistantPrefix(messages []deepseek.ChatCompletionMessage, prefix string) []deepseek.ChatCompletionMessage {
+	return append(messages, deepseek.ChatCompletionMessage{
+		Role:    deepseek.ChatMessageRoleAssistant,
+		Content: prefix,
+		Prefix:  true,
+	})
+}
+
+// hasAssistantPrefix reports whether the request ends with an assistant prefix message
+func hasAssistantPrefix(request *deepseek.ChatCompletionRequest) bool {
+	n := len(request.Messages)
+	return n > 0 && request.Messages[n-1].Role == deepseek.ChatMessageRoleAssistant && request.Messages[n-1].Prefix
+}
+
+// clientFor returns the API client that can serve the request: prefix completion requires the beta endpoint
+func (s *DeepseekServer) clientFor(request *deepseek.ChatCompletionRequest) *deepseek.Client {
+	if hasAssistantPrefix(request) {
+		return s.betaClient
+	}
+	return s.client
+}
+
+// prependAssistantPrefix makes the response content read as one coherent answer, since the API
+// returns only the continuation of the prefix
+func prependAssistantPrefix(resp *deepseek.ChatCompletionResponse, prefix string) {
+	if len(resp.Choices) > 0 {
+		resp.Choices[0].Message.Content = prefix + resp.Choices[0].Message.Content
+	}
+}
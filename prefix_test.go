@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+func TestHasAssistantPrefix(t *testing.T) {
+	user := deepseek.ChatCompletionMessage{Role: deepseek.ChatMessageRoleUser, Content: "q"}
+	tests := []struct {
+		name     string
+		messages []deepseek.ChatCompletionMessage
+		want     bool
+	}{
+		{"no messages", nil, false},
+		{"user message last", []deepseek.ChatCompletionMessage{user}, false},
+		{"assistant message without prefix", []deepseek.ChatCompletionMessage{user, {Role: deepseek.ChatMessageRoleAssistant, Content: "a"}}, false},
+		{"assistant prefix", withAssistantPrefix([]deepseek.ChatCompletionMessage{user}, "{"), true},
+		{"prefix before the last message", append(withAssistantPrefix(nil, "{"), user), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasAssistantPrefix(&deepseek.ChatCompletionRequest{Messages: tt.messages}); got != tt.want {
+				t.Errorf("hasAssistantPrefix = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssistantPrefixCompletion(t *testing.T) {
+	const prefix = "```json\n{"
+	const continuation = "\"ok\": true}\n```"
+
+	for _, streaming := range []bool{false, true} {
+		name := "non-streaming"
+		if streaming {
+			name = "streaming"
+		}
+		t.Run(name, func(t *testing.T) {
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("prefix completion sent to the regular endpoint %s", r.URL.Path)
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			defer api.Close()
+
+			var last deepseek.ChatCompletionMessage
+			beta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var request struct {
+					Stream   bool                             `json:"stream"`
+					Messages []deepseek.ChatCompletionMessage `json:"messages"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Messages) == 0 {
+					t.Errorf("invalid request: %v", err)
+				} else {
+					last = request.Messages[len(request.Messages)-1]
+				}
+				if request.Stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.Write([]byte("data: {\"id\":\"s1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":" + jsonString(continuation) + "}}]}\n\n"))
+					w.Write([]byte("data: [DONE]\n\n"))
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":` + jsonString(continuation) + `}}]}`))
+			}))
+			defer beta.Close()
+
+			s := &DeepseekServer{
+				config:     &Config{EnableStreaming: streaming},
+				client:     deepseek.NewClient("key", api.URL+"/"),
+				betaClient: deepseek.NewClient("key", beta.URL+"/"),
+			}
+			ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+			messages := []deepseek.ChatCompletionMessage{{Role: deepseek.ChatMessageRoleUser, Content: "Return a JSON status"}}
+			request := &deepseek.ChatCompletionRequest{Model: "deepseek-chat", Messages: withAssistantPrefix(messages, prefix)}
+
+			response, err := s.createChatCompletion(ctx, request, nil)
+			if err != nil {
+				t.Fatalf("createChatCompletion: %v", err)
+			}
+			if last.Role != deepseek.ChatMessageRoleAssistant || last.Content != prefix || !last.Prefix {
+				t.Errorf("last message sent = %+v, want the assistant prefix", last)
+			}
+			prependAssistantPrefix(response, prefix)
+			if got := response.Choices[0].Message.Content; got != prefix+continuation {
+				t.Errorf("answer = %q, want %q", got, prefix+continuation)
+			}
+		})
+	}
+}
+
+// jsonString encodes s as a JSON string literal
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
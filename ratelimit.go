@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// rateLimitWindow is the period over which requests and tokens per minute are counted
+const rateLimitWindow = time.Minute
+
+// Errors returned when a request cannot be sent within the rate limits
+var (
+	ErrQueueFull    = errors.New("rate limit queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting in the rate limit queue")
+)
+
+// callKey is the context key under which the ID of a tool call is stored
+const callKey contextKey = "call"
+
+// nextCallID numbers tool calls so that the rate limiter can share capacity fairly between them
+var nextCallID atomic.Uint64
+
+// withCallID returns a context that identifies a new tool call to the rate limiter
+func withCallID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, callKey, nextCallID.Add(1))
+}
+
+// callIDFromContext returns the ID of the tool call a request belongs to, or 0 if unknown
+func callIDFromContext(ctx context.Context) uint64 {
+	id, _ := ctx.Value(callKey).(uint64)
+	return id
+}
+
+// RateGrant is the capacity reserved for one API request
+type RateGrant struct {
+	at     time.Time
+	tokens int
+}
+
+// rateWaiter is a request waiting in the queue
+type rateWaiter struct {
+	call    uint64
+	tokens  int
+	ready   chan struct{}
+	grant   *RateGrant
+	granted bool
+}
+
+// RateLimiterStats is a snapshot of the rate limiter's usage and queue
+type RateLimiterStats struct {
+	WindowRequests int   // Requests sent within the last minute
+	WindowTokens   int   // Tokens of the requests sent within the last minute
+	Queued         int   // Requests waiting for capacity
+	Granted        int64 // Requests let through since startup
+	Delayed        int64 // Requests that had to wait since startup
+	Rejected       int64 // Requests refused because the queue was full or the wait too long
+	LongestWait    time.Duration
+}
+
+// RateLimiter keeps requests to the DeepSeek API within requests-per-minute and tokens-per-minute
+// limits. Requests over the limits wait in a bounded queue. When capacity frees up it goes to the
+// tool call that was served least recently, so a call sending many requests, such as
+// deepseek_analyze_large, does not hold up the others.
+type RateLimiter struct {
+	mu           sync.Mutex
+	rpm          int // 0 means unlimited
+	tpm          int // 0 means unlimited
+	queueSize    int
+	queueTimeout time.Duration
+	window       time.Duration
+
+	grants     []*RateGrant
+	waiters    []*rateWaiter
+	lastServed map[uint64]time.Time
+	timer      *time.Timer
+
+	granted     int64
+	delayed     int64
+	rejected    int64
+	longestWait time.Duration
+}
+
+// NewRateLimiter creates a rate limiter from the configured limits
+func NewRateLimiter(config *Config) *RateLimiter {
+	return &RateLimiter{
+		rpm:          config.RateLimitRPM,
+		tpm:          config.RateLimitTPM,
+		queueSize:    config.QueueSize,
+		queueTimeout: config.QueueTimeout,
+		window:       rateLimitWindow,
+		lastServed:   make(map[uint64]time.Time),
+	}
+}
+
+// Enabled reports whether any limit is set
+func (l *RateLimiter) Enabled() bool {
+	return l != nil && (l.rpm > 0 || l.tpm > 0)
+}
+
+// Acquire reserves capacity for a request of the given estimated tokens, waiting in the queue if
+// the limits are reached. It returns the grant to pass to Settle and the time spent
+// waiting. The request's tool call is taken from ctx.
+func (l *RateLimiter) Acquire(ctx context.Context, tokens int) (*RateGrant, time.Duration, error) {
+	if !l.Enabled() {
+		return nil, 0, nil
+	}
+	if l.tpm > 0 && tokens > l.tpm {
+		return nil, 0, fmt.Errorf("the request needs about %d tokens, more than DEEPSEEK_RATE_LIMIT_TPM allows in a minute (%d)", tokens, l.tpm)
+	}
+
+	l.mu.Lock()
+	waiter := &rateWaiter{call: callIDFromContext(ctx), tokens: tokens, ready: make(chan struct{})}
+	// A request that can be sent right away never needs a place in the queue
+	if len(l.waiters) >= l.queueSize && (len(l.waiters) > 0 || !l.fits(tokens, time.Now())) {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, 0, fmt.Errorf("%w: %d requests are already waiting (DEEPSEEK_QUEUE_SIZE); try again later", ErrQueueFull, len(l.waiters))
+	}
+	l.waiters = append(l.waiters, waiter)
+	l.dispatch()
+	if waiter.granted {
+		l.mu.Unlock()
+		return waiter.grant, 0, nil
+	}
+	l.mu.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-waiter.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = fmt.Errorf("%w after %s (DEEPSEEK_QUEUE_TIMEOUT); the rate limits are saturated, try again later", ErrQueueTimeout, l.queueTimeout)
+	}
+	wait := time.Since(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !waiter.granted {
+		// Leaving the queue may let the requests behind this one through
+		l.removeWaiter(waiter)
+		if errors.Is(err, ErrQueueTimeout) {
+			l.rejected++
+		}
+		l.dispatch()
+		return nil, wait, err
+	}
+	if wait > 0 {
+		l.delayed++
+		l.longestWait = max(l.longestWait, wait)
+	}
+	return waiter.grant, wait, nil
+}
+
+// Settle replaces the estimated tokens of a sent request with the number it actually used
+func (l *RateLimiter) Settle(grant *RateGrant, tokens int) {
+	if grant == nil || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	grant.tokens = tokens
+	l.dispatch()
+}
+
+// Release returns the capacity of a grant whose request was never sent
+func (l *RateLimiter) Release(grant *RateGrant) {
+	if grant == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, g := range l.grants {
+		if g == grant {
+			l.grants = append(l.grants[:i], l.grants[i+1:]...)
+			l.granted--
+			break
+		}
+	}
+	l.dispatch()
+}
+
+// Stats returns a snapshot of the limiter's usage and queue
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire(time.Now())
+	stats := RateLimiterStats{
+		WindowRequests: len(l.grants),
+		Queued:         len(l.waiters),
+		Granted:        l.granted,
+		Delayed:        l.delayed,
+		Rejected:       l.rejected,
+		LongestWait:    l.longestWait,
+	}
+	for _, grant := range l.grants {
+		stats.WindowTokens += grant.tokens
+	}
+	return stats
+}
+
+// dispatch grants capacity to waiting requests while it lasts and schedules itself for when
+// more frees up. It must be called with the lock held whenever the grants or the queue change.
+func (l *RateLimiter) dispatch() {
+	now := time.Now()
+	l.expire(now)
+	for len(l.waiters) > 0 {
+		next := l.nextWaiter()
+		if !l.fits(next.tokens, now) {
+			l.schedule(l.availableAt(next.tokens).Sub(now))
+			return
+		}
+		next.grant = &RateGrant{at: now, tokens: next.tokens}
+		next.granted = true
+		l.grants = append(l.grants, next.grant)
+		l.lastServed[next.call] = now
+		l.granted++
+		l.removeWaiter(next)
+		close(next.ready)
+	}
+}
+
+// nextWaiter picks the oldest request of the tool call that was served least recently
+func (l *RateLimiter) nextWaiter() *rateWaiter {
+	next := l.waiters[0]
+	for _, waiter := range l.waiters[1:] {
+		if l.lastServed[waiter.call].Before(l.lastServed[next.call]) {
+			next = waiter
+		}
+	}
+	return next
+}
+
+// fits reports whether a request of the given tokens can be sent now without exceeding the limits
+func (l *RateLimiter) fits(tokens int, now time.Time) bool {
+	l.expire(now)
+	if l.rpm > 0 && len(l.grants)+1 > l.rpm {
+		return false
+	}
+	if l.tpm > 0 {
+		used := 0
+		for _, grant := range l.grants {
+			used += grant.tokens
+		}
+		if used+tokens > l.tpm {
+			return false
+		}
+	}
+	return true
+}
+
+// availableAt returns when enough grants will have expired for a request of the given tokens
+func (l *RateLimiter) availableAt(tokens int) time.Time {
+	var at time.Time
+	if l.rpm > 0 && len(l.grants) >= l.rpm {
+		at = l.grants[len(l.grants)-l.rpm].at.Add(l.window)
+	}
+	if l.tpm > 0 {
+		used := 0
+		for _, grant := range l.grants {
+			used += grant.tokens
+		}
+		for _, grant := range l.grants {
+			if used+tokens <= l.tpm {
+				break
+			}
+			used -= grant.tokens
+			if expiry := grant.at.Add(l.window); expiry.After(at) {
+				at = expiry
+			}
+		}
+	}
+	return at
+}
+
+// schedule runs dispatch again after d
+func (l *RateLimiter) schedule(d time.Duration) {
+	d = max(d, time.Millisecond)
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.timer = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.dispatch()
+	})
+}
+
+// expire drops grants older than the window and forgets tool calls that have no requests in it
+func (l *RateLimiter) expire(now time.Time) {
+	cutoff := now.Add(-l.window)
+	start := 0
+	for start < len(l.grants) && !l.grants[start].at.After(cutoff) {
+		start++
+	}
+	l.grants = l.grants[start:]
+
+	for call, served := range l.lastServed {
+		if served.Before(cutoff) && !l.hasWaiter(call) {
+			delete(l.lastServed, call)
+		}
+	}
+}
+
+// hasWaiter reports whether a tool call has a request in the queue
+func (l *RateLimiter) hasWaiter(call uint64) bool {
+	for _, waiter := range l.waiters {
+		if waiter.call == call {
+			return true
+		}
+	}
+	return false
+}
+
+// removeWaiter takes a request out of the queue
+func (l *RateLimiter) removeWaiter(waiter *rateWaiter) {
+	for i, w := range l.waiters {
+		if w == waiter {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// estimateRequestTokens estimates the prompt tokens of an assembled chat completion request
+func estimateRequestTokens(request *deepseek.ChatCompletionRequest) int {
+	tokens := 0
+	for _, message := range request.Messages {
+		tokens += deepseek.EstimateTokenCount(message.Content).EstimatedTokens + messageOverheadTokens
+	}
+	return tokens
+}
+
+// formatRateLimiterStats renders the rate limiter's usage and queue as markdown
+func formatRateLimiterStats(stats RateLimiterStats, config *Config) string {
+	var sb strings.Builder
+	sb.WriteString("## Rate Limits\n\n")
+	if config.RateLimitRPM <= 0 && config.RateLimitTPM <= 0 {
+		sb.WriteString("**Limits:** none (set DEEPSEEK_RATE_LIMIT_RPM or DEEPSEEK_RATE_LIMIT_TPM)\n")
+		return sb.String()
+	}
+
+	limit := func(value int) string {
+		if value <= 0 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%d", value)
+	}
+	sb.WriteString(fmt.Sprintf("**Limits:** %s requests and %s tokens per minute | **Queue:** %d of %d, waiting at most %s\n\n",
+		limit(config.RateLimitRPM), limit(config.RateLimitTPM), stats.Queued, config.QueueSize, config.QueueTimeout))
+
+	sb.WriteString("| Counter | Value |\n")
+	sb.WriteString("|---------|-------|\n")
+	sb.WriteString(fmt.Sprintf("| Requests in the last minute | %d |\n", stats.WindowRequests))
+	sb.WriteString(fmt.Sprintf("| Tokens in the last minute | %d |\n", stats.WindowTokens))
+	sb.WriteString(fmt.Sprintf("| Requests sent since startup | %d |\n", stats.Granted))
+	sb.WriteString(fmt.Sprintf("| Requests delayed | %d |\n", stats.Delayed))
+	sb.WriteString(fmt.Sprintf("| Requests rejected | %d |\n", stats.Rejected))
+	sb.WriteString(fmt.Sprintf("| Longest wait | %s |\n", stats.LongestWait.Round(time.Millisecond)))
+	return sb.String()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRateLimiter creates a limiter whose window is short enough for tests
+func testRateLimiter(config *Config, window time.Duration) *RateLimiter {
+	l := NewRateLimiter(config)
+	l.window = window
+	return l
+}
+
+// waitQueued waits until n requests are waiting in the limiter's queue
+func waitQueued(t *testing.T, l *RateLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue length %d, want %d", l.Stats().Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateLimiterFairness(t *testing.T) {
+	l := testRateLimiter(&Config{RateLimitRPM: 1, QueueSize: 10}, 40*time.Millisecond)
+	busy, quiet := withCallID(context.Background()), withCallID(context.Background())
+
+	if _, wait, err := l.Acquire(busy, 1); err != nil || wait != 0 {
+		t.Fatalf("first request: wait %v, error %v", wait, err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(ctx context.Context, name string, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := l.Acquire(ctx, 1); err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}()
+		waitQueued(t, l, queued)
+	}
+	// The busy call queues two more requests before the quiet call queues its first
+	enqueue(busy, "busy", 1)
+	enqueue(busy, "busy", 2)
+	enqueue(quiet, "quiet", 3)
+	wg.Wait()
+
+	if got := strings.Join(order, ","); got != "quiet,busy,busy" {
+		t.Errorf("grant order = %s, want the quiet call served first", got)
+	}
+	if stats := l.Stats(); stats.Granted != 4 || stats.Delayed != 3 {
+		t.Errorf("stats = %+v, want 4 granted and 3 delayed", stats)
+	}
+}
+
+func TestRateLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		setup   func(l *RateLimiter)
+		ctx     func() (context.Context, context.CancelFunc)
+		tokens  int
+		wantErr error
+		wantAny bool // An error other than the sentinels is expected
+	}{
+		{name: "disabled", config: Config{}, tokens: 1 << 30},
+		{name: "within limits", config: Config{RateLimitRPM: 2, RateLimitTPM: 100, QueueSize: 1}, tokens: 50},
+		{name: "larger than the token limit", config: Config{RateLimitTPM: 100, QueueSize: 1}, tokens: 101, wantAny: true},
+		{
+			name:   "queue full",
+			config: Config{RateLimitRPM: 1, QueueSize: 0},
+			setup: func(l *RateLimiter) {
+				l.Acquire(context.Background(), 1)
+			},
+			tokens:  1,
+			wantErr: ErrQueueFull,
+		},
+		{
+			name:   "queue timeout",
+			config: Config{RateLimitRPM: 1, QueueSize: 1, QueueTimeout: 20 * time.Millisecond},
+			setup: func(l *RateLimiter) {
+				l.Acquire(context.Background(), 1)
+			},
+			tokens:  1,
+			wantErr: ErrQueueTimeout,
+		},
+		{
+			name:   "cancelled while queued",
+			config: Config{RateLimitRPM: 1, QueueSize: 1},
+			setup: func(l *RateLimiter) {
+				l.Acquire(context.Background(), 1)
+			},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			tokens:  1,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:   "token limit reached",
+			config: Config{RateLimitTPM: 100, QueueSize: 1, QueueTimeout: 20 * time.Millisecond},
+			setup: func(l *RateLimiter) {
+				l.Acquire(context.Background(), 60)
+			},
+			tokens:  60,
+			wantErr: ErrQueueTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			l := testRateLimiter(&config, time.Minute)
+			if tt.setup != nil {
+				tt.setup(l)
+			}
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			_, _, err := l.Acquire(ctx, tt.tokens)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Acquire error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantAny:
+				if err == nil {
+					t.Fatal("Acquire succeeded, want an error")
+				}
+			case err != nil:
+				t.Fatalf("Acquire: %v", err)
+			}
+			if queued := l.Stats().Queued; queued != 0 {
+				t.Errorf("%d requests left in the queue", queued)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSettleAndRelease(t *testing.T) {
+	l := testRateLimiter(&Config{RateLimitRPM: 2, RateLimitTPM: 100, QueueSize: 1, QueueTimeout: 20 * time.Millisecond}, time.Minute)
+	ctx := context.Background()
+
+	grant, _, err := l.Acquire(ctx, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The request used far fewer tokens than estimated, which frees capacity
+	l.Settle(grant, 10)
+	if _, wait, err := l.Acquire(ctx, 80); err != nil || wait != 0 {
+		t.Fatalf("after Settle: wait %v, error %v", wait, err)
+	}
+	if stats := l.Stats(); stats.WindowRequests != 2 || stats.WindowTokens != 90 {
+		t.Errorf("stats = %+v, want 2 requests and 90 tokens", stats)
+	}
+
+	// The request limit is reached until a grant is released
+	if _, _, err := l.Acquire(ctx, 1); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("Acquire over the request limit = %v, want %v", err, ErrQueueTimeout)
+	}
+	l.Release(grant)
+	if _, wait, err := l.Acquire(ctx, 1); err != nil || wait != 0 {
+		t.Fatalf("after Release: wait %v, error %v", wait, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Values accepted by the include_reasoning argument of deepseek_ask
+const (
+	reasoningNone    = "none"
+	reasoningSummary = "summary"
+	reasoningFull    = "full"
+)
+
+// maxReasoningSummaryLength caps the size of a reasoning summary in characters
+const maxReasoningSummaryLength = 1500
+
+// parseReasoningMode converts the include_reasoning argument into one of the reasoning modes.
+// Booleans are accepted for convenience: true means the full reasoning.
+func parseReasoningMode(raw interface{}) (string, error) {
+	switch value := raw.(type) {
+	case nil:
+		return reasoningNone, nil
+	case bool:
+		if value {
+			return reasoningFull, nil
+		}
+		return reasoningNone, nil
+	case string:
+		switch strings.ToLower(value) {
+		case "", reasoningNone:
+			return reasoningNone, nil
+		case reasoningSummary:
+			return reasoningSummary, nil
+		case reasoningFull:
+			return reasoningFull, nil
+		}
+	}
+	return "", fmt.Errorf("include_reasoning must be one of %q, %q or %q", reasoningNone, reasoningSummary, reasoningFull)
+}
+
+// buildChatRequest creates a chat completion request tailored to the capabilities of the model.
+// It returns notes describing any requested options that the model does not support.
+func buildChatRequest(model string, messages []deepseek.ChatCompletionMessage, temperature float32, jsonMode bool) (*deepseek.ChatCompletionRequest, []string) {
+	capabilities := GetModelCapabilities(model)
+	var notes []string
+
+	request := &deepseek.ChatCompletionRequest{
+		Model:    model,
+		Messages: stripReasoningContent(messages),
+	}
+
+	if capabilities.Sampling {
+		request.Temperature = temperature
+	}
+
+	if jsonMode {
+		if capabilities.JSONMode {
+			request.JSONMode = true
+		} else {
+			notes = append(notes, fmt.Sprintf("JSON mode is not supported by %s and was ignored.", model))
+		}
+	}
+
+	return request, notes
+}
+
+// stripReasoningContent returns a copy of the messages without reasoning_content, which the
+// API rejects when earlier reasoning model replies are sent back as history
+func stripReasoningContent(messages []deepseek.ChatCompletionMessage) []deepseek.ChatCompletionMessage {
+	stripped := make([]deepseek.ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		msg.ReasoningContent = ""
+		stripped[i] = msg
+	}
+	return stripped
+}
+
+// formatReasoning renders the model's reasoning according to the requested mode.
+// It returns an empty string when there is nothing to show.
+func formatReasoning(reasoning string, mode string) string {
+	reasoning = strings.TrimSpace(reasoning)
+	if reasoning == "" || mode == reasoningNone {
+		return ""
+	}
+
+	if mode == reasoningFull {
+		return "## Reasoning\n\n" + reasoning
+	}
+
+	estimate := deepseek.EstimateTokenCount(reasoning)
+	return fmt.Sprintf("## Reasoning Summary\n\n*The model reasoned for about %d tokens; showing the opening and concluding steps.*\n\n%s",
+		estimate.EstimatedTokens, summarizeReasoning(reasoning))
+}
+
+// summarizeReasoning keeps the first and last paragraphs of the reasoning, which usually hold
+// the model's reading of the problem and its conclusion, within maxReasoningSummaryLength
+func summarizeReasoning(reasoning string) string {
+	var paragraphs []string
+	for _, paragraph := range strings.Split(reasoning, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+
+	if len(paragraphs) > 3 {
+		paragraphs = []string{paragraphs[0], "[...]", paragraphs[len(paragraphs)-2], paragraphs[len(paragraphs)-1]}
+	}
+
+	summary := strings.Join(paragraphs, "\n\n")
+	if runes := []rune(summary); len(runes) > maxReasoningSummaryLength {
+		half := maxReasoningSummaryLength / 2
+		summary = string(runes[:half]) + "\n\n[...]\n\n" + string(runes[len(runes)-half:])
+	}
+	return summary
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// Redaction keys
+const (
+	redactionKeyFileName  = "redaction.key"
+	redactionKeySize      = 32
+	minRedactionKeyLength = 16
+)
+
+// Redaction modes
+const (
+	redactionOff    = "off"
+	redactionRedact = "redact"
+	redactionStrict = "strict"
+)
+
+// Limits of high-entropy token detection
+const (
+	minEntropyTokenLength = 32
+	minTokenEntropy       = 4.3 // Bits per character; generated keys score above 4.5, identifiers and words below 4
+)
+
+// ErrSecretDetected is returned in strict mode when content contains a likely secret
+var ErrSecretDetected = errors.New("likely secret detected")
+
+// secretPattern detects one kind of secret. When the expression has a capture group only the
+// group is redacted, so that e.g. the key name in `password = "..."` stays readable.
+type secretPattern struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+// builtinSecretPatterns are the secret formats detected without configuration
+var builtinSecretPatterns = []secretPattern{
+	{"private_key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github_token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"slack_token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"google_api_key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"api_key", regexp.MustCompile(`\bsk-(?:[a-z]+-)?[A-Za-z0-9_-]{20,}`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"connection_string", regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@'"]+:([^\s@/'"]+)@`)},
+	{"env_secret", regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+)?[A-Z0-9_]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_KEY|APIKEY|ACCESS_KEY|PRIVATE_KEY|CREDENTIALS?)[A-Z0-9_]*[ \t]*=[ \t]*["']?([^\s"'#]{6,})`)},
+	{"assigned_secret", regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret)["']?[ \t]*(?::=|[:=])[ \t]*["']([^"'\s]{8,})["']`)},
+}
+
+// entropyCandidate matches tokens checked for high entropy
+var entropyCandidate = regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/_=-]{%d,}`, minEntropyTokenLength))
+
+// redactionPlaceholder matches the placeholders written by a Redactor
+var redactionPlaceholder = regexp.MustCompile(`\[REDACTED:[a-z_]+:[0-9a-f]{8}\]`)
+
+// SecretFinding is a likely secret found in a text
+type SecretFinding struct {
+	Kind  string
+	Start int
+	End   int
+}
+
+// Redactor finds likely secrets in outbound content and replaces them with placeholders.
+// Placeholders are derived from the secret with a configured or persisted key, so the same secret
+// gets the same placeholder in every file and request, also after the server restarts.
+type Redactor struct {
+	mode     string
+	patterns []secretPattern
+	allow    []*regexp.Regexp
+	key      []byte
+}
+
+// NewRedactor creates a redactor using the built-in patterns plus deny, ignoring matches of allow.
+// Placeholders are derived from secrets with key.
+func NewRedactor(mode string, key []byte, allow, deny []*regexp.Regexp) (*Redactor, error) {
+	if len(key) == 0 {
+		return nil, errors.New("redaction key is empty")
+	}
+	patterns := append([]secretPattern(nil), builtinSecretPatterns...)
+	for _, pattern := range deny {
+		patterns = append(patterns, secretPattern{kind: "custom", pattern: pattern})
+	}
+	return &Redactor{mode: mode, patterns: patterns, allow: allow, key: key}, nil
+}
+
+// loadRedactionKey returns the key placeholders are derived from: a hash of secret when it is set,
+// otherwise the key stored in path, which is generated the first time. Without either, the key is
+// random, so placeholders change when the server restarts.
+func loadRedactionKey(secret, path string) ([]byte, error) {
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		return sum[:], nil
+	}
+	if path == "" {
+		return newRedactionKey()
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < redactionKeySize {
+			return nil, fmt.Errorf("invalid redaction key in %s: delete it to generate a new one", path)
+		}
+		return key, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read redaction key: %w", err)
+	}
+
+	key, err := newRedactionKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create redaction key directory: %w", err)
+	}
+	// O_EXCL keeps the key of another server started at the same time
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return loadRedactionKey("", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store redaction key: %w", err)
+	}
+	_, err = file.WriteString(hex.EncodeToString(key) + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to store redaction key: %w", err)
+	}
+	return key, nil
+}
+
+// newRedactionKey generates a random redaction key
+func newRedactionKey() ([]byte, error) {
+	key := make([]byte, redactionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate redaction key: %w", err)
+	}
+	return key, nil
+}
+
+// Enabled reports whether content is checked for secrets at all
+func (r *Redactor) Enabled() bool {
+	return r != nil && r.mode != redactionOff
+}
+
+// Strict reports whether requests containing secrets are refused rather than redacted
+func (r *Redactor) Strict() bool {
+	return r != nil && r.mode == redactionStrict
+}
+
+// Scan returns the likely secrets in text, ordered by position and without overlaps
+func (r *Redactor) Scan(text string) []SecretFinding {
+	if !r.Enabled() {
+		return nil
+	}
+
+	var findings []SecretFinding
+	for _, secret := range r.patterns {
+		for _, match := range secret.pattern.FindAllStringSubmatchIndex(text, -1) {
+			start, end := match[0], match[1]
+			if len(match) >= 4 && match[2] >= 0 {
+				start, end = match[2], match[3]
+			}
+			if r.allowed(text[start:end]) || isPlaceholderValue(text[start:end]) {
+				continue
+			}
+			findings = append(findings, SecretFinding{Kind: secret.kind, Start: start, End: end})
+		}
+	}
+	for _, match := range entropyCandidate.FindAllStringIndex(text, -1) {
+		token := text[match[0]:match[1]]
+		if isIntegrityHash(text[:match[0]]) || !looksRandom(token) || r.allowed(token) {
+			continue
+		}
+		findings = append(findings, SecretFinding{Kind: "high_entropy", Start: match[0], End: match[1]})
+	}
+
+	// Keep the first of overlapping findings; patterns come before entropy so they win ties
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	var kept []SecretFinding
+	for _, finding := range findings {
+		if len(kept) > 0 && finding.Start < kept[len(kept)-1].End {
+			continue
+		}
+		kept = append(kept, finding)
+	}
+	return kept
+}
+
+// Redact replaces the likely secrets in text with placeholders and returns the number replaced.
+// A secret spanning several lines is followed by as many newlines, so line numbers stay valid.
+func (r *Redactor) Redact(text string) (string, int) {
+	findings := r.Scan(text)
+	if len(findings) == 0 {
+		return text, 0
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, finding := range findings {
+		secret := text[finding.Start:finding.End]
+		sb.WriteString(text[last:finding.Start])
+		sb.WriteString(r.placeholder(finding.Kind, secret))
+		sb.WriteString(strings.Repeat("\n", strings.Count(secret, "\n")))
+		last = finding.End
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), len(findings)
+}
+
+// Check redacts text, or in strict mode returns an error wrapping ErrSecretDetected naming the
+// kinds of secrets found
+func (r *Redactor) Check(text string) (string, int, error) {
+	if r.Strict() {
+		if findings := r.Scan(text); len(findings) > 0 {
+			return "", len(findings), fmt.Errorf("%w: %d likely secret(s) (%s)", ErrSecretDetected, len(findings), findingKinds(findings))
+		}
+		return text, 0, nil
+	}
+	redacted, count := r.Redact(text)
+	return redacted, count, nil
+}
+
+// placeholder returns the stable placeholder of a secret
+func (r *Redactor) placeholder(kind, secret string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(secret))
+	return fmt.Sprintf("[REDACTED:%s:%s]", kind, hex.EncodeToString(mac.Sum(nil))[:8])
+}
+
+// allowed reports whether a match is a configured false positive
+func (r *Redactor) allowed(match string) bool {
+	for _, pattern := range r.allow {
+		if pattern.MatchString(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactChatRequest returns a copy of the request with secrets redacted from every message,
+// or an error in strict mode. It is applied to every chat request before it is sent.
+func (s *DeepseekServer) redactChatRequest(request *deepseek.ChatCompletionRequest) (*deepseek.ChatCompletionRequest, int, error) {
+	if !s.redactor.Enabled() {
+		return request, 0, nil
+	}
+
+	redacted := *request
+	redacted.Messages = make([]deepseek.ChatCompletionMessage, len(request.Messages))
+	total := 0
+	for i, message := range request.Messages {
+		content, count, err := s.redactor.Check(message.Content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("request refused: the %s message contains %w", message.Role, err)
+		}
+		message.Content = content
+		redacted.Messages[i] = message
+		total += count
+	}
+	return &redacted, total, nil
+}
+
+// secretRefusal returns the error response refusing a request in strict mode when files of the
+// report were rejected for containing secrets, or nil when the request may proceed
+func secretRefusal(report *FileReport) *protocol.CallToolResponse {
+	var paths []string
+	for _, entry := range report.Entries {
+		if entry.Status == fileRejectedSecret {
+			paths = append(paths, entry.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return &protocol.CallToolResponse{
+		IsError: true,
+		Content: []protocol.ToolContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Request refused: %d file(s) contain likely secrets and DEEPSEEK_REDACTION is strict. "+
+				"Remove the secrets, exclude the files, or add an allow pattern to DEEPSEEK_REDACTION_ALLOW if they are false positives.\n\n%s",
+				len(paths), report.Format()),
+		}},
+	}
+}
+
+// countRedactions returns the number of redaction placeholders in content
+func countRedactions(content []byte) int {
+	return len(redactionPlaceholder.FindAllIndex(content, -1))
+}
+
+// findingKinds lists the distinct kinds of findings, e.g. "aws_access_key, private_key"
+func findingKinds(findings []SecretFinding) string {
+	seen := make(map[string]bool)
+	var kinds []string
+	for _, finding := range findings {
+		if !seen[finding.Kind] {
+			seen[finding.Kind] = true
+			kinds = append(kinds, finding.Kind)
+		}
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
+
+// placeholderValue matches assigned values that read like names, e.g. `your_api_key` or `rejected_secret`
+var placeholderValue = regexp.MustCompile(`^[a-z_.-]+$`)
+
+// isPlaceholderValue reports whether an assigned value is a template, reference or name rather than a secret
+func isPlaceholderValue(value string) bool {
+	return strings.HasPrefix(value, "$") || strings.HasPrefix(value, "<") || strings.HasPrefix(value, "{{") ||
+		strings.HasPrefix(value, "%") || strings.HasPrefix(value, "[REDACTED:") || placeholderValue.MatchString(value)
+}
+
+// isIntegrityHash reports whether the text before a token introduces a checksum, as in go.sum or lock files
+func isIntegrityHash(before string) bool {
+	before = before[max(0, len(before)-8):]
+	for _, prefix := range []string{"h1:", "sha1-", "sha256:", "sha256-", "sha384-", "sha512-"} {
+		if strings.HasSuffix(before, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksRandom reports whether a token mixes upper and lower case letters and digits with the
+// character entropy of generated keys. Hex strings such as commit hashes are never reported.
+func looksRandom(token string) bool {
+	var upper, lower, digit bool
+	counts := make(map[rune]int)
+	for _, c := range token {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+		counts[c]++
+	}
+	if !upper || !lower || !digit {
+		return false
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(len(token))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= minTokenEntropy
+}
+
+// parseRedactionMode validates DEEPSEEK_REDACTION, defaulting to redact
+func parseRedactionMode(mode string) (string, error) {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "":
+		return redactionRedact, nil
+	case redactionOff, redactionRedact, redactionStrict:
+		return mode, nil
+	}
+	return "", fmt.Errorf("must be %q, %q or %q", redactionRedact, redactionStrict, redactionOff)
+}
+
+// parseRedactionPatterns compiles a comma-separated list of regular expressions.
+// Commas inside braces or brackets belong to the expression, so `[a-z]{8,}` is one pattern.
+func parseRedactionPatterns(list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	var current strings.Builder
+	depth := 0
+	flush := func() error {
+		expr := strings.TrimSpace(current.String())
+		current.Reset()
+		if expr == "" {
+			return nil
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, pattern)
+		return nil
+	}
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case c == '\\' && i+1 < len(list):
+			current.WriteByte(c)
+			i++
+			c = list[i]
+		case c == '{' || c == '[':
+			depth++
+		case (c == '}' || c == ']') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -16,31 +20,62 @@ type ErrorClassifier func(error) bool
 
 // IsTimeoutError checks if an error is a timeout
 func IsTimeoutError(err error) bool {
-	return err != nil && (strings.Contains(err.Error(), "timeout") ||
-		strings.Contains(err.Error(), "deadline") ||
-		strings.Contains(err.Error(), "timed out"))
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true
+	}
+	// The API library does not always wrap the errors it returns, so fall back to their text
+	errorMessage := err.Error()
+	return strings.Contains(errorMessage, "context deadline exceeded") ||
+		strings.Contains(errorMessage, "Client.Timeout exceeded") ||
+		strings.Contains(errorMessage, "i/o timeout")
 }
 
-// IsNetworkError checks if an error is a likely network error
+// IsNetworkError checks if an error means the connection failed or broke off before a response arrived
 func IsNetworkError(err error) bool {
 	if err == nil {
 		return false
 	}
-
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
 	errorMessage := err.Error()
-	return strings.Contains(errorMessage, "network") ||
-		strings.Contains(errorMessage, "connection") ||
+	return strings.Contains(errorMessage, "connection reset by peer") ||
+		strings.Contains(errorMessage, "connection refused") ||
 		strings.Contains(errorMessage, "broken pipe") ||
-		strings.Contains(errorMessage, "reset") ||
-		strings.Contains(errorMessage, "EOF") ||
-		strings.Contains(errorMessage, "closed")
+		strings.Contains(errorMessage, "unexpected EOF") ||
+		strings.Contains(errorMessage, "server closed idle connection")
 }
 
-// IsRetryableError checks if an error should trigger a retry
+// IsRetryableError checks if an error should trigger a retry. Classified API errors are retried
+// for rate limits, server errors and overload but never for invalid requests, keys or balance,
+// and nothing is retried while the circuit breaker is open.
 func IsRetryableError(err error) bool {
+	var openErr *CircuitOpenError
+	if errors.As(err, &openErr) {
+		return false
+	}
+	var apiErr *DeepseekAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
 	return IsTimeoutError(err) || IsNetworkError(err)
 }
 
+// retryDelay returns the delay an error asks for before the next attempt, e.g. from Retry-After
+func retryDelay(err error) time.Duration {
+	var delayer interface{ RetryDelay() time.Duration }
+	if errors.As(err, &delayer) {
+		return delayer.RetryDelay()
+	}
+	return 0
+}
+
 // RetryWithBackoff retries an operation with exponential backoff
 func RetryWithBackoff(
 	ctx context.Context,
@@ -88,10 +123,19 @@ func RetryWithBackoff(
 			nextBackoff = maxBackoff
 		}
 
+		// Wait at least as long as the server asked, unless that is longer than is worth waiting
+		if delay := retryDelay(err); delay > maxRetryAfter {
+			logger.Error("Not retrying: the server asked to wait %v: %v", delay, err)
+			return err
+		} else if delay > nextBackoff {
+			logger.Info("Waiting %v before retrying, as requested by the server", delay)
+			nextBackoff = delay
+		}
+
 		// Wait for backoff period or until context is cancelled
 		select {
 		case <-ctx.Done():
-			return errors.New("operation cancelled during backoff")
+			return fmt.Errorf("operation cancelled while waiting to retry: %w", err)
 		case <-time.After(nextBackoff):
 			// Continue to next attempt
 		}
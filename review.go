@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// Defaults for diff reviews
+const (
+	defaultReviewContextLines  = 10
+	maxReviewContextLines      = 200
+	defaultReviewFullFileLines = 400
+)
+
+// Special head values selecting uncommitted changes instead of a commit
+const (
+	reviewHeadWorkingTree = "working_tree"
+	reviewHeadStaged      = "staged"
+)
+
+// reviewInstructions tells the model how to scope and format a diff review
+const reviewInstructions = `Review the change below. Focus on the changed lines (marked + and -); use the surrounding context and the full files only to understand them.
+Report each finding as ` + "`path:line`" + ` using the new-file line numbers shown in the left column of the diff, followed by a severity (critical, major, minor or nit), the problem and a suggested fix.
+Do not comment on unchanged code unless the change breaks it. If the change has no problems, say so.`
+
+// hunkHeaderPattern matches a unified diff hunk header such as `@@ -10,7 +10,8 @@ func foo()`
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// DiffFile is the part of a unified diff describing one file
+type DiffFile struct {
+	OldPath string
+	NewPath string
+	Status  string // added, deleted, modified, renamed or binary
+	Hunks   []DiffHunk
+	Added   int
+	Removed int
+	Omitted string // Why the hunks were left out of the review, if they were
+}
+
+// DiffHunk is one hunk of a DiffFile
+type DiffHunk struct {
+	Header   string
+	NewStart int
+	Lines    []string // Raw hunk lines including their ' ', '+', '-' or '\' marker
+}
+
+// Path returns the path of the file after the change, or before it for deleted files
+func (f *DiffFile) Path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
+// parseUnifiedDiff splits `git diff` output into files and hunks
+func parseUnifiedDiff(diff string) []DiffFile {
+	var files []DiffFile
+	var current *DiffFile
+	var hunk *DiffHunk
+
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, DiffFile{Status: "modified"})
+			current = &files[len(files)-1]
+			hunk = nil
+			if paths := strings.SplitN(strings.TrimPrefix(line, "diff --git a/"), " b/", 2); len(paths) == 2 {
+				current.OldPath, current.NewPath = paths[0], paths[1]
+			}
+		case current == nil:
+			continue
+		case hunk == nil && strings.HasPrefix(line, "new file mode"):
+			current.Status = "added"
+		case hunk == nil && strings.HasPrefix(line, "deleted file mode"):
+			current.Status = "deleted"
+		case hunk == nil && strings.HasPrefix(line, "rename from "):
+			current.Status = "renamed"
+			current.OldPath = strings.TrimPrefix(line, "rename from ")
+		case hunk == nil && strings.HasPrefix(line, "rename to "):
+			current.NewPath = strings.TrimPrefix(line, "rename to ")
+		case hunk == nil && strings.HasPrefix(line, "Binary files "):
+			current.Status = "binary"
+		case hunk == nil && strings.HasPrefix(line, "--- "):
+			if path := strings.TrimPrefix(line, "--- "); path != "/dev/null" {
+				current.OldPath = strings.TrimPrefix(path, "a/")
+			}
+		case hunk == nil && strings.HasPrefix(line, "+++ "):
+			if path := strings.TrimPrefix(line, "+++ "); path == "/dev/null" {
+				current.NewPath = ""
+			} else {
+				current.NewPath = strings.TrimPrefix(path, "b/")
+			}
+		case strings.HasPrefix(line, "@@"):
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			newStart, _ := strconv.Atoi(match[3])
+			current.Hunks = append(current.Hunks, DiffHunk{Header: line, NewStart: newStart})
+			hunk = &current.Hunks[len(current.Hunks)-1]
+		case hunk != nil:
+			hunk.Lines = append(hunk.Lines, line)
+			if strings.HasPrefix(line, "+") {
+				current.Added++
+			} else if strings.HasPrefix(line, "-") {
+				current.Removed++
+			}
+		}
+	}
+	return files
+}
+
+// formatAnnotatedDiff renders the diff with each context and added line prefixed by its new-file
+// line number, so findings can cite the lines as they are after the change
+func formatAnnotatedDiff(files []DiffFile) string {
+	var sb strings.Builder
+	for _, file := range files {
+		path := file.Path()
+		if file.Status == "renamed" {
+			path = file.OldPath + " → " + file.NewPath
+		}
+		sb.WriteString(fmt.Sprintf("\n## %s (%s, +%d -%d)\n\n", path, file.Status, file.Added, file.Removed))
+		if file.Omitted != "" {
+			sb.WriteString(fmt.Sprintf("(changes not shown: %s)\n", file.Omitted))
+			continue
+		}
+		if len(file.Hunks) == 0 {
+			sb.WriteString("(no textual changes)\n")
+			continue
+		}
+
+		sb.WriteString("```diff\n")
+		for _, hunk := range file.Hunks {
+			sb.WriteString(hunk.Header + "\n")
+			newLine := hunk.NewStart
+			for _, line := range hunk.Lines {
+				switch {
+				case strings.HasPrefix(line, "-"):
+					sb.WriteString(fmt.Sprintf("%6s %s\n", "", line))
+				case strings.HasPrefix(line, "\\"):
+					sb.WriteString(fmt.Sprintf("%6s %s\n", "", line))
+				default:
+					sb.WriteString(fmt.Sprintf("%6d %s\n", newLine, line))
+					newLine++
+				}
+			}
+		}
+		sb.WriteString("```\n")
+	}
+	return sb.String()
+}
+
+// runGit runs a git command in dir and returns its standard output
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir, "-c", "core.quotePath=false"}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// validateGitRef rejects refs that git could mistake for options
+func validateGitRef(ref string) error {
+	if strings.HasPrefix(ref, "-") || strings.ContainsAny(ref, " \t\n") {
+		return fmt.Errorf("invalid git ref %q", ref)
+	}
+	return nil
+}
+
+// handleReviewDiff handles requests to the deepseek_review_diff tool
+func (s *DeepseekServer) handleReviewDiff(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	logger := getLoggerFromContext(ctx)
+
+	// Resolve the repository inside the workspace
+	repoPath, _ := req.Arguments["repo_path"].(string)
+	if repoPath == "" {
+		repoPath = "."
+	}
+	repoDir, err := s.workspace.Resolve(repoPath)
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("Invalid repo_path: %v", err)), nil
+	}
+	topLevel, err := runGit(ctx, repoDir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("%s is not a git repository: %v", repoPath, err)), nil
+	}
+	repoRoot, err := s.workspace.Resolve(strings.TrimSpace(topLevel))
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("The repository root is outside the workspace: %v", err)), nil
+	}
+
+	// Determine which change to review
+	head, _ := req.Arguments["head"].(string)
+	if head == "" {
+		head = reviewHeadWorkingTree
+	}
+	base, _ := req.Arguments["base"].(string)
+	if base == "" {
+		if head != reviewHeadWorkingTree && head != reviewHeadStaged {
+			return createErrorResponse("base is required when head is a commit or branch"), nil
+		}
+		base = "HEAD"
+	}
+	if err := validateGitRef(base); err != nil {
+		return createErrorResponse(err.Error()), nil
+	}
+	if err := validateGitRef(head); err != nil {
+		return createErrorResponse(err.Error()), nil
+	}
+
+	contextLines := defaultReviewContextLines
+	if contextLinesRaw, ok := req.Arguments["context_lines"].(float64); ok && contextLinesRaw >= 0 {
+		contextLines = min(int(contextLinesRaw), maxReviewContextLines)
+	}
+	fullFileLines := defaultReviewFullFileLines
+	if fullFileLinesRaw, ok := req.Arguments["full_file_max_lines"].(float64); ok && fullFileLinesRaw >= 0 {
+		fullFileLines = int(fullFileLinesRaw)
+	}
+
+	diffArgs := []string{"diff", "--no-color", "--no-ext-diff", "--find-renames", fmt.Sprintf("-U%d", contextLines)}
+	var changeLabel string
+	switch head {
+	case reviewHeadWorkingTree:
+		diffArgs = append(diffArgs, base)
+		changeLabel = fmt.Sprintf("working tree against %s", base)
+	case reviewHeadStaged:
+		diffArgs = append(diffArgs, "--cached", base)
+		changeLabel = fmt.Sprintf("staged changes against %s", base)
+	default:
+		diffArgs = append(diffArgs, base+"..."+head)
+		changeLabel = fmt.Sprintf("%s...%s", base, head)
+	}
+	diffArgs = append(diffArgs, "--")
+
+	diff, err := runGit(ctx, repoRoot, diffArgs...)
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("Failed to compute diff: %v", err)), nil
+	}
+	diffFiles := parseUnifiedDiff(diff)
+	if len(diffFiles) == 0 {
+		return createErrorResponse(fmt.Sprintf("No changes found (%s). Untracked files are not included in working tree diffs.", changeLabel)), nil
+	}
+	logger.Info("Reviewing %s in %s: %d file(s) changed", changeLabel, repoRoot, len(diffFiles))
+
+	// Changes to files that file_paths would refuse are not sent either; only their headers remain
+	validator := NewFileValidator(s.config, s.workspace, s.fileTypes, s.redactor)
+	report := &FileReport{}
+	revisionFiles := s.screenDiffFiles(ctx, validator, report, repoRoot, head, diffFiles)
+	if refusal := secretRefusal(report); refusal != nil {
+		return refusal, nil
+	}
+
+	// The diff comes first in the request budget; full files are added while there is room
+	annotatedDiff, redacted, err := s.redactor.Check(formatAnnotatedDiff(diffFiles))
+	if err != nil {
+		return createErrorResponse(fmt.Sprintf("Request refused: the diff contains %v. Remove the secrets or add an allow pattern to DEEPSEEK_REDACTION_ALLOW if they are false positives.", err)), nil
+	}
+	if redacted > 0 {
+		logger.Info("Redacted %d likely secret(s) from the diff", redacted)
+	}
+	if err := validator.Admit([]*LoadedFile{{Path: "diff", Content: []byte(annotatedDiff)}}); err != nil {
+		return createErrorResponse(fmt.Sprintf("The diff is too large to review in one request (%v). Review a smaller range of commits or fewer files.", err)), nil
+	}
+
+	var files []LoadedFile
+	for _, diffFile := range diffFiles {
+		path := diffFile.NewPath
+		file := revisionFiles[path]
+		if fullFileLines == 0 || file == nil {
+			continue
+		}
+		report.include(path, 0)
+
+		if lines := len(splitLinesKeepEnds(string(file.Content))); lines > fullFileLines {
+			report.reject(path, fileSkipped, fmt.Sprintf("%d lines, above the %d line limit for full files", lines, fullFileLines))
+			continue
+		}
+		if err := validator.Admit([]*LoadedFile{file}); err != nil {
+			report.reject(path, fileStatusForError(err), err.Error())
+			continue
+		}
+		report.setLoaded(path, []*LoadedFile{file})
+		files = append(files, *file)
+	}
+
+	// Build the review request with the configured system prompt
+	systemPrompt := s.config.DeepseekSystemPrompt
+	if customPrompt, ok := req.Arguments["systemPrompt"].(string); ok && customPrompt != "" {
+		systemPrompt = customPrompt
+	}
+	modelName := s.config.DeepseekModel
+	if customModel, ok := req.Arguments["model"].(string); ok && customModel != "" {
+		if err := s.ValidateModelID(customModel); err != nil {
+			return createErrorResponse(fmt.Sprintf("Invalid model: %v", err)), nil
+		}
+		modelName = customModel
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString(reviewInstructions)
+	if focus, ok := req.Arguments["focus"].(string); ok && focus != "" {
+		prompt.WriteString("\n\nPay particular attention to: " + focus)
+	}
+	prompt.WriteString(fmt.Sprintf("\n\n# Change (%s)\n", changeLabel))
+	prompt.WriteString(annotatedDiff)
+	if len(files) > 0 {
+		prompt.WriteString("\n# Full Files After the Change\n")
+		for _, file := range files {
+			prompt.WriteString(fmt.Sprintf("\n## %s\n\n```%s\n%s\n```\n", file.Path, file.Type.Language, string(file.Content)))
+		}
+	}
+
+	messages := []deepseek.ChatCompletionMessage{
+		{Role: deepseek.ChatMessageRoleSystem, Content: systemPrompt},
+		{Role: deepseek.ChatMessageRoleUser, Content: prompt.String()},
+	}
+	request, requestNotes := buildChatRequest(modelName, messages, s.config.DeepseekTemperature, false)
+
+	response, stats, err := s.executeDeepseekRequest(ctx, request, progressTokenFromContext(ctx))
+	if err != nil {
+		logger.Error("DeepSeek API error: %v", err)
+		return createErrorResponse(fmt.Sprintf("Error from DeepSeek API: %v", err)), nil
+	}
+
+	result := s.formatResponse(response, reasoningNone, stats)
+	var added, removed int
+	for _, diffFile := range diffFiles {
+		added += diffFile.Added
+		removed += diffFile.Removed
+	}
+	result.Content = append(result.Content, protocol.ToolContent{
+		Type: "text",
+		Text: fmt.Sprintf("## Reviewed Change\n\n**Change:** %s in `%s` | **Files:** %d | **Lines:** +%d -%d",
+			changeLabel, filepath.Base(repoRoot), len(diffFiles), added, removed),
+	})
+	if len(report.Entries) > 0 {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: report.Format(),
+		})
+	}
+	for _, note := range requestNotes {
+		result.Content = append(result.Content, protocol.ToolContent{
+			Type: "text",
+			Text: "*Note: " + note + "*",
+		})
+	}
+	return result, nil
+}
+
+// screenDiffFiles applies the file inclusion rules of file_paths to the changed files. Hunks of
+// files that are ignored, of a type that is not allowed or too large are dropped and the file is
+// recorded in the report. It returns the files that passed, as they are after the change, by path.
+func (s *DeepseekServer) screenDiffFiles(ctx context.Context, validator *FileValidator, report *FileReport,
+	repoRoot, head string, diffFiles []DiffFile) map[string]*LoadedFile {
+	logger := getLoggerFromContext(ctx)
+	matcher := newIgnoreMatcher()
+	files := make(map[string]*LoadedFile)
+
+	for i := range diffFiles {
+		diffFile := &diffFiles[i]
+		path := diffFile.Path()
+		var err error
+		switch {
+		case matcher.ignoredPath(repoRoot, path) || (diffFile.OldPath != "" && matcher.ignoredPath(repoRoot, diffFile.OldPath)):
+			err = fmt.Errorf("%w: %s (excluded by .gitignore or .deepseekignore)", ErrPathNotAllowed, path)
+		case diffFile.Status == "deleted" || diffFile.Status == "binary":
+			// Only the name is left to check; git shows no content for binary changes anyway
+			err = checkFileType(path, s.fileTypes.Detect(path, nil), s.fileTypes, validator.allowedTypes)
+		default:
+			var file *LoadedFile
+			if file, err = s.readRevisionFile(ctx, validator, repoRoot, head, path); err == nil {
+				files[path] = file
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+		status := fileStatusForError(err)
+		report.include(path, 0)
+		report.reject(path, status, err.Error())
+
+		// Unreadable files keep their hunks, and strict mode refuses the whole request over secrets
+		switch status {
+		case fileRejectedPath:
+			diffFile.Omitted = "excluded by .gitignore or .deepseekignore"
+		case fileRejectedType:
+			diffFile.Omitted = "file type not allowed"
+		case fileRejectedSize:
+			diffFile.Omitted = "file is too large"
+		default:
+			continue
+		}
+		logger.Info("Leaving the changes to %s out of the review: %v", path, err)
+		diffFile.Hunks = nil
+	}
+	return files
+}
+
+// readRevisionFile reads a changed file as it is after the change: from disk for working tree
+// reviews and from git for staged changes and commits. The returned file is labelled with its repository path.
+func (s *DeepseekServer) readRevisionFile(ctx context.Context, validator *FileValidator, repoRoot, head, path string) (*LoadedFile, error) {
+	if head == reviewHeadWorkingTree {
+		file, err := validator.Read(filepath.Join(repoRoot, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		file.Path = path
+		return file, nil
+	}
+
+	revision := head
+	if head == reviewHeadStaged {
+		revision = ""
+	}
+	content, err := runGit(ctx, repoRoot, "show", revision+":"+path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileUnreadable, err)
+	}
+	return validator.Inspect(path, []byte(content))
+}
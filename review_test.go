@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reviewTestDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -10,4 +10,5 @@ func main() {
+ 	a := 1
+-	b := 2
++	b := 3
++	c := 4
+ 	fmt.Println(a, b)
+@@ -40,2 +41,2 @@ func helper() {
+-	return 1
++	return 2
+ }
+diff --git a/docs/new.md b/docs/new.md
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/docs/new.md
+@@ -0,0 +1,2 @@
++# New
++text
+\ No newline at end of file
+diff --git a/old.go b/old.go
+deleted file mode 100644
+index 4444444..0000000
+--- a/old.go
++++ /dev/null
+@@ -1,1 +0,0 @@
+-package old
+diff --git a/pkg/a.go b/pkg/b.go
+similarity index 90%
+rename from pkg/a.go
+rename to pkg/b.go
+diff --git a/logo.png b/logo.png
+index 5555555..6666666 100644
+Binary files a/logo.png and b/logo.png differ
+`
+
+func TestParseUnifiedDiff(t *testing.T) {
+	files := parseUnifiedDiff(reviewTestDiff)
+
+	tests := []struct {
+		path    string
+		oldPath string
+		status  string
+		hunks   int
+		added   int
+		removed int
+	}{
+		{"main.go", "main.go", "modified", 2, 3, 2},
+		{"docs/new.md", "docs/new.md", "added", 1, 2, 0},
+		{"old.go", "old.go", "deleted", 1, 0, 1},
+		{"pkg/b.go", "pkg/a.go", "renamed", 0, 0, 0},
+		{"logo.png", "logo.png", "binary", 0, 0, 0},
+	}
+	if len(files) != len(tests) {
+		t.Fatalf("parsed %d files, want %d: %+v", len(files), len(tests), files)
+	}
+	for i, tt := range tests {
+		file := files[i]
+		if file.Path() != tt.path || file.OldPath != tt.oldPath || file.Status != tt.status ||
+			len(file.Hunks) != tt.hunks || file.Added != tt.added || file.Removed != tt.removed {
+			t.Errorf("file %d = %s (old %q, %s, %d hunks, +%d -%d), want %s (old %q, %s, %d hunks, +%d -%d)",
+				i, file.Path(), file.OldPath, file.Status, len(file.Hunks), file.Added, file.Removed,
+				tt.path, tt.oldPath, tt.status, tt.hunks, tt.added, tt.removed)
+		}
+	}
+
+	if hunk := files[0].Hunks[1]; hunk.NewStart != 41 || len(hunk.Lines) != 3 {
+		t.Errorf("second hunk starts at %d with %d lines, want 41 and 3", hunk.NewStart, len(hunk.Lines))
+	}
+	if parseUnifiedDiff("") != nil || parseUnifiedDiff("no diff here\n") != nil {
+		t.Error("text without a diff header produced files")
+	}
+}
+
+func TestFormatAnnotatedDiff(t *testing.T) {
+	got := formatAnnotatedDiff(parseUnifiedDiff(reviewTestDiff))
+
+	for _, want := range []string{
+		"## main.go (modified, +3 -2)",
+		"@@ -10,4 +10,5 @@ func main() {\n    10  \ta := 1\n       -\tb := 2\n    11 +\tb := 3\n    12 +\tc := 4\n    13  \tfmt.Println(a, b)\n",
+		"@@ -40,2 +41,2 @@ func helper() {\n       -\treturn 1\n    41 +\treturn 2\n    42  }\n",
+		"## docs/new.md (added, +2 -0)",
+		"     2 +text\n       \\ No newline at end of file\n",
+		"## old.go (deleted, +0 -1)",
+		"## pkg/a.go → pkg/b.go (renamed, +0 -0)\n\n(no textual changes)\n",
+		"## logo.png (binary, +0 -0)\n\n(no textual changes)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("annotated diff does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestValidateGitRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr bool
+	}{
+		{"HEAD", false},
+		{"main~2", false},
+		{"origin/feature-x", false},
+		{"--output=/tmp/x", true},
+		{"HEAD main", true},
+	}
+	for _, tt := range tests {
+		if err := validateGitRef(tt.ref); (err != nil) != tt.wantErr {
+			t.Errorf("validateGitRef(%q) = %v, want error %v", tt.ref, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScreenDiffFiles(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, root, map[string]string{
+		".gitignore":              "dist/\n",
+		"main.go":                 "package main\n",
+		"dist/bundle.go":          "package dist\n",
+		"secrets/.deepseekignore": "*.go\n",
+		"secrets/keys.go":         "package secrets\n",
+		"notes.md":                "# Notes\n",
+		"big.go":                  strings.Repeat("// comment\n", 200),
+	})
+	ws, err := NewWorkspace([]string{root}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{AllowedFileTypes: []string{"text/x-go"}, MaxFileSize: 1024}
+	s := &DeepseekServer{config: config, workspace: ws, fileTypes: NewFileTypeRegistry(nil)}
+	ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+
+	hunk := []DiffHunk{{Header: "@@ -1 +1 @@", NewStart: 1, Lines: []string{"+changed"}}}
+	diffFiles := []DiffFile{
+		{OldPath: "main.go", NewPath: "main.go", Status: "modified", Hunks: hunk},
+		{OldPath: "dist/bundle.go", NewPath: "dist/bundle.go", Status: "modified", Hunks: hunk},
+		{OldPath: "secrets/keys.go", NewPath: "secrets/keys.go", Status: "modified", Hunks: hunk},
+		{OldPath: "notes.md", NewPath: "notes.md", Status: "modified", Hunks: hunk},
+		{OldPath: "big.go", NewPath: "big.go", Status: "modified", Hunks: hunk},
+		{OldPath: "old.py", Status: "deleted", Hunks: hunk},
+		{OldPath: "gone.go", NewPath: "gone.go", Status: "modified", Hunks: hunk},
+	}
+	report := &FileReport{}
+	files := s.screenDiffFiles(ctx, NewFileValidator(config, ws, s.fileTypes, nil), report, root, reviewHeadWorkingTree, diffFiles)
+
+	if len(files) != 1 || files["main.go"] == nil || files["main.go"].Path != "main.go" {
+		t.Errorf("files = %v, want only main.go", files)
+	}
+	want := map[string]struct{ omitted, status string }{
+		"main.go":         {"", ""},
+		"dist/bundle.go":  {"excluded by .gitignore or .deepseekignore", fileRejectedPath},
+		"secrets/keys.go": {"excluded by .gitignore or .deepseekignore", fileRejectedPath},
+		"notes.md":        {"file type not allowed", fileRejectedType},
+		"big.go":          {"file is too large", fileRejectedSize},
+		"old.py":          {"file type not allowed", fileRejectedType},
+		"gone.go":         {"", fileUnreadable}, // Its hunks are kept, only the full file is missing
+	}
+	for _, diffFile := range diffFiles {
+		w := want[diffFile.Path()]
+		if diffFile.Omitted != w.omitted || (len(diffFile.Hunks) == 0) != (w.omitted != "") {
+			t.Errorf("%s: omitted %q with %d hunks, want omitted %q", diffFile.Path(), diffFile.Omitted, len(diffFile.Hunks), w.omitted)
+		}
+	}
+	statuses := make(map[string]string)
+	for _, entry := range report.Entries {
+		statuses[entry.Path] = entry.Status
+	}
+	for path, w := range want {
+		if statuses[path] != w.status {
+			t.Errorf("%s: report status %q, want %q", path, statuses[path], w.status)
+		}
+	}
+
+	annotated := formatAnnotatedDiff(diffFiles)
+	if !strings.Contains(annotated, "## dist/bundle.go (modified, +0 -0)\n\n(changes not shown: excluded by .gitignore or .deepseekignore)\n") {
+		t.Errorf("annotated diff does not stub the ignored file:\n%s", annotated)
+	}
+	if strings.Count(annotated, "+changed") != 2 {
+		t.Errorf("annotated diff shows the hunks of %d files, want main.go and gone.go:\n%s", strings.Count(annotated, "+changed"), annotated)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxListedSymbols caps the number of symbols suggested when a symbol selector does not match
+const maxListedSymbols = 20
+
+// File reference suffixes selecting part of a file, e.g. `deepseek.go:240-330` or `deepseek.go#handleAskDeepseek`
+var (
+	lineRangeSuffix = regexp.MustCompile(`:(\d+)(?:-(\d+))?$`)
+	symbolSuffix    = regexp.MustCompile(`#([A-Za-z_$][A-Za-z0-9_$.]*)$`)
+)
+
+// FileSelector selects part of a file, either a line range or a named top-level symbol
+type FileSelector struct {
+	StartLine int
+	EndLine   int
+	Symbol    string
+}
+
+// String renders the selector the way it is written in a file reference
+func (sel FileSelector) String() string {
+	if sel.Symbol != "" {
+		return "#" + sel.Symbol
+	}
+	if sel.StartLine == sel.EndLine {
+		return fmt.Sprintf(":%d", sel.StartLine)
+	}
+	return fmt.Sprintf(":%d-%d", sel.StartLine, sel.EndLine)
+}
+
+// parseFileSelector splits a file reference into its path and optional selector.
+// A line range is `path:start-end` or `path:line`; a symbol is `path#Name` or `path#Type.Method`.
+func parseFileSelector(entry string) (string, *FileSelector, error) {
+	if match := symbolSuffix.FindStringSubmatchIndex(entry); match != nil {
+		return entry[:match[0]], &FileSelector{Symbol: entry[match[2]:match[3]]}, nil
+	}
+
+	match := lineRangeSuffix.FindStringSubmatchIndex(entry)
+	if match == nil {
+		return entry, nil, nil
+	}
+	start, err := strconv.Atoi(entry[match[2]:match[3]])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid line number in %s: %w", entry, err)
+	}
+	end := start
+	if match[4] >= 0 {
+		end, err = strconv.Atoi(entry[match[4]:match[5]])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid line number in %s: %w", entry, err)
+		}
+	}
+	if start < 1 || end < start {
+		return "", nil, fmt.Errorf("invalid line range in %s: lines are numbered from 1 and the end must not precede the start", entry)
+	}
+	return entry[:match[0]], &FileSelector{StartLine: start, EndLine: end}, nil
+}
+
+// Apply returns the excerpt of a loaded file chosen by the selector, labelled with its real line numbers.
+// Ranges running past the end of the file are clamped to the last line.
+func (sel FileSelector) Apply(file *LoadedFile) (*LoadedFile, error) {
+	lines := splitLinesKeepEnds(string(file.Content))
+
+	start, end := sel.StartLine, sel.EndLine
+	if sel.Symbol != "" {
+		symbol, err := findSymbol(file, sel.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		start, end = symbol.StartLine, symbol.EndLine
+		if end == 0 {
+			end = len(lines)
+		}
+	}
+
+	if start > len(lines) {
+		return nil, fmt.Errorf("line %d is out of range (file has %d lines)", start, len(lines))
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	excerpt := *file
+	excerpt.Content = []byte(strings.Join(lines[start-1:end], ""))
+	excerpt.StartLine = start
+	excerpt.EndLine = end
+	excerpt.Symbol = sel.Symbol
+	return &excerpt, nil
+}
+
+// findSymbol looks a symbol up in the file's outline. `Type.Method` matches a method exactly;
+// a bare name matches a function, type or variable, or a method of any type when that is unambiguous.
+func findSymbol(file *LoadedFile, name string) (OutlineSymbol, error) {
+	symbols := fileOutline(file.Path, file.Content)
+
+	var matches []OutlineSymbol
+	for _, symbol := range symbols {
+		if symbol.Name == name {
+			return symbol, nil
+		}
+		if i := strings.LastIndex(symbol.Name, "."); i >= 0 && symbol.Name[i+1:] == name {
+			matches = append(matches, symbol)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		var names []string
+		for i, symbol := range symbols {
+			if i >= maxListedSymbols {
+				names = append(names, "...")
+				break
+			}
+			names = append(names, symbol.Name)
+		}
+		if len(names) == 0 {
+			return OutlineSymbol{}, fmt.Errorf("symbol %s not found (no declarations found in the file)", name)
+		}
+		return OutlineSymbol{}, fmt.Errorf("symbol %s not found; declarations in the file: %s", name, strings.Join(names, ", "))
+	default:
+		var names []string
+		for _, symbol := range matches {
+			names = append(names, symbol.Name)
+		}
+		return OutlineSymbol{}, fmt.Errorf("symbol %s is ambiguous; use one of: %s", name, strings.Join(names, ", "))
+	}
+}
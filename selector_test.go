@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFileSelector(t *testing.T) {
+	tests := []struct {
+		entry    string
+		wantPath string
+		want     *FileSelector
+		wantErr  bool
+	}{
+		{"deepseek.go", "deepseek.go", nil, false},
+		{"deepseek.go:240-330", "deepseek.go", &FileSelector{StartLine: 240, EndLine: 330}, false},
+		{"deepseek.go:12", "deepseek.go", &FileSelector{StartLine: 12, EndLine: 12}, false},
+		{"deepseek.go#handleAskDeepseek", "deepseek.go", &FileSelector{Symbol: "handleAskDeepseek"}, false},
+		{"deepseek.go#DeepseekServer.handleComplete", "deepseek.go", &FileSelector{Symbol: "DeepseekServer.handleComplete"}, false},
+		{"src/app.js#$init", "src/app.js", &FileSelector{Symbol: "$init"}, false},
+		{`C:\src\main.go`, `C:\src\main.go`, nil, false}, // A drive letter is not a line number
+		{"notes#1.md", "notes#1.md", nil, false},
+		{"main.go:", "main.go:", nil, false},
+		{"main.go:0", "", nil, true},
+		{"main.go:30-20", "", nil, true},
+		{"main.go:99999999999999999999", "", nil, true},
+	}
+	for _, tt := range tests {
+		path, sel, err := parseFileSelector(tt.entry)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFileSelector(%q) error = %v, want error %v", tt.entry, err, tt.wantErr)
+			continue
+		}
+		if path != tt.wantPath || (sel == nil) != (tt.want == nil) || (sel != nil && *sel != *tt.want) {
+			t.Errorf("parseFileSelector(%q) = %q, %+v; want %q, %+v", tt.entry, path, sel, tt.wantPath, tt.want)
+		}
+		if sel != nil && path+sel.String() != tt.entry {
+			t.Errorf("selector %+v renders as %q, want it to round-trip %q", sel, path+sel.String(), tt.entry)
+		}
+	}
+}
+
+func TestFileSelectorApply(t *testing.T) {
+	source := `package demo
+
+type Server struct{}
+
+func (s *Server) Start() error {
+	return nil
+}
+
+func (s *Server) Stop() {}
+
+type Client struct{}
+
+func (c *Client) Stop() {}
+
+func helper() int {
+	return 1
+}
+`
+	file := &LoadedFile{Path: "demo.go", Content: []byte(source)}
+
+	tests := []struct {
+		name      string
+		sel       FileSelector
+		wantStart int
+		wantEnd   int
+		wantFirst string // First line of the excerpt
+		wantErr   string
+	}{
+		{"line range", FileSelector{StartLine: 3, EndLine: 5}, 3, 5, "type Server struct{}", ""},
+		{"single line", FileSelector{StartLine: 9, EndLine: 9}, 9, 9, "func (s *Server) Stop() {}", ""},
+		{"range clamped to the end", FileSelector{StartLine: 15, EndLine: 100}, 15, 17, "func helper() int {", ""},
+		{"range past the end", FileSelector{StartLine: 40, EndLine: 50}, 0, 0, "", "out of range"},
+		{"function", FileSelector{Symbol: "helper"}, 15, 17, "func helper() int {", ""},
+		{"method by type", FileSelector{Symbol: "Server.Start"}, 5, 7, "func (s *Server) Start() error {", ""},
+		{"unambiguous bare method", FileSelector{Symbol: "Start"}, 5, 7, "func (s *Server) Start() error {", ""},
+		{"ambiguous bare method", FileSelector{Symbol: "Stop"}, 0, 0, "", "ambiguous"},
+		{"unknown symbol", FileSelector{Symbol: "Missing"}, 0, 0, "", "declarations in the file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			excerpt, err := tt.sel.Apply(file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if excerpt.StartLine != tt.wantStart || excerpt.EndLine != tt.wantEnd {
+				t.Errorf("excerpt lines %d-%d, want %d-%d", excerpt.StartLine, excerpt.EndLine, tt.wantStart, tt.wantEnd)
+			}
+			if first := strings.SplitN(string(excerpt.Content), "\n", 2)[0]; first != tt.wantFirst {
+				t.Errorf("excerpt starts with %q, want %q", first, tt.wantFirst)
+			}
+			if excerpt.Symbol != tt.sel.Symbol || string(file.Content) != source {
+				t.Errorf("excerpt symbol %q, or the original file was modified", excerpt.Symbol)
+			}
+		})
+	}
+}
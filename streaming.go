@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Streaming phases reported in progress notifications
+const (
+	phaseWaiting   = "waiting"
+	phaseReasoning = "reasoning"
+	phaseAnswering = "answering"
+	phaseComplete  = "complete"
+)
+
+// progressInterval is the minimum delay between two progress notifications for the same request
+const progressInterval = 500 * time.Millisecond
+
+// ProgressNotifier sends MCP progress notifications to the client
+type ProgressNotifier interface {
+	NotifyProgress(token interface{}, progress int, message string) error
+}
+
+// streamUsageReader passes a server-sent event stream through unchanged while recording the
+// context cache usage of its usage chunk in meta, since the library's stream chunks omit it
+type streamUsageReader struct {
+	body io.ReadCloser
+	meta *responseMeta
+	line []byte // Unfinished line carried over between reads
+}
+
+// Read implements io.Reader
+func (r *streamUsageReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			// Only usage chunks matter, and they are short, so long content lines are not kept
+			if len(r.line)+len(data) <= maxUsageLineSize {
+				r.line = append(r.line, data...)
+			} else {
+				r.line = r.line[:0]
+			}
+			break
+		}
+		if len(r.line)+end <= maxUsageLineSize {
+			r.recordUsage(append(r.line, data[:end]...))
+		}
+		r.line = r.line[:0]
+		data = data[end+1:]
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (r *streamUsageReader) Close() error {
+	return r.body.Close()
+}
+
+// maxUsageLineSize is the longest event line inspected for usage
+const maxUsageLineSize = 64 * 1024
+
+// recordUsage stores the cache usage of a "data:" line carrying a usage object
+func (r *streamUsageReader) recordUsage(line []byte) {
+	payload, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok || !bytes.Contains(payload, []byte(`"usage"`)) {
+		return
+	}
+	var chunk struct {
+		Usage *struct {
+			PromptCacheHitTokens  int `json:"prompt_cache_hit_tokens"`
+			PromptCacheMissTokens int `json:"prompt_cache_miss_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(payload, &chunk) != nil || chunk.Usage == nil {
+		return
+	}
+	r.meta.mu.Lock()
+	r.meta.cacheHitTokens = chunk.Usage.PromptCacheHitTokens
+	r.meta.cacheMissTokens = chunk.Usage.PromptCacheMissTokens
+	r.meta.mu.Unlock()
+}
+
+// streamProgress tracks the state of a streamed completion and throttles progress notifications
+type streamProgress struct {
+	notifier ProgressNotifier
+	token    interface{}
+	logger   Logger
+	phase    string
+	chunks   int
+	lastSent time.Time
+}
+
+// update records a received chunk and sends a notification on phase changes or after progressInterval
+func (p *streamProgress) update(phase string) {
+	p.chunks++
+	if phase == p.phase && time.Since(p.lastSent) < progressInterval {
+		return
+	}
+	p.phase = phase
+	p.send()
+}
+
+// send emits a progress notification with the current state
+func (p *streamProgress) send() {
+	p.lastSent = time.Now()
+	if p.notifier == nil || p.token == nil {
+		return
+	}
+	message := fmt.Sprintf("%s: %d chunks received", p.phase, p.chunks)
+	if err := p.notifier.NotifyProgress(p.token, p.chunks, message); err != nil {
+		p.logger.Warn("Failed to send progress notification: %v", err)
+	}
+}
+
+// createChatCompletion makes a single attempt at a chat completion request, streaming it when streaming
+// is enabled. Secrets are redacted from the messages first, or the request is refused in strict mode.
+// Tools call it through executeDeepseekRequest, which adds the timeout and retries.
+func (s *DeepseekServer) createChatCompletion(ctx context.Context, request *deepseek.ChatCompletionRequest, progressToken interface{}) (*deepseek.ChatCompletionResponse, error) {
+	request, redacted, err := s.redactChatRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if redacted > 0 {
+		getLoggerFromContext(ctx).Info("Redacted %d likely secret(s) from the request messages", redacted)
+	}
+
+	// Tool calls are not assembled from streamed chunks, so requests offering tools are never streamed
+	if !s.config.EnableStreaming || len(request.Tools) > 0 {
+		return s.clientFor(request).CreateChatCompletion(ctx, request)
+	}
+	return s.streamChatCompletion(ctx, request, progressToken)
+}
+
+// streamChatCompletion sends the request using the streaming API, reports progress while
+// chunks arrive and assembles the chunks into a regular completion response
+func (s *DeepseekServer) streamChatCompletion(ctx context.Context, request *deepseek.ChatCompletionRequest, progressToken interface{}) (*deepseek.ChatCompletionResponse, error) {
+	logger := getLoggerFromContext(ctx)
+
+	streamRequest := &deepseek.StreamChatCompletionRequest{
+		Stream:        true,
+		StreamOptions: deepseek.StreamOptions{IncludeUsage: true},
+		Model:         request.Model,
+		Messages:      request.Messages,
+		MaxTokens:     request.MaxTokens,
+		Temperature:   request.Temperature,
+		Stop:          request.Stop,
+		Tools:         request.Tools,
+	}
+	// The streaming request has no JSON mode switch; ask for a JSON object through the response format
+	if request.JSONMode {
+		streamRequest.ResponseFormat = &deepseek.ResponseFormat{Type: "json_object"}
+	}
+
+	stream, err := s.clientFor(request).CreateChatCompletionStream(ctx, streamRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	progress := &streamProgress{
+		notifier: s.progress,
+		token:    progressToken,
+		logger:   logger,
+		phase:    phaseWaiting,
+	}
+	progress.send()
+
+	response := &deepseek.ChatCompletionResponse{Model: request.Model}
+	var content, reasoning strings.Builder
+	var finishReason string
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stream interrupted after %d chunks: %w", progress.chunks, err)
+		}
+
+		if response.ID == "" {
+			response.ID = chunk.ID
+			response.Created = chunk.Created
+			if chunk.Model != "" {
+				response.Model = chunk.Model
+			}
+		}
+		if chunk.Usage != nil {
+			response.Usage.PromptTokens = chunk.Usage.PromptTokens
+			response.Usage.CompletionTokens = chunk.Usage.CompletionTokens
+			response.Usage.TotalTokens = chunk.Usage.TotalTokens
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.ReasoningContent != "" {
+				reasoning.WriteString(choice.Delta.ReasoningContent)
+				progress.update(phaseReasoning)
+			}
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				progress.update(phaseAnswering)
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+
+	// Context cache usage is taken from the raw usage chunk recorded by the HTTP client
+	if meta, ok := ctx.Value(responseMetaKey{}).(*responseMeta); ok {
+		meta.mu.Lock()
+		response.Usage.PromptCacheHitTokens = meta.cacheHitTokens
+		response.Usage.PromptCacheMissTokens = meta.cacheMissTokens
+		meta.mu.Unlock()
+	}
+
+	progress.phase = phaseComplete
+	progress.send()
+	logger.Info("Stream completed: %d chunks received (finish reason: %s)", progress.chunks, finishReason)
+
+	response.Object = "chat.completion"
+	response.Choices = []deepseek.Choice{
+		{
+			Message: deepseek.Message{
+				Role:             deepseek.ChatMessageRoleAssistant,
+				Content:          content.String(),
+				ReasoningContent: reasoning.String(),
+			},
+			FinishReason: finishReason,
+		},
+	}
+	return response, nil
+}
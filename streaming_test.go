@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// recordingNotifier records the progress notifications it is asked to send
+type recordingNotifier struct {
+	mu    sync.Mutex
+	calls []progressParams
+}
+
+func (n *recordingNotifier) NotifyProgress(token interface{}, progress int, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, progressParams{ProgressToken: token, Progress: progress, Message: message})
+	return nil
+}
+
+// sseServer serves the given event payloads as a chat completion stream
+func sseServer(t *testing.T, events []string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Stream        bool `json:"stream"`
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Stream || !request.StreamOptions.IncludeUsage {
+			t.Errorf("request is not a streaming request with usage: %+v (%v)", request, err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	srv := sseServer(t, []string{
+		`{"id":"c1","created":1,"model":"deepseek-reasoner","choices":[{"index":0,"delta":{"role":"assistant","content":"","reasoning_content":"Think"}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{"content":"","reasoning_content":"ing."}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{"content":", world"},"finish_reason":"stop"}]}`,
+		`{"id":"c1","choices":[],"usage":{"prompt_tokens":30,"completion_tokens":5,"total_tokens":35,"prompt_cache_hit_tokens":20,"prompt_cache_miss_tokens":10}}`,
+		`[DONE]`,
+	})
+	defer srv.Close()
+
+	client := deepseek.NewClient("key", srv.URL+"/")
+	client.HTTPClient = &responseRecorder{next: http.DefaultClient}
+	notifier := &recordingNotifier{}
+	s := &DeepseekServer{
+		config:   &Config{EnableStreaming: true, HTTPTimeout: 5 * time.Second},
+		client:   client,
+		progress: notifier,
+	}
+
+	ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+	token := json.RawMessage(`"tok-1"`)
+	request := &deepseek.ChatCompletionRequest{
+		Model:    deepseek.DeepSeekReasoner,
+		Messages: []deepseek.ChatCompletionMessage{{Role: deepseek.ChatMessageRoleUser, Content: "hi"}},
+	}
+	resp, stats, err := s.executeDeepseekRequest(ctx, request, token)
+	if err != nil {
+		t.Fatalf("executeDeepseekRequest: %v", err)
+	}
+
+	message := resp.Choices[0].Message
+	if message.Content != "Hello, world" || message.ReasoningContent != "Thinking." {
+		t.Errorf("assembled message = %+v", message)
+	}
+	if resp.ID != "c1" || resp.Model != "deepseek-reasoner" || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("response metadata = %+v", resp)
+	}
+	want := deepseek.Usage{PromptTokens: 30, CompletionTokens: 5, TotalTokens: 35, PromptCacheHitTokens: 20, PromptCacheMissTokens: 10}
+	if resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+	if stats.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", stats.Attempts)
+	}
+
+	var phases []string
+	for _, call := range notifier.calls {
+		if string(call.ProgressToken.(json.RawMessage)) != `"tok-1"` {
+			t.Errorf("progress token = %s", call.ProgressToken)
+		}
+		phases = append(phases, strings.SplitN(call.Message, ":", 2)[0])
+	}
+	if got := strings.Join(phases, ","); got != "waiting,reasoning,answering,complete" {
+		t.Errorf("progress phases = %s", got)
+	}
+	if last := notifier.calls[len(notifier.calls)-1]; last.Progress != 4 || last.Message != "complete: 4 chunks received" {
+		t.Errorf("final progress = %d (%q), want 4 chunks", last.Progress, last.Message)
+	}
+}
+
+func TestStreamChatCompletionWithoutToken(t *testing.T) {
+	srv := sseServer(t, []string{
+		`{"id":"c2","choices":[{"index":0,"delta":{"content":"ok"},"finish_reason":"stop"}]}`,
+		`[DONE]`,
+	})
+	defer srv.Close()
+
+	notifier := &recordingNotifier{}
+	s := &DeepseekServer{
+		config:   &Config{EnableStreaming: true, HTTPTimeout: 5 * time.Second},
+		client:   deepseek.NewClient("key", srv.URL+"/"),
+		progress: notifier,
+	}
+	ctx := context.WithValue(context.Background(), loggerKey, NewLogger(LevelError))
+	request := &deepseek.ChatCompletionRequest{Model: deepseek.DeepSeekChat, Messages: []deepseek.ChatCompletionMessage{{Role: "user", Content: "hi"}}}
+
+	resp, _, err := s.executeDeepseekRequest(ctx, request, nil)
+	if err != nil {
+		t.Fatalf("executeDeepseekRequest: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "ok" {
+		t.Errorf("content = %q", resp.Choices[0].Message.Content)
+	}
+	if len(notifier.calls) != 0 {
+		t.Errorf("sent %d progress notifications without a progress token", len(notifier.calls))
+	}
+}
+
+func TestStreamUsageReaderSplitLines(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"" + strings.Repeat("x", 2*maxUsageLineSize) + "\"}}]}\n\n" +
+		"data: {\"usage\":{\"prompt_tokens\":3,\"prompt_cache_hit_tokens\":2,\"prompt_cache_miss_tokens\":1}}\n\n" +
+		"data: [DONE]\n\n"
+
+	for _, size := range []int{1, 7, 4096} {
+		t.Run(fmt.Sprintf("reads of %d bytes", size), func(t *testing.T) {
+			meta := &responseMeta{}
+			reader := &streamUsageReader{body: io.NopCloser(strings.NewReader(stream)), meta: meta}
+			buf := make([]byte, size)
+			var out strings.Builder
+			for {
+				n, err := reader.Read(buf)
+				out.Write(buf[:n])
+				if err != nil {
+					break
+				}
+			}
+			if out.String() != stream {
+				t.Error("the stream was altered")
+			}
+			if meta.cacheHitTokens != 2 || meta.cacheMissTokens != 1 {
+				t.Errorf("cache usage = %d hit, %d miss", meta.cacheHitTokens, meta.cacheMissTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/gomcpgo/mcp/pkg/handler"
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// JSON-RPC error codes
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+)
+
+// supportedProtocolVersions are the MCP protocol versions the server speaks, newest first
+var supportedProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// jsonRPCMessage is any JSON-RPC 2.0 message read from the client: a request, a notification
+// (no ID) or a response to a request sent by the server (no method)
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+// jsonRPCRequest is a JSON-RPC 2.0 request sent to the client
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params,omitempty"`
+}
+
+// jsonRPCResponse is a JSON-RPC 2.0 response sent to the client
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+// jsonRPCError is the error member of a JSON-RPC 2.0 response
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// jsonRPCNotification is a JSON-RPC 2.0 notification message
+type jsonRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// progressParams are the parameters of a notifications/progress message
+type progressParams struct {
+	ProgressToken interface{} `json:"progressToken"`
+	Progress      int         `json:"progress"`
+	Message       string      `json:"message,omitempty"`
+}
+
+// callToolParams are the parameters of a tools/call request
+type callToolParams struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Meta      struct {
+		ProgressToken json.RawMessage `json:"progressToken"`
+	} `json:"_meta"`
+}
+
+// listRootsResult is the result of a roots/list request
+type listRootsResult struct {
+	Roots []struct {
+		URI  string `json:"uri"`
+		Name string `json:"name,omitempty"`
+	} `json:"roots"`
+}
+
+// progressTokenKey is the context key under which a tool call's progress token is stored
+const progressTokenKey contextKey = "progress_token"
+
+// progressTokenFromContext returns the MCP progress token the client sent with the tool call, or nil
+func progressTokenFromContext(ctx context.Context) interface{} {
+	if token, ok := ctx.Value(progressTokenKey).(json.RawMessage); ok {
+		return token
+	}
+	return nil
+}
+
+// StdioServer serves MCP over newline-delimited JSON-RPC on stdin and stdout. Responses,
+// progress notifications and requests to the client all go through one writer, so messages
+// never interleave. Tool calls run concurrently, receive the progress token from the request's
+// _meta and are cancelled by notifications/cancelled. When the client supports roots, the server
+// asks for them after initialization and whenever they change.
+type StdioServer struct {
+	name         string
+	version      string
+	handler      handler.ToolHandler
+	rootsHandler func(paths []string)
+	logger       Logger
+	clientRoots  bool // The client declared the roots capability
+
+	in      *bufio.Reader
+	out     io.Writer
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	inFlight map[string]context.CancelFunc       // Running tool calls by request ID
+	pending  map[string]func(msg jsonRPCMessage) // Requests sent to the client, awaiting responses
+	nextID   int64
+	calls    sync.WaitGroup
+}
+
+// NewStdioServer creates an MCP server reading requests from in and writing messages to out
+func NewStdioServer(name, version string, in io.Reader, out io.Writer, logger Logger) *StdioServer {
+	return &StdioServer{
+		name:     name,
+		version:  version,
+		logger:   logger,
+		in:       bufio.NewReader(in),
+		out:      out,
+		inFlight: make(map[string]context.CancelFunc),
+		pending:  make(map[string]func(msg jsonRPCMessage)),
+	}
+}
+
+// Handle sets the handler that lists and executes the tools
+func (s *StdioServer) Handle(h handler.ToolHandler) {
+	s.handler = h
+}
+
+// HandleRoots sets the function that receives the directories of the client's roots. It is
+// called from the read loop, so it must not block.
+func (s *StdioServer) HandleRoots(h func(paths []string)) {
+	s.rootsHandler = h
+}
+
+// Run reads and dispatches messages until the input ends or ctx is done, then cancels the
+// tool calls still running and waits for them
+func (s *StdioServer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.calls.Wait()
+	}()
+
+	for {
+		line, err := s.in.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			s.dispatch(ctx, line)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read from the client: %w", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// dispatch handles one message from the client
+func (s *StdioServer) dispatch(ctx context.Context, line []byte) {
+	var msg jsonRPCMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		s.writeError(json.RawMessage("null"), jsonRPCParseError, fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+	if msg.JSONRPC != "2.0" {
+		if len(msg.ID) > 0 {
+			s.writeError(msg.ID, jsonRPCInvalidRequest, "only JSON-RPC 2.0 is supported")
+		}
+		return
+	}
+	if msg.Method == "" {
+		s.handleResponse(msg)
+		return
+	}
+	isNotification := len(msg.ID) == 0
+
+	switch msg.Method {
+	case "initialize":
+		s.write(jsonRPCResponse{JSONRPC: "2.0", ID: msg.ID, Result: s.initializeResult(msg.Params)})
+	case "ping":
+		s.write(jsonRPCResponse{JSONRPC: "2.0", ID: msg.ID, Result: struct{}{}})
+	case "tools/list":
+		resp, err := s.handler.ListTools(ctx)
+		if err != nil {
+			s.writeError(msg.ID, jsonRPCInternalError, err.Error())
+			return
+		}
+		s.write(jsonRPCResponse{JSONRPC: "2.0", ID: msg.ID, Result: resp})
+	case "tools/call":
+		s.startToolCall(ctx, msg)
+	case "notifications/initialized", "notifications/roots/list_changed":
+		s.requestRoots()
+	case "notifications/cancelled":
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.cancelToolCall(params.RequestID)
+		}
+	default:
+		if !isNotification {
+			s.writeError(msg.ID, jsonRPCMethodNotFound, fmt.Sprintf("method not found: %s", msg.Method))
+		}
+	}
+}
+
+// initializeResult answers the initialize request, agreeing on the client's protocol version when
+// supported, and notes whether the client can list its roots
+func (s *StdioServer) initializeResult(params json.RawMessage) interface{} {
+	var request struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		Capabilities    struct {
+			Roots *struct{} `json:"roots"`
+		} `json:"capabilities"`
+	}
+	_ = json.Unmarshal(params, &request)
+	s.clientRoots = request.Capabilities.Roots != nil
+
+	version := supportedProtocolVersions[0]
+	for _, supported := range supportedProtocolVersions {
+		if request.ProtocolVersion == supported {
+			version = supported
+		}
+	}
+	return map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools": map[string]interface{}{},
+		},
+		"serverInfo": map[string]string{
+			"name":    s.name,
+			"version": s.version,
+		},
+	}
+}
+
+// startToolCall runs a tools/call request in its own goroutine so that long calls do not block others
+func (s *StdioServer) startToolCall(ctx context.Context, msg jsonRPCMessage) {
+	var params callToolParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil || params.Name == "" {
+		s.writeError(msg.ID, jsonRPCInvalidParams, "tools/call requires a tool name and object arguments")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if token := params.Meta.ProgressToken; len(token) > 0 && string(token) != "null" {
+		ctx = context.WithValue(ctx, progressTokenKey, token)
+	}
+	id := string(msg.ID)
+	s.mu.Lock()
+	s.inFlight[id] = cancel
+	s.mu.Unlock()
+
+	s.calls.Add(1)
+	go func() {
+		defer s.calls.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.inFlight, id)
+			s.mu.Unlock()
+			cancel()
+		}()
+
+		resp, err := s.handler.CallTool(ctx, &protocol.CallToolRequest{Name: params.Name, Arguments: params.Arguments})
+		if ctx.Err() != nil {
+			// The client cancelled the call or went away, so it expects no response
+			return
+		}
+		if err != nil {
+			s.writeError(msg.ID, jsonRPCInternalError, err.Error())
+			return
+		}
+		s.write(jsonRPCResponse{JSONRPC: "2.0", ID: msg.ID, Result: resp})
+	}()
+}
+
+// cancelToolCall cancels a running tool call at the client's request
+func (s *StdioServer) cancelToolCall(id json.RawMessage) {
+	s.mu.Lock()
+	cancel, ok := s.inFlight[string(id)]
+	s.mu.Unlock()
+	if ok {
+		s.logger.Info("Client cancelled request %s", id)
+		cancel()
+	}
+}
+
+// requestRoots asks the client for its roots if it supports them and a roots handler is set
+func (s *StdioServer) requestRoots() {
+	if !s.clientRoots || s.rootsHandler == nil {
+		return
+	}
+	s.request("roots/list", nil, func(msg jsonRPCMessage) {
+		if msg.Error != nil {
+			s.logger.Warn("Client failed to list its roots: %s", msg.Error.Message)
+			return
+		}
+		var result listRootsResult
+		if err := json.Unmarshal(msg.Result, &result); err != nil {
+			s.logger.Warn("Invalid roots/list result: %v", err)
+			return
+		}
+		var paths []string
+		for _, root := range result.Roots {
+			path, err := fileURIPath(root.URI)
+			if err != nil {
+				s.logger.Warn("Ignoring client root %s: %v", root.URI, err)
+				continue
+			}
+			paths = append(paths, path)
+		}
+		s.rootsHandler(paths)
+	})
+}
+
+// fileURIPath converts a file:// URI to a local path
+func fileURIPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("only file:// roots are supported")
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("roots on other hosts are not supported")
+	}
+	if u.Path == "" {
+		return "", fmt.Errorf("the URI has no path")
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// request sends a request to the client. onResponse is called from the read loop with the response.
+func (s *StdioServer) request(method string, params interface{}, onResponse func(msg jsonRPCMessage)) error {
+	s.mu.Lock()
+	s.nextID++
+	id := json.RawMessage(strconv.FormatInt(s.nextID, 10))
+	s.pending[string(id)] = onResponse
+	s.mu.Unlock()
+
+	if err := s.write(jsonRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params}); err != nil {
+		s.mu.Lock()
+		delete(s.pending, string(id))
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// handleResponse passes a response from the client to the request that is waiting for it
+func (s *StdioServer) handleResponse(msg jsonRPCMessage) {
+	s.mu.Lock()
+	onResponse, ok := s.pending[string(msg.ID)]
+	delete(s.pending, string(msg.ID))
+	s.mu.Unlock()
+	if !ok {
+		s.logger.Warn("Ignoring response to unknown request %s", msg.ID)
+		return
+	}
+	onResponse(msg)
+}
+
+// NotifyProgress implements the ProgressNotifier interface
+func (s *StdioServer) NotifyProgress(token interface{}, progress int, message string) error {
+	return s.write(jsonRPCNotification{
+		JSONRPC: "2.0",
+		Method:  "notifications/progress",
+		Params: progressParams{
+			ProgressToken: token,
+			Progress:      progress,
+			Message:       message,
+		},
+	})
+}
+
+// writeError sends a JSON-RPC error response
+func (s *StdioServer) writeError(id json.RawMessage, code int, message string) {
+	s.write(jsonRPCResponse{JSONRPC: "2.0", ID: id, Error: &jsonRPCError{Code: code, Message: message}})
+}
+
+// write sends one message as a single line; the lock keeps concurrent messages from interleaving
+func (s *StdioServer) write(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Error("Failed to encode message: %v", err)
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := s.out.Write(append(data, '\n')); err != nil {
+		s.logger.Error("Failed to write message: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomcpgo/mcp/pkg/protocol"
+)
+
+// fakeToolHandler answers tool calls after sending progress through the server
+type fakeToolHandler struct {
+	server  *StdioServer
+	started chan string
+}
+
+func (h *fakeToolHandler) ListTools(ctx context.Context) (*protocol.ListToolsResponse, error) {
+	return &protocol.ListToolsResponse{Tools: []protocol.Tool{{Name: "echo"}}}, nil
+}
+
+func (h *fakeToolHandler) CallTool(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	if h.started != nil {
+		h.started <- req.Name
+	}
+	if req.Name == "block" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if token := progressTokenFromContext(ctx); token != nil {
+		for i := 1; i <= 20; i++ {
+			h.server.NotifyProgress(token, i, strings.Repeat("p", 100))
+		}
+	}
+	text, _ := req.Arguments["text"].(string)
+	return &protocol.CallToolResponse{Content: []protocol.ToolContent{{Type: "text", Text: text}}}, nil
+}
+
+// startTestServer runs a StdioServer over pipes and returns a writer for requests and a
+// channel of the messages the server sends
+func startTestServer(t *testing.T, started chan string, options ...func(*StdioServer)) (io.WriteCloser, <-chan map[string]json.RawMessage) {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	srv := NewStdioServer("test", "0.1", inR, outW, NewLogger(LevelError))
+	srv.Handle(&fakeToolHandler{server: srv, started: started})
+	for _, option := range options {
+		option(srv)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Run(context.Background()); err != nil {
+			t.Errorf("Run: %v", err)
+		}
+		outW.Close()
+	}()
+	t.Cleanup(func() {
+		inW.Close()
+		<-done
+	})
+
+	messages := make(chan map[string]json.RawMessage, 100)
+	go func() {
+		defer close(messages)
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			var msg map[string]json.RawMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				t.Errorf("server wrote an invalid line %q: %v", scanner.Text(), err)
+				continue
+			}
+			messages <- msg
+		}
+	}()
+	return inW, messages
+}
+
+// nextMessage waits for the next message from the server
+func nextMessage(t *testing.T, messages <-chan map[string]json.RawMessage) map[string]json.RawMessage {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message from the server")
+		return nil
+	}
+}
+
+func TestStdioServerRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		want    string // Substring of the response
+	}{
+		{"initialize", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`, `"protocolVersion":"2024-11-05"`},
+		{"unknown version", `{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`, `"protocolVersion":"2025-03-26"`},
+		{"ping", `{"jsonrpc":"2.0","id":3,"method":"ping"}`, `"result":{}`},
+		{"tools/list", `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`, `"name":"echo"`},
+		{"tools/call", `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`, `"text":"hi"`},
+		{"missing tool name", `{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{}}`, `"code":-32602`},
+		{"unknown method", `{"jsonrpc":"2.0","id":7,"method":"resources/list"}`, `"code":-32601`},
+		{"invalid JSON", `{"jsonrpc":`, `"code":-32700`},
+	}
+
+	in, messages := startTestServer(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			io.WriteString(in, tt.request+"\n")
+			msg := nextMessage(t, messages)
+			raw, _ := json.Marshal(msg)
+			if !strings.Contains(string(raw), tt.want) {
+				t.Errorf("response %s does not contain %s", raw, tt.want)
+			}
+		})
+	}
+
+	// Notifications get no response
+	io.WriteString(in, `{"jsonrpc":"2.0","method":"notifications/initialized"}`+"\n")
+	io.WriteString(in, `{"jsonrpc":"2.0","id":8,"method":"ping"}`+"\n")
+	if msg := nextMessage(t, messages); string(msg["id"]) != "8" {
+		t.Errorf("expected the ping response, got %v", msg)
+	}
+}
+
+func TestStdioServerProgressFromMeta(t *testing.T) {
+	in, messages := startTestServer(t, nil)
+
+	// Concurrent calls writing progress must produce whole, valid lines
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2", "3"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			io.WriteString(in, `{"jsonrpc":"2.0","id":`+id+`,"method":"tools/call","params":{"name":"echo","arguments":{"text":"r`+id+`"},"_meta":{"progressToken":"tok-`+id+`"}}}`+"\n")
+		}(id)
+	}
+	wg.Wait()
+
+	progress := map[string]int{}
+	responses := 0
+	for responses < 3 {
+		msg := nextMessage(t, messages)
+		if method := string(msg["method"]); method == `"notifications/progress"` {
+			var params progressParams
+			json.Unmarshal(msg["params"], &params)
+			progress[params.ProgressToken.(string)]++
+			continue
+		}
+		responses++
+		var result protocol.CallToolResponse
+		json.Unmarshal(msg["result"], &result)
+		if want := "r" + string(msg["id"]); len(result.Content) != 1 || result.Content[0].Text != want {
+			t.Errorf("response to %s = %+v, want %s", msg["id"], result, want)
+		}
+	}
+	for _, token := range []string{"tok-1", "tok-2", "tok-3"} {
+		if progress[token] != 20 {
+			t.Errorf("%s: %d progress notifications, want 20", token, progress[token])
+		}
+	}
+}
+
+func TestStdioServerCancel(t *testing.T) {
+	started := make(chan string, 1)
+	in, messages := startTestServer(t, started)
+
+	io.WriteString(in, `{"jsonrpc":"2.0","id":"a","method":"tools/call","params":{"name":"block","arguments":{}}}`+"\n")
+	<-started
+	io.WriteString(in, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"a"}}`+"\n")
+	io.WriteString(in, `{"jsonrpc":"2.0","id":"b","method":"ping"}`+"\n")
+
+	// The cancelled call sends no response, so the next message answers the ping
+	if msg := nextMessage(t, messages); string(msg["id"]) != `"b"` {
+		t.Errorf("expected only the ping response, got %v", msg)
+	}
+}
+
+func TestStdioServerRoots(t *testing.T) {
+	received := make(chan []string, 4)
+	in, messages := startTestServer(t, nil, func(srv *StdioServer) {
+		srv.HandleRoots(func(paths []string) { received <- paths })
+	})
+
+	io.WriteString(in, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{"roots":{"listChanged":true}}}}`+"\n")
+	nextMessage(t, messages)
+	io.WriteString(in, `{"jsonrpc":"2.0","method":"notifications/initialized"}`+"\n")
+
+	tests := []struct {
+		name   string
+		result string
+		want   []string
+	}{
+		{"file roots", `{"roots":[{"uri":"file:///home/dev/project","name":"project"},{"uri":"file://localhost/srv/my%20app"}]}`,
+			[]string{filepath.FromSlash("/home/dev/project"), filepath.FromSlash("/srv/my app")}},
+		{"non-file roots are ignored", `{"roots":[{"uri":"https://example.com/repo"},{"uri":"file://other-host/x"},{"uri":"file:///ok"}]}`,
+			[]string{filepath.FromSlash("/ok")}},
+		{"no roots", `{"roots":[]}`, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if i > 0 {
+				io.WriteString(in, `{"jsonrpc":"2.0","method":"notifications/roots/list_changed"}`+"\n")
+			}
+			request := nextMessage(t, messages)
+			if string(request["method"]) != `"roots/list"` {
+				t.Fatalf("expected a roots/list request, got %v", request)
+			}
+			io.WriteString(in, `{"jsonrpc":"2.0","id":`+string(request["id"])+`,"result":`+tt.result+`}`+"\n")
+
+			select {
+			case paths := <-received:
+				if strings.Join(paths, ",") != strings.Join(tt.want, ",") {
+					t.Errorf("roots = %v, want %v", paths, tt.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("roots handler not called")
+			}
+		})
+	}
+}
+
+func TestStdioServerNoRootsCapability(t *testing.T) {
+	in, messages := startTestServer(t, nil, func(srv *StdioServer) {
+		srv.HandleRoots(func(paths []string) { t.Error("roots handler called for a client without roots") })
+	})
+
+	io.WriteString(in, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`+"\n")
+	nextMessage(t, messages)
+	io.WriteString(in, `{"jsonrpc":"2.0","method":"notifications/initialized"}`+"\n")
+	io.WriteString(in, `{"jsonrpc":"2.0","id":2,"method":"ping"}`+"\n")
+	if msg := nextMessage(t, messages); string(msg["id"]) != "2" {
+		t.Errorf("expected the ping response and no roots/list request, got %v", msg)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Workspace restricts file access to a set of root directories. Paths are checked after
+// symlinks are resolved, so a link inside a root cannot be used to reach files outside it.
+// Roots configured explicitly are fixed; otherwise the client's MCP roots replace the default.
+type Workspace struct {
+	mu          sync.RWMutex
+	roots       []string
+	defaultRoot []string // Roots used while the client provides none
+	fixed       bool     // Roots were configured explicitly, so the client's roots are ignored
+}
+
+// NewWorkspace creates a workspace from the configured root directories. When none are configured,
+// defaultRoot is used until the client provides its roots; an empty defaultRoot leaves the
+// workspace without roots, so every path is rejected until then.
+// Relative roots are resolved against the current working directory.
+func NewWorkspace(roots []string, defaultRoot string) (*Workspace, error) {
+	configured, err := cleanRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	if len(configured) > 0 {
+		return &Workspace{roots: configured, fixed: true}, nil
+	}
+
+	fallback, err := cleanRoots([]string{defaultRoot})
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{roots: fallback, defaultRoot: fallback}, nil
+}
+
+// cleanRoots converts root directories to clean absolute paths with symlinks resolved, skipping empty ones
+func cleanRoots(roots []string) ([]string, error) {
+	var cleaned []string
+	for _, root := range roots {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace root %s: %w", root, err)
+		}
+		// Compare resolved paths, e.g. a root under /tmp on macOS is really under /private/tmp
+		if resolvedRoot, err := filepath.EvalSymlinks(absRoot); err == nil {
+			absRoot = resolvedRoot
+		}
+		cleaned = append(cleaned, filepath.Clean(absRoot))
+	}
+	return cleaned, nil
+}
+
+// isBroadRoot reports whether dir is the filesystem root or the home directory, which grant
+// access to far more than a project and are therefore never used as an implicit root
+func isBroadRoot(dir string) bool {
+	cleaned, err := cleanRoots([]string{dir})
+	if err != nil || len(cleaned) == 0 {
+		return true
+	}
+	if filepath.Dir(cleaned[0]) == cleaned[0] {
+		return true
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if homeRoot, err := cleanRoots([]string{home}); err == nil && len(homeRoot) > 0 && homeRoot[0] == cleaned[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// SetClientRoots replaces the roots with the directories of the client's MCP roots, unless roots
+// were configured explicitly. An empty list restores the default root. It reports whether the
+// roots were replaced.
+func (w *Workspace) SetClientRoots(roots []string) (bool, error) {
+	if w.fixed {
+		return false, nil
+	}
+	cleaned, err := cleanRoots(roots)
+	if err != nil {
+		return false, err
+	}
+	if len(cleaned) == 0 {
+		cleaned = w.defaultRoot
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.roots = cleaned
+	return true, nil
+}
+
+// Roots returns the workspace root directories
+func (w *Workspace) Roots() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]string(nil), w.roots...)
+}
+
+// Resolve converts a path to an absolute path with symlinks resolved and verifies it lies inside
+// a workspace root. Relative paths are resolved against the first root and paths containing `..`
+// are rejected outright. Paths that do not exist yet are checked through their nearest existing parent.
+func (w *Workspace) Resolve(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("path must not be empty")
+	}
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == os.PathSeparator }) {
+		if segment == ".." {
+			return "", fmt.Errorf("path %s contains '..', which is not allowed", path)
+		}
+	}
+
+	roots := w.Roots()
+	if len(roots) == 0 {
+		return "", fmt.Errorf("no workspace roots are set; set DEEPSEEK_WORKSPACE_ROOTS or use an MCP client that provides roots")
+	}
+
+	absPath := path
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(roots[0], absPath)
+	}
+	absPath = filepath.Clean(absPath)
+
+	resolved, err := evalExistingSymlinks(absPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path %s: %w", path, err)
+	}
+
+	if !rootsContain(roots, resolved) {
+		if resolved != absPath && rootsContain(roots, absPath) {
+			return "", fmt.Errorf("path %s is a symlink to %s, which is outside the workspace roots (%s)",
+				path, resolved, strings.Join(roots, ", "))
+		}
+		return "", fmt.Errorf("path %s is outside the workspace roots (%s); add its directory to DEEPSEEK_WORKSPACE_ROOTS to allow it",
+			path, strings.Join(roots, ", "))
+	}
+	return resolved, nil
+}
+
+// evalExistingSymlinks resolves the symlinks in a clean absolute path. When the path does not
+// exist, its nearest existing parent is resolved and the remaining components are appended.
+func evalExistingSymlinks(absPath string) (string, error) {
+	var missing []string
+	current := absPath
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return absPath, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
+
+// RelPath returns the path relative to the workspace root containing it, for display
+func (w *Workspace) RelPath(absPath string) string {
+	for _, root := range w.Roots() {
+		if rel, err := filepath.Rel(root, absPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return absPath
+}
+
+// rootsContain reports whether a clean absolute path is equal to or below one of the roots
+func rootsContain(roots []string, absPath string) bool {
+	for _, root := range roots {
+		prefix := root
+		if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+			prefix += string(os.PathSeparator)
+		}
+		if absPath == root || strings.HasPrefix(absPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
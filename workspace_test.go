@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWorkspace creates a workspace rooted at a temporary directory holding a file, a
+// subdirectory, a symlink inside the root and one pointing outside it
+func testWorkspace(t *testing.T) (*Workspace, string, string) {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{filepath.Join(root, "a.go"), filepath.Join(root, "sub", "b.go"), filepath.Join(outside, "secret.txt")} {
+		if err := os.WriteFile(file, []byte("package x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "a.go"), filepath.Join(root, "inside-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := NewWorkspace([]string{root}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws, root, outside
+}
+
+func TestWorkspaceResolve(t *testing.T) {
+	ws, root, outside := testWorkspace(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string // Resolved path, when no error is expected
+		wantErr string
+	}{
+		{"relative file", "a.go", filepath.Join(root, "a.go"), ""},
+		{"nested file", "sub/b.go", filepath.Join(root, "sub", "b.go"), ""},
+		{"absolute file", filepath.Join(root, "a.go"), filepath.Join(root, "a.go"), ""},
+		{"root itself", root, root, ""},
+		{"missing file", "sub/new.go", filepath.Join(root, "sub", "new.go"), ""},
+		{"symlink inside root", "inside-link", filepath.Join(root, "a.go"), ""},
+		{"empty", "", "", "must not be empty"},
+		{"dot dot", "sub/../a.go", "", "contains '..'"},
+		{"leading dot dot", "../x", "", "contains '..'"},
+		{"absolute outside", filepath.Join(outside, "secret.txt"), "", "outside the workspace roots"},
+		{"symlink escaping root", "escape/secret.txt", "", "is a symlink to"},
+		{"missing file below escaping symlink", "escape/new.txt", "", "is a symlink to"},
+		{"sibling with root prefix", root + "-other/file", "", "outside the workspace roots"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ws.Resolve(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve(%q) error = %v, want one containing %q", tt.path, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Resolve(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkspaceRelPath(t *testing.T) {
+	ws, root, outside := testWorkspace(t)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(root, "sub", "b.go"), "sub/b.go"},
+		{root, "."},
+		{filepath.Join(outside, "secret.txt"), filepath.Join(outside, "secret.txt")},
+	}
+	for _, tt := range tests {
+		if got := ws.RelPath(tt.path); got != tt.want {
+			t.Errorf("RelPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWorkspaceClientRoots(t *testing.T) {
+	configuredRoot := t.TempDir()
+	defaultRoot, _ := filepath.EvalSymlinks(t.TempDir())
+	clientRoot, _ := filepath.EvalSymlinks(t.TempDir())
+
+	tests := []struct {
+		name         string
+		configured   []string
+		defaultRoot  string
+		clientRoots  []string
+		wantReplaced bool
+		wantRoots    []string
+	}{
+		{"client roots replace the default", nil, defaultRoot, []string{clientRoot}, true, []string{clientRoot}},
+		{"client roots without a default", nil, "", []string{clientRoot}, true, []string{clientRoot}},
+		{"empty client roots restore the default", nil, defaultRoot, nil, true, []string{defaultRoot}},
+		{"configured roots win", []string{configuredRoot}, "", []string{clientRoot}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, err := NewWorkspace(tt.configured, tt.defaultRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := ws.Roots()
+			replaced, err := ws.SetClientRoots(tt.clientRoots)
+			if err != nil || replaced != tt.wantReplaced {
+				t.Fatalf("SetClientRoots = %v, %v; want %v", replaced, err, tt.wantReplaced)
+			}
+			want := tt.wantRoots
+			if !replaced {
+				want = before
+			}
+			if got := ws.Roots(); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("roots = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWorkspaceWithoutRoots(t *testing.T) {
+	ws, err := NewWorkspace(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Resolve("a.go"); err == nil || !strings.Contains(err.Error(), "no workspace roots") {
+		t.Errorf("Resolve without roots = %v, want an error asking for roots", err)
+	}
+}
+
+func TestIsBroadRoot(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"/", true},
+		{home, true},
+		{home + string(os.PathSeparator), true},
+		{t.TempDir(), false},
+	}
+	for _, tt := range tests {
+		if got := isBroadRoot(tt.dir); got != tt.want {
+			t.Errorf("isBroadRoot(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}